@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"strings"
 
 	"github.com/bodgit/sevenzip"
@@ -22,17 +21,51 @@ func init() {
 
 type SevenZip struct {
 	// If true, errors encountered during reading or writing
-	// a file within an archive will be logged and the
-	// operation will continue on remaining files.
+	// a file within an archive will be skipped and the
+	// operation will continue on remaining files. The errors
+	// are returned together as a *SkippedEntriesError when
+	// the operation completes.
 	ContinueOnError bool
 
+	// If set, OnError is called with each error encountered
+	// during reading or writing a file within an archive,
+	// along with the name of the file. If it returns nil, the
+	// file is skipped and the operation continues, and the
+	// error is included in the *SkippedEntriesError returned
+	// when the operation completes; otherwise the operation
+	// stops and returns OnError's error. OnError takes
+	// precedence over ContinueOnError. Context cancellation and
+	// exceeded Limits always stop the operation.
+	OnError func(entry string, err error) error
+
 	// The password, if dealing with an encrypted archive.
+	// When archiving, the contents of files are encrypted
+	// with AES-256 if a password is set.
 	Password string
+
+	// If true, files are compressed together as one stream
+	// when archiving (a "solid" archive). This usually
+	// compresses better, but extracting a file requires
+	// decompressing all the files before it. If a file fails
+	// after some of its contents were written to the stream,
+	// the operation stops even if errors are being skipped.
+	Solid bool
+
+	// If true and Password is set, the list of files and
+	// their metadata are also encrypted when archiving, so
+	// the password is needed to list the archive's contents.
+	EncryptHeaders bool
+
+	// Limits on the resources that may be consumed while
+	// extracting, to defend against decompression bombs.
+	Limits Limits
 }
 
 func (SevenZip) Extension() string { return ".7z" }
 func (SevenZip) MediaType() string { return "application/x-7z-compressed" }
 
+func (z SevenZip) resourceLimits() Limits { return z.Limits }
+
 func (z SevenZip) Match(_ context.Context, filename string, stream io.Reader) (MatchResult, error) {
 	var mr MatchResult
 
@@ -51,7 +84,121 @@ func (z SevenZip) Match(_ context.Context, filename string, stream io.Reader) (M
 	return mr, nil
 }
 
-// Archive is not implemented for 7z because I do not know of a pure-Go 7z writer.
+// Archive writes a 7z archive of the files to output, compressed with LZMA2.
+// The start of a 7z archive points to the list of files at its end, so if
+// output is an io.WriteSeeker, Archive seeks back to write it; otherwise, the
+// compressed files are written to a temporary file until the end.
+func (z SevenZip) Archive(ctx context.Context, output io.Writer, files []FileInfo) error {
+	zw, err := newSevenZipWriter(output, z.Solid, z.Password, z.EncryptHeaders)
+	if err != nil {
+		return err
+	}
+	defer zw.abort()
+
+	ctx, p := startProgress(ctx)
+	p.setTotalsFromFiles(files)
+
+	errs := z.entryErrorHandler()
+	for i, file := range files {
+		p.start(file)
+		err := z.archiveOneFile(ctx, zw, i, file)
+		p.finish(err)
+		if err != nil {
+			if err := errs.handle(ctx, file.NameInArchive, err); err != nil {
+				return err
+			}
+			if zw.err != nil {
+				return zw.err
+			}
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return errs.err()
+}
+
+// ArchiveAsync is like Archive, but it reads the files to archive from jobs.
+// Like Archive, it needs a temporary file unless output is an io.WriteSeeker.
+func (z SevenZip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan ArchiveAsyncJob) error {
+	zw, err := newSevenZipWriter(output, z.Solid, z.Password, z.EncryptHeaders)
+	if err != nil {
+		return err
+	}
+	defer zw.abort()
+
+	ctx, p := startProgress(ctx)
+
+	errs := z.entryErrorHandler()
+	var abortErr error
+	var i int
+	for job := range jobs {
+		if abortErr != nil {
+			// keep receiving jobs so senders don't block, but don't write them
+			job.Result <- abortErr
+			continue
+		}
+		p.start(job.File)
+		err := z.archiveOneFile(ctx, zw, i, job.File)
+		p.finish(err)
+		job.Result <- err
+		if err != nil {
+			abortErr = errs.handle(ctx, job.File.NameInArchive, err)
+			if abortErr == nil {
+				abortErr = zw.err
+			}
+		}
+		i++
+	}
+	if abortErr != nil {
+		return abortErr
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return errs.err()
+}
+
+func (z SevenZip) entryErrorHandler() *entryErrorHandler {
+	return &entryErrorHandler{continueOnError: z.ContinueOnError, onError: z.OnError}
+}
+
+func (z SevenZip) archiveOneFile(ctx context.Context, zw *sevenZipWriter, idx int, file FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err // honor context cancellation
+	}
+
+	name := file.NameInArchive
+	if name == "" {
+		name = file.Name() // assume base name of file I guess
+	}
+	w, err := zw.create(sevenZipEntry{
+		name:       strings.TrimSuffix(name, "/"), // 7z identifies directories by their attributes
+		isDir:      file.IsDir(),
+		modTime:    file.ModTime(),
+		attributes: sevenZipAttributes(file.Mode()),
+	})
+	if err != nil {
+		return fmt.Errorf("creating entry for file %d: %s: %w", idx, file.Name(), err)
+	}
+
+	// file won't be considered a symlink if FollowSymlinks in FilesFromDisk is true
+	if isSymlink(file) {
+		_, err = w.Write([]byte(file.LinkTarget))
+	} else if !file.IsDir() {
+		err = openAndCopyFile(ctx, file, w)
+	}
+	if err != nil {
+		return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), w.cancel(err))
+	}
+
+	if err := w.close(); err != nil {
+		return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), err)
+	}
+	return nil
+}
 
 // Extract extracts files from z, implementing the Extractor interface. Uniquely, however,
 // sourceArchive must be an io.ReaderAt and io.Seeker, which are oddly disjoint interfaces
@@ -72,8 +219,13 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 
 	zr, err := sevenzip.NewReaderWithPassword(sra, size, z.Password)
 	if err != nil {
-		return err
+		return classifySevenZipError("", z.Password, err)
 	}
+	handleFile = z.Limits.wrapHandler(handleFile)
+	ctx, p := startProgress(ctx)
+	p.setTotals(len(zr.File), sevenZipTotalSize(zr.File))
+	handleFile = p.wrapHandler(handleFile)
+	errs := z.entryErrorHandler()
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -87,19 +239,7 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 			continue
 		}
 
-		fi := f.FileInfo()
-		file := FileInfo{
-			FileInfo:      fi,
-			Header:        f.FileHeader,
-			NameInArchive: f.Name,
-			Open: func() (fs.File, error) {
-				openedFile, err := f.Open()
-				if err != nil {
-					return nil, err
-				}
-				return fileInArchive{openedFile, fi}, nil
-			},
-		}
+		file := sevenZipFileInfo(ctx, f, z.Password)
 
 		err := handleFile(ctx, file)
 		if errors.Is(err, fs.SkipAll) {
@@ -107,19 +247,98 @@ func (z SevenZip) Extract(ctx context.Context, sourceArchive io.Reader, handleFi
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
 			skipDirs.add(f.Name)
 		} else if err != nil {
-			if z.ContinueOnError {
-				log.Printf("[ERROR] %s: %v", f.Name, err)
-				continue
+			if err := errs.handle(ctx, f.Name, err); err != nil {
+				return fmt.Errorf("handling file %d: %s: %w", i, f.Name, err)
 			}
-			return fmt.Errorf("handling file %d: %s: %w", i, f.Name, err)
 		}
 	}
 
-	return nil
+	return errs.err()
+}
+
+// OpenArchiveReader returns a reader that iterates the entries of the 7z archive.
+// Like Extract, sourceArchive must be an io.ReaderAt and io.Seeker.
+func (z SevenZip) OpenArchiveReader(ctx context.Context, sourceArchive io.Reader) (ArchiveReader, error) {
+	sra, ok := sourceArchive.(seekReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("input type must be an io.ReaderAt and io.Seeker because of 7z format constraints")
+	}
+
+	size, err := streamSizeBySeeking(sra)
+	if err != nil {
+		return nil, fmt.Errorf("determining stream size: %w", err)
+	}
+
+	zr, err := sevenzip.NewReaderWithPassword(sra, size, z.Password)
+	if err != nil {
+		return nil, classifySevenZipError("", z.Password, err)
+	}
+
+	return &sevenZipArchiveReader{ctx: ctx, files: zr.File, password: z.Password, limits: z.Limits.newState()}, nil
+}
+
+// sevenZipArchiveReader implements ArchiveReader for 7z archives.
+type sevenZipArchiveReader struct {
+	ctx      context.Context
+	files    []*sevenzip.File
+	password string
+	limits   *limitState
+}
+
+func (r *sevenZipArchiveReader) Next() (FileInfo, error) {
+	if err := r.ctx.Err(); err != nil {
+		return FileInfo{}, err // honor context cancellation
+	}
+	if len(r.files) == 0 {
+		return FileInfo{}, io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+	return r.limits.entry(sevenZipFileInfo(r.ctx, f, r.password))
+}
+
+func (*sevenZipArchiveReader) Close() error { return nil }
+
+// sevenZipFileInfo returns a FileInfo for the 7z entry f, which was
+// decrypted with password, if any. Reads from the opened file honor
+// ctx cancellation.
+func sevenZipFileInfo(ctx context.Context, f *sevenzip.File, password string) FileInfo {
+	fi := f.FileInfo()
+	return FileInfo{
+		FileInfo:      fi,
+		Header:        f.FileHeader,
+		NameInArchive: f.Name,
+		Open: func() (fs.File, error) {
+			openedFile, err := f.Open()
+			if err != nil {
+				return nil, classifySevenZipError(f.Name, password, err)
+			}
+			classify := func(entry string, err error) error {
+				return classifySevenZipError(entry, password, err)
+			}
+			return fileInArchive{classifyingReader{openedFile, f.Name, classify}, fi, ctx}, nil
+		},
+	}
+}
+
+// sevenZipTotalSize returns the combined size of the regular files.
+func sevenZipTotalSize(files []*sevenzip.File) int64 {
+	var size int64
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			size += int64(f.UncompressedSize)
+		}
+	}
+	return size
 }
 
 // https://py7zr.readthedocs.io/en/latest/archive_format.html#signature
 var sevenZipHeader = []byte("7z\xBC\xAF\x27\x1C")
 
-// Interface guard
-var _ Extractor = SevenZip{}
+// Interface guards
+var (
+	_ Archiver            = SevenZip{}
+	_ ArchiverAsync       = SevenZip{}
+	_ Extractor           = SevenZip{}
+	_ ArchiveReaderOpener = SevenZip{}
+)
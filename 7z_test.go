@@ -0,0 +1,184 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSevenZipArchive(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	big := bytes.Repeat([]byte("compress me, compress me not\n"), 10000)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, contents := range map[string][]byte{
+		"a.txt":         []byte("first"),
+		"sub/big.txt":   big,
+		"sub/empty.txt": nil,
+		"sub/c.txt":     []byte("third"),
+	} {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, contents, 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := FilesFromDisk(ctx, nil, map[string]string{dir + string(filepath.Separator): ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		format SevenZip
+	}{
+		{name: "non-solid", format: SevenZip{}},
+		{name: "solid", format: SevenZip{Solid: true}},
+		{name: "encrypted", format: SevenZip{Password: "hunter2"}},
+		{name: "encrypted solid with headers", format: SevenZip{Password: "hunter2", Solid: true, EncryptHeaders: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := tc.format.Archive(ctx, buf, files); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]FileInfo)
+			contents := make(map[string][]byte)
+			err := tc.format.Extract(ctx, bytes.NewReader(buf.Bytes()), func(_ context.Context, f FileInfo) error {
+				got[f.NameInArchive] = f
+				if f.IsDir() {
+					return nil
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				contents[f.NameInArchive], err = io.ReadAll(rc)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(files) {
+				t.Errorf("expected %d entries, got %d", len(files), len(got))
+			}
+			if f, ok := got["sub/"]; !ok || !f.IsDir() {
+				t.Errorf("expected directory sub/, got %v", f)
+			}
+			if !bytes.Equal(contents["sub/big.txt"], big) || string(contents["a.txt"]) != "first" || len(contents["sub/empty.txt"]) != 0 {
+				t.Error("extracted contents do not match")
+			}
+			if f := got["a.txt"]; !f.ModTime().Equal(mtime) || f.Mode().Perm() != 0o640 {
+				t.Errorf("expected mtime %s and mode 0640, got %s and %s", mtime, f.ModTime(), f.Mode())
+			}
+
+			if tc.format.Password != "" {
+				noPassword := SevenZip{}
+				err := readAllEntries(noPassword, buf.Bytes())
+				if !errors.Is(err, ErrEncrypted) {
+					t.Errorf("expected ErrEncrypted without a password, got: %v", err)
+				}
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		if err := (SevenZip{}).Archive(ctx, buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := readAllEntries(SevenZip{}, buf.Bytes()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("seekable output", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "test.7z"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString("prefix"); err != nil {
+			t.Fatal(err)
+		}
+		if err := (SevenZip{}).Archive(ctx, f, files); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteString("suffix"); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		archive := bytes.TrimSuffix(bytes.TrimPrefix(data, []byte("prefix")), []byte("suffix"))
+		if err := readAllEntries(SevenZip{}, archive); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("times outside the range of UnixNano", func(t *testing.T) {
+		var files []FileInfo
+		for name, mtime := range map[string]time.Time{
+			"future.txt": time.Date(2500, 1, 2, 3, 4, 5, 600, time.UTC),
+			"past.txt":   time.Date(1650, 1, 2, 3, 4, 5, 0, time.UTC),
+		} {
+			info := testFileInfo{name: name, mode: 0o644, mtime: mtime}
+			files = append(files, FileInfo{FileInfo: info, NameInArchive: name, Open: func() (fs.File, error) {
+				return fileInArchive{io.NopCloser(bytes.NewReader(nil)), info, nil}, nil
+			}})
+		}
+		buf := new(bytes.Buffer)
+		if err := (SevenZip{}).Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+
+		// the reader converts file times through nanoseconds too, so
+		// look for them in the header, which is stored as is
+		for _, fileTime := range []uint64{
+			283697966450000006, // 2500-01-02 03:04:05.0000006
+			15463982450000000,  // 1650-01-02 03:04:05
+		} {
+			if !bytes.Contains(buf.Bytes(), binary.LittleEndian.AppendUint64(nil, fileTime)) {
+				t.Errorf("expected file time %d in the header", fileTime)
+			}
+		}
+	})
+
+	t.Run("ArchiveAsync", func(t *testing.T) {
+		jobs := make(chan ArchiveAsyncJob)
+		go func() {
+			defer close(jobs)
+			for _, file := range files {
+				result := make(chan error, 1)
+				jobs <- ArchiveAsyncJob{File: file, Result: result}
+				if err := <-result; err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		buf := new(bytes.Buffer)
+		if err := (SevenZip{Solid: true}).ArchiveAsync(ctx, buf, jobs); err != nil {
+			t.Fatal(err)
+		}
+		if err := readAllEntries(SevenZip{}, buf.Bytes()); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,741 @@
+package archives
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// sevenZipWriter writes a 7z archive. The packed (compressed) streams are
+// written as files are added, and the header that describes them is written
+// when the writer is closed. The start header at the very beginning of the
+// archive points to the header, so it is written last: by seeking back if
+// the output is an io.WriteSeeker, or otherwise by writing the packed
+// streams to a temporary file first.
+//
+// Files are compressed with LZMA2. If a password is given, the compressed
+// streams (and optionally the header) are encrypted with AES-256 the way
+// 7-Zip does it. Each folder (7z's term for a chain of coders that produces
+// one stream of file contents) holds one file, or every file in solid mode.
+type sevenZipWriter struct {
+	output         io.Writer
+	seeker         io.WriteSeeker // set if seeking back to the start is possible
+	start          int64          // position of the start header, if seeking
+	tmp            *os.File       // the packed streams, if not seeking
+	out            io.Writer      // where the packed streams and header go
+	solid          bool
+	key            []byte // AES-256 key; nil if not encrypting
+	encryptHeaders bool
+
+	packed  int64 // number of bytes of packed streams written to out
+	entries []sevenZipEntry
+	folders []*sevenZipFolder
+	cur     *sevenZipFolderWriter // the open folder, if any
+
+	// set after a failure that leaves the archive unable to continue,
+	// like an error after part of a file was written to a solid folder
+	err error
+}
+
+// sevenZipEntry is a file in a 7z archive.
+type sevenZipEntry struct {
+	name       string
+	size       uint64
+	crc        uint32
+	hasStream  bool // false for directories and empty files
+	isDir      bool
+	modTime    time.Time
+	attributes uint32
+}
+
+// sevenZipFolder describes a folder that has been written.
+type sevenZipFolder struct {
+	aesProps   []byte // nil if not encrypted
+	dictCap    int
+	unpackSize uint64 // size of the uncompressed contents
+	codedSize  uint64 // size of the LZMA2 stream, before encryption
+	packSize   uint64 // size of the packed stream
+	numStreams int    // number of files in the folder
+	crc        uint32 // CRC of the contents, if hasCRC
+	hasCRC     bool
+}
+
+func newSevenZipWriter(output io.Writer, solid bool, password string, encryptHeaders bool) (*sevenZipWriter, error) {
+	zw := &sevenZipWriter{output: output, out: output, solid: solid}
+	if password != "" {
+		zw.key = sevenZipAESKey(password, nil, sevenZipAESCycles)
+		zw.encryptHeaders = encryptHeaders
+	}
+
+	// reserve room for the start header if we can seek back to fill it in;
+	// otherwise collect the packed streams in a temporary file until then
+	if ws, ok := output.(io.WriteSeeker); ok {
+		if start, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			zw.seeker, zw.start = ws, start
+			if _, err := output.Write(make([]byte, sevenZipStartHeaderSize)); err != nil {
+				return nil, fmt.Errorf("reserving start header: %w", err)
+			}
+			return zw, nil
+		}
+	}
+	tmp, err := os.CreateTemp("", "archives-7z-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file for packed streams: %w", err)
+	}
+	zw.tmp, zw.out = tmp, tmp
+	return zw, nil
+}
+
+// create adds an entry to the archive and returns a writer for its contents,
+// which must be closed (or canceled) before the next entry is created. The
+// entry's size, CRC, and whether it has a stream are set by the writer.
+func (zw *sevenZipWriter) create(entry sevenZipEntry) (*sevenZipFileWriter, error) {
+	if zw.err != nil {
+		return nil, zw.err
+	}
+	return &sevenZipFileWriter{zw: zw, entry: entry}, nil
+}
+
+// sevenZipFileWriter writes the contents of one entry.
+type sevenZipFileWriter struct {
+	zw    *sevenZipWriter
+	entry sevenZipEntry
+}
+
+func (fw *sevenZipFileWriter) Write(p []byte) (int, error) {
+	zw := fw.zw
+	if zw.err != nil {
+		return 0, zw.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if zw.cur == nil {
+		folder, err := zw.openFolder()
+		if err != nil {
+			zw.err = err
+			return 0, err
+		}
+		zw.cur = folder
+	}
+	n, err := zw.cur.Write(p)
+	fw.entry.size += uint64(n)
+	fw.entry.crc = crc32.Update(fw.entry.crc, crc32.IEEETable, p[:n])
+	if err != nil {
+		zw.err = err
+	}
+	return n, err
+}
+
+// close adds the entry to the archive.
+func (fw *sevenZipFileWriter) close() error {
+	zw := fw.zw
+	if zw.err != nil {
+		return zw.err
+	}
+	fw.entry.hasStream = fw.entry.size > 0
+	if fw.entry.hasStream {
+		zw.cur.folder.numStreams++
+		if !zw.solid {
+			if err := zw.closeFolder(); err != nil {
+				return err
+			}
+		}
+	}
+	zw.entries = append(zw.entries, fw.entry)
+	return nil
+}
+
+// cancel leaves the entry out of the archive. That is only possible if none
+// of its contents were written yet, since the contents of every file in a
+// folder are one stream; otherwise the archive cannot be finished, and
+// cancel returns the error that every later operation will return.
+func (fw *sevenZipFileWriter) cancel(cause error) error {
+	zw := fw.zw
+	if zw.err == nil && fw.entry.size > 0 {
+		zw.err = fmt.Errorf("7z: cannot continue after partially writing %s: %w", fw.entry.name, cause)
+	}
+	return zw.err
+}
+
+// sevenZipFolderWriter compresses, and maybe encrypts, one folder.
+type sevenZipFolderWriter struct {
+	folder *sevenZipFolder
+	lzma2  *lzma.Writer2
+	coded  *countingWriter // the LZMA2 stream
+	aes    *sevenZipAESWriter
+	packed *countingWriter // the packed stream
+	crc    uint32
+}
+
+func (zw *sevenZipWriter) openFolder() (*sevenZipFolderWriter, error) {
+	fw := &sevenZipFolderWriter{
+		folder: &sevenZipFolder{dictCap: sevenZipDictCap},
+		packed: &countingWriter{Writer: zw.out},
+	}
+	var w io.Writer = fw.packed
+	if zw.key != nil {
+		aesw, props, err := newSevenZipAESWriter(w, zw.key)
+		if err != nil {
+			return nil, err
+		}
+		fw.aes, fw.folder.aesProps = aesw, props
+		w = aesw
+	}
+	fw.coded = &countingWriter{Writer: w}
+	lzma2, err := lzma.Writer2Config{DictCap: sevenZipDictCap}.NewWriter2(fw.coded)
+	if err != nil {
+		return nil, fmt.Errorf("creating LZMA2 writer: %w", err)
+	}
+	fw.lzma2 = lzma2
+	return fw, nil
+}
+
+func (fw *sevenZipFolderWriter) Write(p []byte) (int, error) {
+	n, err := fw.lzma2.Write(p)
+	fw.folder.unpackSize += uint64(n)
+	fw.crc = crc32.Update(fw.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// close finishes the streams of the folder and returns its description.
+func (fw *sevenZipFolderWriter) close() (*sevenZipFolder, error) {
+	if err := fw.lzma2.Close(); err != nil {
+		return nil, fmt.Errorf("closing LZMA2 stream: %w", err)
+	}
+	if fw.aes != nil {
+		if err := fw.aes.Close(); err != nil {
+			return nil, fmt.Errorf("closing AES stream: %w", err)
+		}
+	}
+	fw.folder.codedSize = uint64(fw.coded.n.Load())
+	fw.folder.packSize = uint64(fw.packed.n.Load())
+	fw.folder.crc = fw.crc
+	return fw.folder, nil
+}
+
+func (zw *sevenZipWriter) closeFolder() error {
+	folder, err := zw.cur.close()
+	zw.cur = nil
+	if err != nil {
+		zw.err = err
+		return err
+	}
+	zw.folders = append(zw.folders, folder)
+	zw.packed += int64(folder.packSize)
+	return nil
+}
+
+// Close writes the header and the start header, completing the archive.
+// It does not close the output.
+func (zw *sevenZipWriter) Close() error {
+	defer zw.abort()
+	if zw.err != nil {
+		return zw.err
+	}
+	if zw.cur != nil {
+		if err := zw.closeFolder(); err != nil {
+			return err
+		}
+	}
+
+	header := zw.header()
+	if zw.encryptHeaders {
+		// the header is compressed and encrypted like a folder,
+		// then described by a small "encoded header" in its place
+		packPos := zw.packed
+		fw, err := zw.openFolder()
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(header); err != nil {
+			return fmt.Errorf("compressing header: %w", err)
+		}
+		folder, err := fw.close()
+		if err != nil {
+			return err
+		}
+		folder.hasCRC = true
+		zw.packed += int64(folder.packSize)
+
+		encoded := new(bytes.Buffer)
+		encoded.WriteByte(sevenZipIDEncodedHeader)
+		writeSevenZipStreamsInfo(encoded, uint64(packPos), []*sevenZipFolder{folder}, nil)
+		header = encoded.Bytes()
+	}
+	if _, err := zw.out.Write(header); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	startHeader := sevenZipStartHeader(uint64(zw.packed), header)
+	if zw.seeker != nil {
+		end := zw.start + sevenZipStartHeaderSize + zw.packed + int64(len(header))
+		if _, err := zw.seeker.Seek(zw.start, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking to start header: %w", err)
+		}
+		if _, err := zw.seeker.Write(startHeader); err != nil {
+			return fmt.Errorf("writing start header: %w", err)
+		}
+		if _, err := zw.seeker.Seek(end, io.SeekStart); err != nil {
+			return fmt.Errorf("seeking to end of archive: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := zw.output.Write(startHeader); err != nil {
+		return fmt.Errorf("writing start header: %w", err)
+	}
+	if _, err := zw.tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewinding temporary file: %w", err)
+	}
+	if _, err := io.Copy(zw.output, zw.tmp); err != nil {
+		return fmt.Errorf("copying packed streams: %w", err)
+	}
+	return nil
+}
+
+// abort releases the resources of the writer without completing the archive.
+func (zw *sevenZipWriter) abort() {
+	if zw.tmp != nil {
+		zw.tmp.Close()
+		os.Remove(zw.tmp.Name())
+		zw.tmp = nil
+	}
+	if zw.err == nil {
+		zw.err = errors.New("7z: writer is closed")
+	}
+}
+
+// header returns the (plain) header, which describes the folders and files.
+func (zw *sevenZipWriter) header() []byte {
+	b := new(bytes.Buffer)
+	b.WriteByte(sevenZipIDHeader)
+	if len(zw.folders) > 0 {
+		b.WriteByte(sevenZipIDMainStreamsInfo)
+		writeSevenZipStreamsInfo(b, 0, zw.folders, zw.entries)
+	}
+	writeSevenZipFilesInfo(b, zw.entries)
+	b.WriteByte(sevenZipIDEnd)
+	return b.Bytes()
+}
+
+// writeSevenZipStreamsInfo writes the pack info, the folders (unpack info),
+// and, if entries is not nil, the sizes and CRCs of the files in the folders
+// (substreams info). The packed streams start at packPos.
+func writeSevenZipStreamsInfo(b *bytes.Buffer, packPos uint64, folders []*sevenZipFolder, entries []sevenZipEntry) {
+	b.WriteByte(sevenZipIDPackInfo)
+	writeSevenZipNumber(b, packPos)
+	writeSevenZipNumber(b, uint64(len(folders)))
+	b.WriteByte(sevenZipIDSize)
+	for _, f := range folders {
+		writeSevenZipNumber(b, f.packSize)
+	}
+	b.WriteByte(sevenZipIDEnd)
+
+	b.WriteByte(sevenZipIDUnpackInfo)
+	b.WriteByte(sevenZipIDFolder)
+	writeSevenZipNumber(b, uint64(len(folders)))
+	b.WriteByte(0) // not external
+	for _, f := range folders {
+		writeSevenZipFolder(b, f)
+	}
+	b.WriteByte(sevenZipIDCodersUnpackSize)
+	for _, f := range folders {
+		if f.aesProps != nil {
+			writeSevenZipNumber(b, f.codedSize)
+		}
+		writeSevenZipNumber(b, f.unpackSize)
+	}
+	var crcs []uint32
+	for _, f := range folders {
+		if f.hasCRC {
+			crcs = append(crcs, f.crc)
+		}
+	}
+	if len(crcs) > 0 {
+		// only the encoded header's folder has a CRC, and it's alone
+		b.WriteByte(sevenZipIDCRC)
+		b.WriteByte(1) // all defined
+		for _, crc := range crcs {
+			binary.Write(b, binary.LittleEndian, crc)
+		}
+	}
+	b.WriteByte(sevenZipIDEnd)
+
+	if entries != nil {
+		writeSevenZipSubStreamsInfo(b, folders, entries)
+	}
+	b.WriteByte(sevenZipIDEnd)
+}
+
+// writeSevenZipFolder writes the coders of a folder: LZMA2, preceded by AES
+// if the folder is encrypted, in which case LZMA2's input is bound to AES's
+// output. Coders are listed in the order they decode, which some readers
+// require.
+func writeSevenZipFolder(b *bytes.Buffer, f *sevenZipFolder) {
+	lzma2Props := []byte{lzma.EncodeDictCap(int64(f.dictCap))}
+	if f.aesProps == nil {
+		writeSevenZipNumber(b, 1)
+		writeSevenZipCoder(b, sevenZipMethodLZMA2, lzma2Props)
+		return
+	}
+	writeSevenZipNumber(b, 2)
+	writeSevenZipCoder(b, sevenZipMethodAES, f.aesProps)
+	writeSevenZipCoder(b, sevenZipMethodLZMA2, lzma2Props)
+	// bind pair: input stream 1 (LZMA2's) comes from output stream 0
+	// (AES's); the only unbound input stream, AES's, is the packed stream
+	writeSevenZipNumber(b, 1)
+	writeSevenZipNumber(b, 0)
+}
+
+// writeSevenZipCoder writes a simple coder (one input and one output stream).
+func writeSevenZipCoder(b *bytes.Buffer, id, props []byte) {
+	const hasProps = 0x20
+	b.WriteByte(byte(len(id)) | hasProps)
+	b.Write(id)
+	writeSevenZipNumber(b, uint64(len(props)))
+	b.Write(props)
+}
+
+func writeSevenZipSubStreamsInfo(b *bytes.Buffer, folders []*sevenZipFolder, entries []sevenZipEntry) {
+	b.WriteByte(sevenZipIDSubStreamsInfo)
+
+	var solid bool
+	for _, f := range folders {
+		solid = solid || f.numStreams != 1
+	}
+	if solid {
+		b.WriteByte(sevenZipIDNumUnpackStream)
+		for _, f := range folders {
+			writeSevenZipNumber(b, uint64(f.numStreams))
+		}
+
+		// the size of the last file in a folder is implied
+		b.WriteByte(sevenZipIDSize)
+		streams := streamEntries(entries)
+		for _, f := range folders {
+			for _, e := range streams[:f.numStreams-1] {
+				writeSevenZipNumber(b, e.size)
+			}
+			streams = streams[f.numStreams:]
+		}
+	}
+
+	b.WriteByte(sevenZipIDCRC)
+	b.WriteByte(1) // all defined
+	for _, e := range streamEntries(entries) {
+		binary.Write(b, binary.LittleEndian, e.crc)
+	}
+	b.WriteByte(sevenZipIDEnd)
+}
+
+// streamEntries returns the entries that have streams, in folder order.
+func streamEntries(entries []sevenZipEntry) []sevenZipEntry {
+	var streams []sevenZipEntry
+	for _, e := range entries {
+		if e.hasStream {
+			streams = append(streams, e)
+		}
+	}
+	return streams
+}
+
+func writeSevenZipFilesInfo(b *bytes.Buffer, entries []sevenZipEntry) {
+	b.WriteByte(sevenZipIDFilesInfo)
+	writeSevenZipNumber(b, uint64(len(entries)))
+	if len(entries) == 0 {
+		// some readers reject a header without files info
+		b.WriteByte(sevenZipIDEnd)
+		return
+	}
+
+	emptyStreams := make([]bool, len(entries))
+	var emptyFiles []bool
+	var anyEmpty, anyEmptyFile bool
+	for i, e := range entries {
+		if !e.hasStream {
+			emptyStreams[i], anyEmpty = true, true
+			emptyFiles = append(emptyFiles, !e.isDir)
+			anyEmptyFile = anyEmptyFile || !e.isDir
+		}
+	}
+	if anyEmpty {
+		writeSevenZipProperty(b, sevenZipIDEmptyStream, sevenZipBoolVector(emptyStreams))
+		if anyEmptyFile {
+			writeSevenZipProperty(b, sevenZipIDEmptyFile, sevenZipBoolVector(emptyFiles))
+		}
+	}
+
+	names := new(bytes.Buffer)
+	names.WriteByte(0) // not external
+	for _, e := range entries {
+		for _, c := range utf16.Encode([]rune(e.name)) {
+			binary.Write(names, binary.LittleEndian, c)
+		}
+		binary.Write(names, binary.LittleEndian, uint16(0))
+	}
+	writeSevenZipProperty(b, sevenZipIDName, names.Bytes())
+
+	defined := make([]bool, len(entries))
+	var times bytes.Buffer
+	for i, e := range entries {
+		if !e.modTime.IsZero() {
+			defined[i] = true
+			// 7z stores Windows file times, as the NTFS extra field of zips does
+			binary.Write(&times, binary.LittleEndian, zipNTFSTime(e.modTime))
+		}
+	}
+	if times.Len() > 0 {
+		mtimes := new(bytes.Buffer)
+		writeSevenZipDefined(mtimes, defined)
+		mtimes.WriteByte(0) // not external
+		mtimes.Write(times.Bytes())
+		writeSevenZipProperty(b, sevenZipIDMTime, mtimes.Bytes())
+	}
+
+	attrs := new(bytes.Buffer)
+	attrs.WriteByte(1) // all defined
+	attrs.WriteByte(0) // not external
+	for _, e := range entries {
+		binary.Write(attrs, binary.LittleEndian, e.attributes)
+	}
+	writeSevenZipProperty(b, sevenZipIDWinAttributes, attrs.Bytes())
+
+	b.WriteByte(sevenZipIDEnd)
+}
+
+func writeSevenZipProperty(b *bytes.Buffer, id byte, data []byte) {
+	b.WriteByte(id)
+	writeSevenZipNumber(b, uint64(len(data)))
+	b.Write(data)
+}
+
+// writeSevenZipDefined writes which items are defined: either a byte saying
+// they all are, or a byte saying they aren't followed by a bit vector.
+func writeSevenZipDefined(b *bytes.Buffer, defined []bool) {
+	for _, d := range defined {
+		if !d {
+			b.WriteByte(0)
+			b.Write(sevenZipBoolVector(defined))
+			return
+		}
+	}
+	b.WriteByte(1)
+}
+
+// sevenZipBoolVector packs v into bits, most significant bit first.
+func sevenZipBoolVector(v []bool) []byte {
+	bits := make([]byte, (len(v)+7)/8)
+	for i, set := range v {
+		if set {
+			bits[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return bits
+}
+
+// writeSevenZipNumber writes v in 7z's variable-length encoding: the number
+// of leading 1 bits in the first byte is the number of bytes that follow,
+// which hold the low bits of v in little-endian order; the rest of the
+// first byte holds the high bits.
+func writeSevenZipNumber(b *bytes.Buffer, v uint64) {
+	for n := 0; n < 8; n++ {
+		if v < 1<<(7*(n+1)) {
+			b.WriteByte(byte(0xFF<<(8-n)) | byte(v>>(8*n)))
+			for i := 0; i < n; i++ {
+				b.WriteByte(byte(v >> (8 * i)))
+			}
+			return
+		}
+	}
+	b.WriteByte(0xFF)
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+// sevenZipStartHeader returns the start header (also known as the signature
+// header), which points to a header of the given contents that follows
+// packedSize bytes of packed streams.
+func sevenZipStartHeader(packedSize uint64, header []byte) []byte {
+	startHeader := make([]byte, sevenZipStartHeaderSize)
+	copy(startHeader, sevenZipHeader)
+	startHeader[6], startHeader[7] = 0, 4 // format version 0.4
+	binary.LittleEndian.PutUint64(startHeader[12:], packedSize)
+	binary.LittleEndian.PutUint64(startHeader[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(startHeader[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(startHeader[8:], crc32.ChecksumIEEE(startHeader[12:]))
+	return startHeader
+}
+
+// sevenZipAttributes returns the attributes of a file with the given mode:
+// Windows attributes in the low 16 bits, and, as p7zip and 7-Zip on Unix do,
+// the Unix mode in the high 16 bits.
+func sevenZipAttributes(mode fs.FileMode) uint32 {
+	const (
+		readOnly      = 0x1
+		directory     = 0x10
+		unixExtension = 0x8000 // the high 16 bits are the Unix mode
+	)
+	attributes := uint32(unixExtension)
+	if mode&0o200 == 0 {
+		attributes |= readOnly
+	}
+
+	unixMode := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		attributes |= directory
+		unixMode |= 0o040000
+	case mode&fs.ModeSymlink != 0:
+		unixMode |= 0o120000
+	default:
+		unixMode |= 0o100000
+	}
+	if mode&fs.ModeSetuid != 0 {
+		unixMode |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unixMode |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unixMode |= 0o1000
+	}
+	return attributes | unixMode<<16
+}
+
+// sevenZipAESKey derives an AES-256 key from password the way 7-Zip does:
+// by hashing the salt, the password in UTF-16LE, and a counter 2^cycles
+// times with SHA-256.
+func sevenZipAESKey(password string, salt []byte, cycles int) []byte {
+	input := bytes.NewBuffer(bytes.Clone(salt))
+	for _, c := range utf16.Encode([]rune(password)) {
+		binary.Write(input, binary.LittleEndian, c)
+	}
+	h := sha256.New()
+	var counter [8]byte
+	for i := uint64(0); i < 1<<cycles; i++ {
+		h.Write(input.Bytes())
+		binary.LittleEndian.PutUint64(counter[:], i)
+		h.Write(counter[:])
+	}
+	return h.Sum(nil)
+}
+
+// sevenZipAESWriter encrypts a stream with AES-256 in CBC mode, padding
+// the last block with zeros as 7-Zip does.
+type sevenZipAESWriter struct {
+	w    io.Writer
+	cbc  cipher.BlockMode
+	buf  []byte // the start of an incomplete block
+	tail [aes.BlockSize]byte
+}
+
+// newSevenZipAESWriter returns a writer that encrypts to w with key and a
+// random IV, and the coder properties that describe how to decrypt it.
+func newSevenZipAESWriter(w io.Writer, key []byte) (*sevenZipAESWriter, []byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, fmt.Errorf("generating IV: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	// first byte: number of cycles, and flags for whether there is a salt
+	// (never) and an IV; second byte: salt size-1 and IV size-1; then the IV
+	props := []byte{sevenZipAESCycles | 0x40, aes.BlockSize - 1}
+	props = append(props, iv...)
+
+	aesw := &sevenZipAESWriter{w: w, cbc: cipher.NewCBCEncrypter(block, iv)}
+	aesw.buf = aesw.tail[:0]
+	return aesw, props, nil
+}
+
+func (aw *sevenZipAESWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(aw.buf) > 0 {
+		fill := copy(aw.tail[len(aw.buf):], p)
+		aw.buf = aw.tail[:len(aw.buf)+fill]
+		p = p[fill:]
+		if len(aw.buf) < aes.BlockSize {
+			return n, nil
+		}
+		if err := aw.encrypt(aw.buf); err != nil {
+			return 0, err
+		}
+		aw.buf = aw.tail[:0]
+	}
+	whole := len(p) - len(p)%aes.BlockSize
+	if whole > 0 {
+		if err := aw.encrypt(bytes.Clone(p[:whole])); err != nil {
+			return 0, err
+		}
+	}
+	aw.buf = aw.tail[:copy(aw.tail[:], p[whole:])]
+	return n, nil
+}
+
+// Close pads and encrypts the last block. It does not close the
+// underlying writer.
+func (aw *sevenZipAESWriter) Close() error {
+	if len(aw.buf) == 0 {
+		return nil
+	}
+	clear(aw.tail[len(aw.buf):])
+	err := aw.encrypt(aw.tail[:])
+	aw.buf = aw.tail[:0]
+	return err
+}
+
+func (aw *sevenZipAESWriter) encrypt(blocks []byte) error {
+	aw.cbc.CryptBlocks(blocks, blocks)
+	_, err := aw.w.Write(blocks)
+	return err
+}
+
+const (
+	sevenZipStartHeaderSize = 32
+	sevenZipDictCap         = 8 << 20
+	sevenZipAESCycles       = 19 // what 7-Zip uses
+)
+
+// Method IDs of the coders that the writer uses.
+var (
+	sevenZipMethodLZMA2 = []byte{0x21}
+	sevenZipMethodAES   = []byte{0x06, 0xF1, 0x07, 0x01}
+)
+
+// Property IDs in 7z headers.
+const (
+	sevenZipIDEnd              = 0x00
+	sevenZipIDHeader           = 0x01
+	sevenZipIDMainStreamsInfo  = 0x04
+	sevenZipIDFilesInfo        = 0x05
+	sevenZipIDPackInfo         = 0x06
+	sevenZipIDUnpackInfo       = 0x07
+	sevenZipIDSubStreamsInfo   = 0x08
+	sevenZipIDSize             = 0x09
+	sevenZipIDCRC              = 0x0A
+	sevenZipIDFolder           = 0x0B
+	sevenZipIDCodersUnpackSize = 0x0C
+	sevenZipIDNumUnpackStream  = 0x0D
+	sevenZipIDEmptyStream      = 0x0E
+	sevenZipIDEmptyFile        = 0x0F
+	sevenZipIDName             = 0x11
+	sevenZipIDMTime            = 0x14
+	sevenZipIDWinAttributes    = 0x15
+	sevenZipIDEncodedHeader    = 0x17
+)
@@ -1,6 +1,7 @@
 package archives
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"golang.org/x/text/encoding"
 )
 
 // FileInfo is a virtualized, generalized file abstraction for interacting with archives.
@@ -41,6 +44,49 @@ type FileInfo struct {
 	// Not supported by all archive formats.
 	LinkTarget string
 
+	// Whether the file's contents are encrypted in the archive.
+	// Set when extracting, by formats that report it.
+	Encrypted bool
+
+	// Whether the file's contents end early in the archive,
+	// which is damaged; they can be read up to where they
+	// end. Set when extracting, by formats that salvage
+	// damaged archives.
+	Truncated bool
+
+	// For sparse files, the regions of the file that hold
+	// data, in order; the rest of it is holes, which are
+	// read as zeros. Set by FilesFromDisk on Linux, and
+	// when extracting, by formats that store sparse files,
+	// which store only these regions when archiving.
+	SparseData []SparseRegion
+
+	// Extended attributes of the file, by name, including
+	// POSIX ACLs as the system.posix_acl_access and
+	// system.posix_acl_default attributes. Set by
+	// FilesFromDisk if its options ask for them, and when
+	// extracting, by formats that store them, which store
+	// these when archiving.
+	Xattrs map[string]string
+
+	// The character encoding the file's name (and comment,
+	// if any) was decoded from, if it was not UTF-8 in the
+	// archive. Set when extracting, by formats configured
+	// with a TextEncoding or to detect it.
+	TextEncoding encoding.Encoding
+
+	// The owner of the file, if recorded in the archive.
+	// Set when extracting, by formats that report it. When
+	// creating an archive, formats that record an owner use
+	// this one if set, instead of the one the OS reports.
+	Owner *FileOwner
+
+	// When the file was last accessed and created, if
+	// recorded in the archive; when it was last modified
+	// is ModTime(). Set when extracting, by formats that
+	// report them, and used like Owner when archiving.
+	AccessTime, CreateTime time.Time
+
 	// A callback function that opens the file to read its
 	// contents. The file must be closed when reading is
 	// complete.
@@ -49,6 +95,17 @@ type FileInfo struct {
 
 func (f FileInfo) Stat() (fs.FileInfo, error) { return f.FileInfo, nil }
 
+// SparseRegion is a region of a sparse file that holds data.
+type SparseRegion struct {
+	Offset, Length int64
+}
+
+// FileOwner is the owner of a file in an archive.
+type FileOwner struct {
+	Uid, Gid     int    // numeric user and group IDs
+	Uname, Gname string // user and group names, if recorded
+}
+
 // FilesFromDisk is an opinionated function that returns a list of FileInfos
 // by walking the directories in the filenames map. The keys are the names on
 // disk, and the values become their associated names in the archive.
@@ -116,6 +173,21 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 				}
 			}
 
+			// holes in regular files are found while their
+			// size on disk can be told from their attributes
+			var sparseData []SparseRegion
+			if info.Mode().IsRegular() {
+				sparseData = fileSparseData(filename, info)
+			}
+
+			var xattrs map[string]string
+			if options != nil && options.Xattrs && linkTarget == "" {
+				xattrs, err = fileXattrs(filename)
+				if err != nil {
+					return fmt.Errorf("%s: reading extended attributes: %w", filename, err)
+				}
+			}
+
 			// handle file attributes
 			if options != nil && options.ClearAttributes {
 				info = noAttrFileInfo{info}
@@ -125,6 +197,8 @@ func FilesFromDisk(ctx context.Context, options *FromDiskOptions, filenames map[
 				FileInfo:      info,
 				NameInArchive: nameInArchive,
 				LinkTarget:    linkTarget,
+				SparseData:    sparseData,
+				Xattrs:        xattrs,
 				Open: func() (fs.File, error) {
 					return os.Open(filename)
 				},
@@ -218,6 +292,11 @@ type FromDiskOptions struct {
 	// If true, some file attributes will not be preserved.
 	// Name, size, type, and permissions will still be preserved.
 	ClearAttributes bool
+
+	// If true, the extended attributes of files are read,
+	// including their POSIX ACLs, so that they can be stored
+	// in archives. This is only supported on Linux.
+	Xattrs bool
 }
 
 // FileHandler is a callback function that is used to handle files as they are read
@@ -234,23 +313,118 @@ type FromDiskOptions struct {
 type FileHandler func(ctx context.Context, info FileInfo) error
 
 // openAndCopyFile opens file for reading, copies its
-// contents to w, then closes file.
-func openAndCopyFile(file FileInfo, w io.Writer) error {
+// contents to w, then closes file. The copy is aborted
+// if ctx is canceled.
+func openAndCopyFile(ctx context.Context, file FileInfo, w io.Writer) error {
 	fileReader, err := file.Open()
 	if err != nil {
 		return err
 	}
 	defer fileReader.Close()
+	fileReader = progressFrom(ctx).observeFile(fileReader)
 	// When file is in use and size is being written to, creating the compressed
 	// file will fail with "archive/tar: write too long." Using CopyN gracefully
 	// handles this.
-	_, err = io.Copy(w, fileReader)
+	_, err = io.Copy(w, contextReadCloser{ctx, fileReader})
 	if err != nil && err != io.EOF {
 		return err
 	}
 	return nil
 }
 
+// contextReadCloser is an io.ReadCloser that honors context
+// cancellation before every read, so that reading a single
+// large stream can be interrupted.
+type contextReadCloser struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (cr contextReadCloser) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.ReadCloser.Read(p)
+}
+
+// contextWriteCloser is an io.WriteCloser that honors context
+// cancellation before every write, so that writing a single
+// large stream can be interrupted.
+type contextWriteCloser struct {
+	ctx context.Context
+	io.WriteCloser
+}
+
+func (cw contextWriteCloser) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.WriteCloser.Write(p)
+}
+
+// spillBuffer holds data in memory, or in a temporary
+// file once it outgrows spillThreshold.
+type spillBuffer struct {
+	mem  bytes.Buffer
+	file *os.File
+	n    int64
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.mem.Len()+len(p) > spillThreshold {
+		f, err := os.CreateTemp("", "archives-*")
+		if err != nil {
+			return 0, err
+		}
+		b.file = f
+		if _, err := b.mem.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.n += int64(n)
+	return n, err
+}
+
+// Len returns the length of the data written.
+func (b *spillBuffer) Len() int64 { return b.n }
+
+func (b *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.file == nil {
+		return b.mem.WriteTo(w)
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, b.file)
+}
+
+// reader returns a reader of the data written, which
+// must not be used after more is written.
+func (b *spillBuffer) reader() *io.SectionReader {
+	if b.file == nil {
+		return io.NewSectionReader(bytes.NewReader(b.mem.Bytes()), 0, b.n)
+	}
+	return io.NewSectionReader(b.file, 0, b.n)
+}
+
+// Close removes the temporary file, if any.
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
+
+const spillThreshold = 4 << 20
+
 // fileIsIncluded returns true if filename is included according to
 // filenameList; meaning it is in the list, its parent folder/path
 // is in the list, or the list is nil.
@@ -1,7 +1,12 @@
 package archives
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -510,3 +515,69 @@ func TestFollowSymlink(t *testing.T) {
 		}
 	})
 }
+
+// endlessReader produces zeros forever, calling onRead after every read.
+type endlessReader struct{ onRead func(total int64) }
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	clear(p)
+	r.onRead(int64(len(p)))
+	return len(p), nil
+}
+
+func TestContextCancellationWithinEntry(t *testing.T) {
+	t.Run("extract", func(t *testing.T) {
+		tarBuf := new(bytes.Buffer)
+		if err := (Tar{}).Archive(context.Background(), tarBuf, []FileInfo{memFile("big.bin", make([]byte, 8<<20))}); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		format := CompressedArchive{Extraction: Tar{}}
+		err := format.Extract(ctx, tarBuf, func(ctx context.Context, f FileInfo) error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			if _, err := io.CopyN(io.Discard, rc, 64<<10); err != nil {
+				return err
+			}
+			cancel()
+			_, err = io.Copy(io.Discard, rc)
+			return err
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var total int64
+		file := FileInfo{
+			FileInfo:      memFileInfo{name: "endless.bin", size: 1 << 40},
+			NameInArchive: "endless.bin",
+			Open: func() (fs.File, error) {
+				r := &endlessReader{onRead: func(n int64) {
+					total += n
+					if total > 1<<20 {
+						cancel()
+					}
+				}}
+				return fileInArchive{ReadCloser: io.NopCloser(r)}, nil
+			},
+		}
+		format := CompressedArchive{Archival: Tar{}, Compression: Gz{}}
+		err := format.Archive(ctx, io.Discard, []FileInfo{file})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+		if total > 2<<20 {
+			t.Errorf("expected copying to stop shortly after cancellation, but read %d bytes", total)
+		}
+	})
+}
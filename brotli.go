@@ -10,7 +10,8 @@ import (
 )
 
 func init() {
-	RegisterFormat(Brotli{})
+	// brotli streams have no header, so match them last
+	RegisterFormatWithPriority(Brotli{}, -10)
 }
 
 // Brotli facilitates brotli compression.
@@ -90,5 +91,6 @@ func (br Brotli) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 }
 
 func (Brotli) OpenReader(r io.Reader) (io.ReadCloser, error) {
-	return io.NopCloser(brotli.NewReader(r)), nil
+	src := &sourceErrorRecorder{Reader: r}
+	return classifyingReader{io.NopCloser(brotli.NewReader(src)), "", src.classify}, nil
 }
@@ -3,6 +3,7 @@ package archives
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"strings"
 
@@ -46,7 +47,87 @@ func (bz Bz2) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 }
 
 func (Bz2) OpenReader(r io.Reader) (io.ReadCloser, error) {
-	return bzip2.NewReader(r, nil)
+	bzR, err := bzip2.NewReader(r, nil)
+	if err != nil {
+		return nil, classifyError("", err)
+	}
+	return newClassifyingReader(bzR, ""), nil
+}
+
+// Streams returns the offsets of the bzip2 streams in r. Streams record
+// neither their length nor the lengths of their blocks, which need not end
+// on a byte, so streams are found by their signature: a stream header
+// followed by the magic number of a block or of the end of the stream,
+// which are aligned to bytes there. The stream before must end right
+// there, with the magic number and CRC that end a stream, padded to a
+// byte. Compressed data are very unlikely to contain all that, but if they
+// do, the stream is split in two there, neither of which decompresses.
+func (Bz2) Streams(r io.ReaderAt, size int64) ([]int64, error) {
+	r = io.NewSectionReader(r, 0, size)
+	const chunkSize = 64 << 10
+	const signatureLen = 10
+	// chunks overlap, so that signatures across them are found
+	buf := make([]byte, chunkSize+signatureLen-1)
+	var offsets []int64
+	for pos := int64(0); pos < size; pos += chunkSize {
+		n, err := r.ReadAt(buf, pos)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		for i := 0; i < min(n, chunkSize); i++ {
+			j := bytes.Index(buf[i:n], bzip2Header)
+			if j < 0 || i+j >= chunkSize {
+				break
+			}
+			i += j
+			sig := buf[i:n]
+			if len(sig) >= signatureLen && sig[3] >= '1' && sig[3] <= '9' &&
+				(bytes.Equal(sig[4:10], bzip2BlockMagic) || bytes.Equal(sig[4:10], bzip2EndMagic)) &&
+				(pos+int64(i) == 0 || bzip2EndsAt(r, pos+int64(i))) {
+				offsets = append(offsets, pos+int64(i))
+			}
+		}
+	}
+	if len(offsets) == 0 || offsets[0] != 0 {
+		return nil, errors.New("not a bzip2 stream")
+	}
+	return offsets, nil
+}
+
+// bzip2EndsAt reports whether a stream ends at offset in r: whether the
+// 80 bits before it, but for up to 7 bits of padding, are the magic number
+// that ends a stream, followed by the CRC of the stream.
+func bzip2EndsAt(r io.ReaderAt, offset int64) bool {
+	const endLen = 11 // bytes that hold the end and its padding
+	b := make([]byte, endLen)
+	if offset < endLen {
+		return false // too short to be a stream
+	}
+	if _, err := r.ReadAt(b, offset-endLen); err != nil {
+		return false
+	}
+	bits := func(from, n int) uint64 {
+		var v uint64
+		for i := from; i < from+n; i++ {
+			v = v<<1 | uint64(b[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	for pad := 0; pad < 8; pad++ {
+		end := endLen*8 - pad
+		if bits(end, pad) == 0 && bits(end-80, 48) == bzip2EndMagicBits {
+			return true
+		}
+	}
+	return false
 }
 
 var bzip2Header = []byte("BZh")
+
+// magic numbers that start a block and the end of a stream
+var (
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+const bzip2EndMagicBits = 0x177245385090
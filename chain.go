@@ -0,0 +1,147 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CompressionChain is a Compression made of multiple layers of compression,
+// for example the gzip-inside-xz of a .gz.xz file. The layers are listed in
+// the same order as their file extensions: the first layer is the innermost
+// one, which is applied first when compressing and removed last when
+// decompressing.
+//
+// A CompressionChain can be the Compression of a CompressedArchive, for
+// example to create or extract .tar.gz.xz files. Identify returns one when
+// it finds more than one layer of compression.
+type CompressionChain []Compression
+
+// Extension returns the concatenation of the layers' extensions.
+func (cc CompressionChain) Extension() string {
+	var ext string
+	for _, layer := range cc {
+		ext += layer.Extension()
+	}
+	return ext
+}
+
+// MediaType returns the media type of the outermost layer.
+func (cc CompressionChain) MediaType() string {
+	if len(cc) == 0 {
+		return ""
+	}
+	return cc[len(cc)-1].MediaType()
+}
+
+// Match matches if every layer matches, from the outermost one inward.
+func (cc CompressionChain) Match(ctx context.Context, filename string, stream io.Reader) (MatchResult, error) {
+	var conglomerate MatchResult
+	if len(cc) == 0 {
+		return conglomerate, nil
+	}
+
+	for i := len(cc) - 1; i >= 0; i-- {
+		matchResult, err := cc[i].Match(ctx, filename, stream)
+		if err != nil {
+			return MatchResult{}, err
+		}
+		if !matchResult.Matched() {
+			return matchResult, nil
+		}
+		conglomerate.ByName = conglomerate.ByName || matchResult.ByName
+		conglomerate.ByStream = conglomerate.ByStream || matchResult.ByStream
+
+		if i > 0 && stream != nil {
+			// decompress this layer so we can match the next one
+			rc, err := cc[i].OpenReader(stream)
+			if err != nil {
+				return MatchResult{}, err
+			}
+			defer rc.Close()
+			stream = rc
+		}
+	}
+
+	return conglomerate, nil
+}
+
+// OpenWriter returns a writer that compresses through every layer, innermost
+// first. Closing it closes the layers from the innermost one outward, so that
+// each flushes into the next; it does not close w.
+func (cc CompressionChain) OpenWriter(w io.Writer) (io.WriteCloser, error) {
+	if len(cc) == 0 {
+		return nil, fmt.Errorf("empty compression chain")
+	}
+	writers := make([]io.WriteCloser, len(cc))
+	for i := len(cc) - 1; i >= 0; i-- {
+		wc, err := cc[i].OpenWriter(w)
+		if err != nil {
+			for _, opened := range writers[i+1:] {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("opening %s writer: %w", cc[i].Extension(), err)
+		}
+		writers[i] = wc
+		w = wc
+	}
+	return chainWriteCloser(writers), nil
+}
+
+// OpenReader returns a reader that decompresses every layer, outermost first.
+// Closing it closes every layer; it does not close r.
+func (cc CompressionChain) OpenReader(r io.Reader) (io.ReadCloser, error) {
+	if len(cc) == 0 {
+		return nil, fmt.Errorf("empty compression chain")
+	}
+	readers := make([]io.ReadCloser, len(cc))
+	for i := len(cc) - 1; i >= 0; i-- {
+		rc, err := cc[i].OpenReader(r)
+		if err != nil {
+			for _, opened := range readers[i+1:] {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("opening %s reader: %w", cc[i].Extension(), err)
+		}
+		readers[i] = rc
+		r = rc
+	}
+	return chainReadCloser(readers), nil
+}
+
+// chainWriteCloser writes to the innermost layer of a compression chain,
+// which is the first element.
+type chainWriteCloser []io.WriteCloser
+
+func (cwc chainWriteCloser) Write(p []byte) (int, error) { return cwc[0].Write(p) }
+
+func (cwc chainWriteCloser) Close() error {
+	var errs []error
+	for _, wc := range cwc {
+		errs = append(errs, wc.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// chainReadCloser reads from the innermost layer of a compression chain,
+// which is the first element.
+type chainReadCloser []io.ReadCloser
+
+func (crc chainReadCloser) Read(p []byte) (int, error) { return crc[0].Read(p) }
+
+func (crc chainReadCloser) Close() error {
+	var errs []error
+	for _, rc := range crc {
+		errs = append(errs, rc.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// maxCompressionLayers is the maximum number of layers of compression
+// that Identify will peel off, to defend against inputs that decompress
+// to themselves or to deeply nested compression.
+const maxCompressionLayers = 8
+
+// Interface guard
+var _ Compression = CompressionChain(nil)
@@ -0,0 +1,91 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCompressionChain(t *testing.T) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("layer upon layer\n"), 100)
+	files := []FileInfo{memFile("a.txt", content)}
+
+	t.Run("compressed archive", func(t *testing.T) {
+		tarGzXz := CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: CompressionChain{Gz{}, Xz{}}}
+		if ext := tarGzXz.Extension(); ext != ".tar.gz.xz" {
+			t.Errorf("expected extension .tar.gz.xz, got %s", ext)
+		}
+		buf := new(bytes.Buffer)
+		if err := tarGzXz.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+
+		format, _, err := Identify(ctx, "", bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca, ok := format.(CompressedArchive)
+		if !ok {
+			t.Fatalf("expected CompressedArchive, got %T", format)
+		}
+		if !reflect.DeepEqual(ca.Compression, CompressionChain{Gz{}, Xz{}}) {
+			t.Errorf("expected chain of gz and xz, got %#v", ca.Compression)
+		}
+		if err := readAllEntries(ca, buf.Bytes()); err != nil {
+			t.Errorf("extracting: %v", err)
+		}
+	})
+
+	t.Run("compression only", func(t *testing.T) {
+		gzZst := CompressionChain{Gz{}, Zstd{}}
+		compressed := compress(t, gzZst.Extension(), content, gzZst.OpenWriter)
+
+		format, _, err := Identify(ctx, "cache.gz.zst", bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(format, gzZst) {
+			t.Fatalf("expected %#v, got %#v", gzZst, format)
+		}
+		rc, err := format.(Decompressor).OpenReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		decompressed, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, content) {
+			t.Error("decompressed content does not match original")
+		}
+	})
+
+	t.Run("single layer", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		zipGz := CompressedArchive{Archival: Zip{}, Compression: Gz{}}
+		if err := zipGz.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		format, _, err := Identify(ctx, "archive.zip.gz", bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ca, ok := format.(CompressedArchive); !ok || ca.Compression != (Gz{}) {
+			t.Errorf("expected zip compressed with gz, got %#v", format)
+		}
+	})
+
+	t.Run("no layers", func(t *testing.T) {
+		// an empty chain must not stand in for a Compression
+		if comp := compressionFromChain(CompressionChain{}); comp != nil {
+			t.Errorf("expected nil for an empty chain, got %#v", comp)
+		}
+		if comp := compressionFromLayers(nil); comp != nil {
+			t.Errorf("expected nil without layers, got %#v", comp)
+		}
+	})
+}
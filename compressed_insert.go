@@ -0,0 +1,246 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressed streams can't be modified, but many formats can concatenate
+// them. A tar archive ends with blocks of zeros after its last file, so
+// files are appended by replacing the stream where those blocks start, and
+// any after it, with new streams: what preceded the blocks in that stream,
+// compressed again, then the new files, then new blocks of zeros. The blocks
+// of zeros are a stream of their own so that the next time, it's the only
+// one that is replaced. The whole archive is decompressed to find where the
+// blocks start, though.
+
+// Insert appends files to a compressed tar archive, if its Compression is a
+// Concatenator, as new streams of compressed data; otherwise, it returns an
+// error. An archive compressed as one stream, as most are, is decompressed
+// and compressed again the first time, but after that, what was inserted
+// before is not. If Compression is nil, files are inserted by the Archival
+// format.
+//
+// The archive is only written to once the files are compressed, so if an
+// error stops the operation before then, it is left as it was. The new
+// streams are written over the end of the archive, though, so if writing
+// them fails, the archive is left damaged from where they were written. The
+// archive may become shorter, in which case into must have a Truncate
+// method, as *os.File does.
+func (ca CompressedArchive) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileInfo) error {
+	if ca.Compression == nil {
+		inserter, ok := ca.Archival.(Inserter)
+		if !ok {
+			return fmt.Errorf("%T archive does not support inserting", ca.Archival)
+		}
+		return inserter.Insert(ctx, into, files)
+	}
+	concatenator, ok := ca.Compression.(Concatenator)
+	if !ok {
+		return fmt.Errorf("%T compression does not support inserting, since its streams can't be concatenated", ca.Compression)
+	}
+	t, ok := ca.Archival.(Tar)
+	if !ok {
+		return fmt.Errorf("inserting into compressed %T archives is not supported", ca.Archival)
+	}
+
+	size, err := streamSizeBySeeking(into)
+	if err != nil {
+		return fmt.Errorf("determining stream size: %w", err)
+	}
+	ra, ok := into.(io.ReaderAt)
+	if !ok {
+		ra = seekingReaderAt{into}
+	}
+	offsets, err := concatenator.Streams(ra, size)
+	if err != nil {
+		return fmt.Errorf("finding compressed streams: %w", err)
+	}
+
+	offsets = append(offsets, size) // where the last stream ends
+
+	// find where the tar archive ends, and the stream that it ends in
+	streams := &streamsReader{c: ca.Compression, r: ra, offsets: offsets}
+	end, err := tarEnd(contextReadCloser{ctx, streams})
+	streams.Close()
+	if err != nil {
+		return fmt.Errorf("finding end of archive: %w", err)
+	}
+	var last int // the last stream that starts before the end
+	for i, start := range streams.starts {
+		if start <= end {
+			last = i
+		}
+	}
+
+	// compress the rest of the archive in that stream, the files,
+	// and the end of the archive, each as a stream
+	compressed := new(spillBuffer)
+	defer compressed.Close()
+	var offset int64
+	if len(streams.starts) > 0 {
+		offset = offsets[last]
+		if rest := end - streams.starts[last]; rest > 0 {
+			rc, err := ca.Compression.OpenReader(io.NewSectionReader(ra, offset, offsets[last+1]-offset))
+			if err != nil {
+				return fmt.Errorf("opening stream at %d: %w", offset, err)
+			}
+			err = compressStream(ca.Compression, compressed, func(w io.Writer) error {
+				_, err := io.CopyN(w, contextReadCloser{ctx, rc}, rest)
+				return err
+			})
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("compressing end of archive: %w", err)
+			}
+		}
+	}
+	var appendErr error
+	err = compressStream(ca.Compression, compressed, func(w io.Writer) error {
+		tw := newTarWriter(contextWriteCloser{ctx, nopWriteCloser{w}})
+		// files that were skipped don't stop the others being inserted
+		appendErr = t.appendFiles(ctx, tw, files)
+		var skipped *SkippedEntriesError
+		if appendErr != nil && !errors.As(appendErr, &skipped) {
+			return appendErr
+		}
+		return tw.Flush() // without the end-of-archive blocks
+	})
+	if err != nil {
+		return err
+	}
+	err = compressStream(ca.Compression, compressed, func(w io.Writer) error {
+		_, err := w.Write(make([]byte, 2*tarBlockSize))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// replace the stream with the new one
+	newSize := offset + compressed.Len()
+	truncater, canTruncate := into.(interface{ Truncate(int64) error })
+	if newSize < size && !canTruncate {
+		return fmt.Errorf("archive would become shorter, but %T can't be truncated", into)
+	}
+	if _, err := into.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := compressed.WriteTo(into); err != nil {
+		return fmt.Errorf("writing compressed stream: %w", err)
+	}
+	if canTruncate && newSize < size {
+		if err := truncater.Truncate(newSize); err != nil {
+			return err
+		}
+	}
+	return appendErr
+}
+
+// compressStream compresses what write writes as a stream written to w.
+func compressStream(c Compressor, w io.Writer, write func(io.Writer) error) error {
+	cw, err := c.OpenWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := write(cw); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// tarEnd returns the length of the tar archive read from r without its
+// end-of-archive blocks, which is where files can be appended to it.
+func tarEnd(r io.Reader) (int64, error) {
+	cr := &countingReader{Reader: r}
+	tr := tar.NewReader(cr)
+	var end int64
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return end, nil
+		} else if err != nil {
+			return 0, err
+		}
+		// the contents are followed by padding to a whole block
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return 0, err
+		}
+		end = tarBlocks(cr.n.Load())
+	}
+}
+
+// streamsReader decompresses the concatenated streams of compressed data
+// that start at offsets in r, one at a time, and records where each starts
+// in the decompressed data. The last offset is where the last stream ends.
+type streamsReader struct {
+	c       Decompressor
+	r       io.ReaderAt
+	offsets []int64
+	starts  []int64 // of the streams opened so far
+	stream  io.ReadCloser
+	n       int64 // decompressed so far
+}
+
+func (sr *streamsReader) Read(p []byte) (int, error) {
+	for {
+		if sr.stream == nil {
+			i := len(sr.starts)
+			if i >= len(sr.offsets)-1 {
+				return 0, io.EOF
+			}
+			rc, err := sr.c.OpenReader(io.NewSectionReader(sr.r, sr.offsets[i], sr.offsets[i+1]-sr.offsets[i]))
+			if err != nil {
+				return 0, fmt.Errorf("opening stream at %d: %w", sr.offsets[i], err)
+			}
+			sr.stream = rc
+			sr.starts = append(sr.starts, sr.n)
+		}
+		n, err := sr.stream.Read(p)
+		sr.n += int64(n)
+		if err == io.EOF {
+			sr.stream.Close()
+			sr.stream = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (sr *streamsReader) Close() error {
+	if sr.stream == nil {
+		return nil
+	}
+	return sr.stream.Close()
+}
+
+// seekingReaderAt reads at offsets by seeking, so it
+// must not be used concurrently or with other reads.
+type seekingReaderAt struct{ io.ReadSeeker }
+
+func (r seekingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Interface guards
+var (
+	_ Inserter     = (*CompressedArchive)(nil)
+	_ Concatenator = (*Gz)(nil)
+	_ Concatenator = (*Zstd)(nil)
+	_ Concatenator = (*Xz)(nil)
+	_ Concatenator = (*Bz2)(nil)
+	_ Concatenator = (*Lz4)(nil)
+)
@@ -0,0 +1,170 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedArchiveInsert(t *testing.T) {
+	ctx := context.Background()
+
+	random := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	contents := map[string][]byte{
+		"a.txt":     []byte("hello"),
+		"b.bin":     random,
+		"c/d.txt":   bytes.Repeat([]byte("appended\n"), 1000),
+		"c/e.empty": {},
+		"f.txt":     []byte("appended again"),
+	}
+	names := []string{"a.txt", "b.bin", "c/d.txt", "c/e.empty", "f.txt"}
+	files := make([]FileInfo, len(names))
+	for i, name := range names {
+		files[i] = memFile(name, contents[name])
+	}
+
+	for _, comp := range []Compression{Gz{}, Gz{Multithreaded: true}, Zstd{}, Xz{}, Bz2{}, Lz4{}} {
+		t.Run(fmt.Sprintf("%T", comp), func(t *testing.T) {
+			format := CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: comp}
+			f, err := os.Create(filepath.Join(t.TempDir(), "test.tar"+comp.Extension()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if err := format.Archive(ctx, f, files[:2]); err != nil {
+				t.Fatal(err)
+			}
+
+			// the first insert compresses the archive again, and the second
+			// replaces only the end of the archive
+			if err := format.Insert(ctx, f, files[2:4]); err != nil {
+				t.Fatal(err)
+			}
+			if err := format.Insert(ctx, f, files[4:]); err != nil {
+				t.Fatal(err)
+			}
+
+			archive, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets, err := comp.(Concatenator).Streams(bytes.NewReader(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the archive without its end, the files inserted each time,
+			// and the end of the archive
+			if len(offsets) != 4 {
+				t.Errorf("expected 4 streams, got %v", offsets)
+			}
+
+			var got []string
+			err = format.Extract(ctx, bytes.NewReader(archive), func(_ context.Context, f FileInfo) error {
+				got = append(got, f.NameInArchive)
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(data, contents[f.NameInArchive]) {
+					t.Errorf("%s: contents do not match", f.NameInArchive)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(names) {
+				t.Errorf("expected files %v, got %v", names, got)
+			}
+		})
+	}
+
+	t.Run("Bz2 signature in data", func(t *testing.T) {
+		var data []byte
+		var want []int64
+		for _, s := range []string{"first", "second"} {
+			buf := new(bytes.Buffer)
+			if err := compressStream(Bz2{}, buf, func(w io.Writer) error {
+				_, err := io.WriteString(w, s)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, int64(len(data)))
+			data = append(data, buf.Bytes()...)
+		}
+		// a stream doesn't start where the previous one doesn't end
+		data = append(data, "xxBZh91AY&SYxx"...)
+		offsets, err := Bz2{}.Streams(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(offsets) != fmt.Sprint(want) {
+			t.Errorf("expected streams at %v, got %v", want, offsets)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		format := CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: Brotli{}}
+		buf := new(bytes.Buffer)
+		if err := format.Archive(ctx, buf, files[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := format.Compression.(Concatenator); ok {
+			t.Fatal("expected brotli not to be a Concatenator")
+		}
+		before := bytes.Clone(buf.Bytes())
+		if err := format.Insert(ctx, &seekableBuffer{buf: buf.Bytes()}, files[1:]); err == nil {
+			t.Error("expected an error inserting into an archive whose streams can't be concatenated")
+		}
+		if !bytes.Equal(before, buf.Bytes()) {
+			t.Error("expected the archive to be left as it was")
+		}
+	})
+}
+
+// seekableBuffer is an in-memory io.ReadWriteSeeker.
+type seekableBuffer struct {
+	buf []byte
+	pos int64
+}
+
+func (b *seekableBuffer) Read(p []byte) (int, error) {
+	if b.pos >= int64(len(b.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.buf[b.pos:])
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *seekableBuffer) Write(p []byte) (int, error) {
+	if end := b.pos + int64(len(p)); end > int64(len(b.buf)) {
+		b.buf = append(b.buf, make([]byte, end-int64(len(b.buf)))...)
+	}
+	n := copy(b.buf[b.pos:], p)
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *seekableBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.pos
+	case io.SeekEnd:
+		offset += int64(len(b.buf))
+	}
+	b.pos = offset
+	return offset, nil
+}
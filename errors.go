@@ -0,0 +1,366 @@
+package archives
+
+import (
+	"archive/tar"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	fastxz "github.com/mikelolasagasti/xz"
+	"github.com/minio/minlz"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pierrec/lz4/v4"
+	"github.com/sorairolake/lzip-go"
+)
+
+// Errors that describe why reading an archive failed, independently of the
+// format or the library that reads it. Errors returned while reading archives
+// and compressed streams wrap one of these (and the original error from the
+// underlying library) when the cause can be determined, so they can be tested
+// with errors.Is. ErrLimitExceeded also belongs to this set.
+var (
+	// ErrEncrypted means the archive or entry is encrypted
+	// and no password was given (or encryption is unsupported).
+	ErrEncrypted = errors.New("encrypted")
+
+	// ErrBadPassword means the password given to decrypt
+	// the archive or entry is incorrect.
+	ErrBadPassword = errors.New("incorrect password")
+
+	// ErrCorrupt means the archive or compressed data is
+	// malformed or fails an integrity check.
+	ErrCorrupt = errors.New("corrupt data")
+
+	// ErrTruncated means the archive or compressed data
+	// ended unexpectedly.
+	ErrTruncated = errors.New("truncated data")
+
+	// ErrUnsupportedMethod means the data uses a compression
+	// or encryption method, or format version, that is not
+	// supported.
+	ErrUnsupportedMethod = errors.New("unsupported method")
+)
+
+// ArchiveError is an error encountered while reading an archive or compressed
+// stream whose cause has been classified. It wraps both Kind and Err, so
+// errors.Is matches either the sentinel (e.g. ErrCorrupt) or the original error
+// from the underlying library.
+type ArchiveError struct {
+	// The name of the entry in the archive being read
+	// when the error occurred, if applicable.
+	Entry string
+
+	// The kind of error: one of ErrEncrypted, ErrBadPassword,
+	// ErrCorrupt, ErrTruncated, or ErrUnsupportedMethod.
+	Kind error
+
+	// The underlying error.
+	Err error
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf("%s: %v: %v", e.Entry, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// classifyError returns err wrapped in an *ArchiveError if its cause can be
+// determined, attributing it to entry (which may be empty). Errors that are
+// nil, io.EOF, already classified, or of unknown cause are returned as-is.
+func classifyError(entry string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	var archiveErr *ArchiveError
+	if errors.As(err, &archiveErr) || errors.Is(err, ErrLimitExceeded) {
+		return err
+	}
+	kind := errorKind(err)
+	if kind == nil {
+		return err
+	}
+	return &ArchiveError{Entry: entry, Kind: kind, Err: err}
+}
+
+// errorKind returns the sentinel error describing the cause of err, as
+// reported by the libraries used to read the various formats, or nil
+// if it is not known.
+func errorKind(err error) error {
+	var corruptInput flate.CorruptInputError
+	var corrupted interface{ IsCorrupted() bool } // github.com/dsnet/compress errors (bzip2)
+
+	switch {
+	case errors.Is(err, rardecode.ErrArchiveEncrypted),
+		errors.Is(err, rardecode.ErrArchivedFileEncrypted):
+		return ErrEncrypted
+
+	case errors.Is(err, rardecode.ErrBadPassword),
+		errors.Is(err, errZipBadPassword):
+		return ErrBadPassword
+
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, rardecode.ErrUnexpectedArcEnd),
+		errors.Is(err, rardecode.ErrDecoderOutOfData),
+		errors.Is(err, rardecode.ErrShortFile),
+		errors.Is(err, fastxz.ErrBuf),
+		errors.Is(err, lz4.ErrInvalidSourceShortBuffer):
+		return ErrTruncated
+
+	case errors.Is(err, zip.ErrAlgorithm),
+		errors.Is(err, rardecode.ErrUnknownDecoder),
+		errors.Is(err, rardecode.ErrUnsupportedDecoder),
+		errors.Is(err, rardecode.ErrUnknownEncryptMethod),
+		errors.Is(err, rardecode.ErrUnknownVersion),
+		errors.Is(err, rardecode.ErrMultipleDecoders),
+		errors.Is(err, fastxz.ErrUnsupportedCheck),
+		errors.Is(err, fastxz.ErrOptions),
+		errors.Is(err, zstd.ErrUnknownDictionary),
+		errors.Is(err, s2.ErrUnsupported),
+		errors.Is(err, minlz.ErrUnsupported),
+		isError[*lzip.UnsupportedVersionError](err),
+		isError[*lzip.UnknownVersionError](err),
+		strings.Contains(err.Error(), "sevenzip: unsupported compression algorithm"):
+		return ErrUnsupportedMethod
+
+	case errors.Is(err, tar.ErrHeader),
+		errors.Is(err, zip.ErrFormat),
+		errors.Is(err, zip.ErrChecksum),
+		errors.Is(err, errZipAuthentication),
+		errors.Is(err, errPPMdCorrupt),
+		errors.Is(err, gzip.ErrHeader),
+		errors.Is(err, gzip.ErrChecksum),
+		errors.Is(err, pgzip.ErrHeader),
+		errors.Is(err, pgzip.ErrChecksum),
+		errors.Is(err, zlib.ErrHeader),
+		errors.Is(err, zlib.ErrChecksum),
+		errors.Is(err, zlib.ErrDictionary),
+		errors.As(err, &corruptInput),
+		errors.Is(err, rardecode.ErrCorruptBlockHeader),
+		errors.Is(err, rardecode.ErrCorruptFileHeader),
+		errors.Is(err, rardecode.ErrBadHeaderCRC),
+		errors.Is(err, rardecode.ErrCorruptEncryptData),
+		errors.Is(err, rardecode.ErrCorruptDecodeHeader),
+		errors.Is(err, rardecode.ErrHuffDecodeFailed),
+		errors.Is(err, rardecode.ErrInvalidLengthTable),
+		errors.Is(err, rardecode.ErrCorruptPPM),
+		errors.Is(err, rardecode.ErrInvalidFileBlock),
+		errors.Is(err, rardecode.ErrBadFileChecksum),
+		errors.Is(err, rardecode.ErrInvalidFilter),
+		errors.Is(err, rardecode.ErrUnknownFilter),
+		errors.Is(err, rardecode.ErrInvalidVMInstruction),
+		errors.Is(err, fastxz.ErrFormat),
+		errors.Is(err, fastxz.ErrData),
+		errors.Is(err, zstd.ErrMagicMismatch),
+		errors.Is(err, zstd.ErrReservedBlockType),
+		errors.Is(err, zstd.ErrCompressedSizeTooBig),
+		errors.Is(err, zstd.ErrBlockTooSmall),
+		errors.Is(err, zstd.ErrUnexpectedBlockSize),
+		errors.Is(err, zstd.ErrWindowSizeTooSmall),
+		errors.Is(err, zstd.ErrFrameSizeMismatch),
+		errors.Is(err, zstd.ErrCRCMismatch),
+		errors.Is(err, lz4.ErrInvalidFrame),
+		errors.Is(err, lz4.ErrInvalidHeaderChecksum),
+		errors.Is(err, lz4.ErrInvalidBlockChecksum),
+		errors.Is(err, lz4.ErrInvalidFrameChecksum),
+		errors.Is(err, s2.ErrCorrupt),
+		errors.Is(err, s2.ErrCRC),
+		errors.Is(err, minlz.ErrCorrupt),
+		errors.Is(err, minlz.ErrCRC),
+		errors.Is(err, lzip.ErrInvalidMagic),
+		isError[*lzip.InvalidCRCError](err),
+		isError[*lzip.InvalidDataSizeError](err),
+		isError[*lzip.InvalidMemberSizeError](err),
+		errors.As(err, &corrupted) && corrupted.IsCorrupted(),
+		strings.Contains(err.Error(), "sevenzip: checksum error"),
+		strings.Contains(err.Error(), "sevenzip: not a valid 7-zip file"):
+		return ErrCorrupt
+	}
+
+	return nil
+}
+
+// isError reports whether any error in err's tree is of type T.
+func isError[T error](err error) bool {
+	var target T
+	return errors.As(err, &target)
+}
+
+// classifySevenZipError is like classifyError, but accounts for read
+// errors that sevenzip flags as involving encryption: these are most
+// likely caused by a missing or incorrect password.
+func classifySevenZipError(entry, password string, err error) error {
+	var readErr *sevenzip.ReadError
+	if errors.As(err, &readErr) && readErr.Encrypted {
+		kind := ErrBadPassword
+		if password == "" {
+			kind = ErrEncrypted
+		}
+		return &ArchiveError{Entry: entry, Kind: kind, Err: err}
+	}
+	return classifyError(entry, err)
+}
+
+// classifyingReader classifies the errors returned from reading
+// the underlying reader, attributing them to entry, if not empty.
+type classifyingReader struct {
+	io.ReadCloser
+	entry    string
+	classify func(entry string, err error) error
+}
+
+// newClassifyingReader returns rc with its read errors classified
+// by classifyError.
+func newClassifyingReader(rc io.ReadCloser, entry string) io.ReadCloser {
+	return classifyingReader{rc, entry, classifyError}
+}
+
+func (r classifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = r.classify(r.entry, err)
+	}
+	return n, err
+}
+
+// sourceErrorRecorder records the last error returned by the source reader
+// of a decompressor whose own errors are not exported. Any other error the
+// decompressor returns must be caused by malformed input.
+type sourceErrorRecorder struct {
+	io.Reader
+	err error
+}
+
+func (r *sourceErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// classify classifies err like classifyError, except that errors of
+// unknown cause that did not come from the source are ErrCorrupt.
+func (r *sourceErrorRecorder) classify(entry string, err error) error {
+	fromSource := r.err != nil && errors.Is(err, r.err)
+	if !fromSource && err != io.EOF && errorKind(err) == nil && !errors.Is(err, ErrLimitExceeded) {
+		return &ArchiveError{Entry: entry, Kind: ErrCorrupt, Err: err}
+	}
+	return classifyError(entry, err)
+}
+
+// EntryError is an error that occurred while reading or writing
+// one entry of an archive.
+type EntryError struct {
+	// The name of the entry.
+	Entry string
+
+	// The error that occurred.
+	Err error
+}
+
+func (e *EntryError) Error() string {
+	if e.Entry == "" {
+		return e.Err.Error()
+	}
+	return e.Entry + ": " + e.Err.Error()
+}
+
+func (e *EntryError) Unwrap() error { return e.Err }
+
+// SkippedRangeError is an error reporting a range of a damaged archive
+// that was skipped to recover from an error, since no entries could be
+// read from it.
+type SkippedRangeError struct {
+	// Where the range starts in the archive, and its length.
+	Offset, Length int64
+
+	// The error that caused the range to be skipped.
+	Err error
+}
+
+func (e *SkippedRangeError) Error() string {
+	return fmt.Sprintf("skipped %d bytes at offset %d: %v", e.Length, e.Offset, e.Err)
+}
+
+func (e *SkippedRangeError) Unwrap() error { return e.Err }
+
+// SkippedEntriesError is returned when an operation completed, but
+// some entries were skipped because of errors, either by enabling
+// ContinueOnError or by OnError returning nil. It wraps the error
+// of each skipped entry.
+type SkippedEntriesError struct {
+	Errors []*EntryError
+}
+
+func (e *SkippedEntriesError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "skipped %d entries because of errors", len(e.Errors))
+	for i, err := range e.Errors {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(err.Error())
+	}
+	return sb.String()
+}
+
+func (e *SkippedEntriesError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// entryErrorHandler decides whether an operation on an archive may
+// continue after an error with one of its entries, according to the
+// ContinueOnError and OnError settings of the format, and keeps track
+// of the entries that were skipped.
+type entryErrorHandler struct {
+	continueOnError bool
+	onError         func(entry string, err error) error
+	skipped         []*EntryError
+}
+
+// handle returns nil if the operation should skip entry and continue
+// after err, or the error to abort the operation with. Context errors
+// and exceeded limits always abort.
+func (h *entryErrorHandler) handle(ctx context.Context, entry string, err error) error {
+	if ctx.Err() != nil || errors.Is(err, ErrLimitExceeded) {
+		return err
+	}
+	if h.onError != nil {
+		if err := h.onError(entry, err); err != nil {
+			return err
+		}
+	} else if !h.continueOnError {
+		return err
+	}
+	h.skipped = append(h.skipped, &EntryError{Entry: entry, Err: err})
+	return nil
+}
+
+// err returns a *SkippedEntriesError if any entries were skipped,
+// whether because of ContinueOnError or because OnError returned
+// nil for them, or nil otherwise.
+func (h *entryErrorHandler) err() error {
+	if len(h.skipped) == 0 {
+		return nil
+	}
+	return &SkippedEntriesError{Errors: h.skipped}
+}
@@ -0,0 +1,224 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestDecompressorErrors(t *testing.T) {
+	content := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+
+	for _, comp := range []Compression{
+		Gz{}, Gz{Multithreaded: true}, Zstd{}, Xz{}, Bz2{}, Lz4{}, Zlib{}, Sz{}, MinLZ{}, Lzip{}, Brotli{},
+	} {
+		compressed := compress(t, comp.Extension(), content, comp.OpenWriter)
+
+		t.Run(comp.Extension()+" truncated", func(t *testing.T) {
+			err := decompressAll(comp, compressed[:len(compressed)/2])
+			if !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected ErrTruncated or ErrCorrupt, got: %v", err)
+			}
+		})
+
+		t.Run(comp.Extension()+" corrupt", func(t *testing.T) {
+			corrupted := bytes.Clone(compressed)
+			for i := len(corrupted) / 3; i < len(corrupted)/3+16; i++ {
+				corrupted[i] ^= 0xff
+			}
+			err := decompressAll(comp, corrupted)
+			if !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrTruncated) {
+				t.Errorf("expected ErrCorrupt or ErrTruncated, got: %v", err)
+			}
+		})
+	}
+
+	t.Run("gz truncated", func(t *testing.T) {
+		compressed := compress(t, ".gz", content, Gz{}.OpenWriter)
+		err := decompressAll(Gz{}, compressed[:len(compressed)-4])
+		if !errors.Is(err, ErrTruncated) {
+			t.Errorf("expected ErrTruncated, got: %v", err)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected original error to be preserved, got: %v", err)
+		}
+	})
+}
+
+// decompressAll reads all of input through the decompressor.
+func decompressAll(comp Decompressor, input []byte) error {
+	rc, err := comp.OpenReader(bytes.NewReader(input))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+func TestArchiveErrors(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("this content will be corrupted")
+
+	t.Run("zip checksum", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		if err := (Zip{}).Archive(ctx, buf, []FileInfo{memFile("a.txt", content)}); err != nil {
+			t.Fatal(err)
+		}
+		corrupted := bytes.Replace(buf.Bytes(), content, bytes.ToUpper(content), 1)
+
+		err := readAllEntries(Zip{}, corrupted)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("expected ErrCorrupt, got: %v", err)
+		}
+		var archiveErr *ArchiveError
+		if !errors.As(err, &archiveErr) || archiveErr.Entry != "a.txt" {
+			t.Errorf("expected error for entry a.txt, got: %v", err)
+		}
+	})
+
+	t.Run("zip encrypted", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "secret.txt", Method: zip.Store, Flags: zipFlagEncrypted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		err = readAllEntries(Zip{}, buf.Bytes())
+		if !errors.Is(err, ErrEncrypted) {
+			t.Errorf("expected ErrEncrypted, got: %v", err)
+		}
+	})
+
+	t.Run("tar truncated", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		if err := (Tar{}).Archive(ctx, buf, []FileInfo{memFile("a.txt", content)}); err != nil {
+			t.Fatal(err)
+		}
+		err := readAllEntries(Tar{}, buf.Bytes()[:512+len(content)/2])
+		if !errors.Is(err, ErrTruncated) {
+			t.Errorf("expected ErrTruncated, got: %v", err)
+		}
+	})
+
+	t.Run("unclassified errors are unchanged", func(t *testing.T) {
+		errOther := errors.New("something else")
+		if err := classifyError("a.txt", errOther); err != errOther {
+			t.Errorf("expected error to be unchanged, got: %v", err)
+		}
+		if err := classifyError("a.txt", io.EOF); err != io.EOF {
+			t.Errorf("expected io.EOF to be unchanged, got: %v", err)
+		}
+	})
+}
+
+func TestContinueOnError(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("this content will be corrupted")
+
+	buf := new(bytes.Buffer)
+	files := []FileInfo{memFile("a.txt", []byte("first")), memFile("b.txt", content), memFile("c.txt", []byte("third"))}
+	if err := (Zip{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := bytes.Replace(buf.Bytes(), content, bytes.ToUpper(content), 1)
+
+	t.Run("aggregate error", func(t *testing.T) {
+		err := readAllEntries(Zip{ContinueOnError: true}, corrupted)
+		var skipped *SkippedEntriesError
+		if !errors.As(err, &skipped) {
+			t.Fatalf("expected *SkippedEntriesError, got: %v", err)
+		}
+		if len(skipped.Errors) != 1 || skipped.Errors[0].Entry != "b.txt" {
+			t.Errorf("expected only b.txt to be skipped, got: %v", err)
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected skipped error to wrap ErrCorrupt, got: %v", err)
+		}
+	})
+
+	t.Run("OnError continues", func(t *testing.T) {
+		var reported []string
+		z := Zip{OnError: func(entry string, err error) error {
+			reported = append(reported, entry)
+			return nil
+		}}
+		err := readAllEntries(z, corrupted)
+		if len(reported) != 1 || reported[0] != "b.txt" {
+			t.Errorf("expected OnError to be called for b.txt, got: %v", reported)
+		}
+		var skipped *SkippedEntriesError
+		if !errors.As(err, &skipped) || len(skipped.Errors) != 1 || skipped.Errors[0].Entry != "b.txt" {
+			t.Errorf("expected b.txt to be skipped, got: %v", err)
+		}
+	})
+
+	t.Run("OnError aborts", func(t *testing.T) {
+		errAbort := errors.New("abort")
+		z := Zip{ContinueOnError: true, OnError: func(string, error) error { return errAbort }}
+		if err := readAllEntries(z, corrupted); !errors.Is(err, errAbort) {
+			t.Errorf("expected OnError's error, got: %v", err)
+		}
+	})
+
+	t.Run("archiving", func(t *testing.T) {
+		errOpen := errors.New("cannot open")
+		bad := memFile("bad.txt", nil)
+		bad.Open = func() (fs.File, error) { return nil, errOpen }
+		files := []FileInfo{memFile("a.txt", []byte("first")), bad, memFile("c.txt", []byte("third"))}
+
+		err := (Tar{}).Archive(ctx, io.Discard, files)
+		if !errors.Is(err, errOpen) {
+			t.Errorf("expected error without ContinueOnError, got: %v", err)
+		}
+		var skipped *SkippedEntriesError
+		err = (Tar{ContinueOnError: true}).Archive(ctx, io.Discard, files)
+		if !errors.As(err, &skipped) || len(skipped.Errors) != 1 || skipped.Errors[0].Entry != "bad.txt" {
+			t.Errorf("expected bad.txt to be skipped, got: %v", err)
+		}
+	})
+
+	t.Run("ArchiveAsync", func(t *testing.T) {
+		errOpen := errors.New("cannot open")
+		bad := memFile("bad.txt", nil)
+		bad.Open = func() (fs.File, error) { return nil, errOpen }
+		files := []FileInfo{memFile("a.txt", []byte("first")), bad, memFile("c.txt", []byte("third"))}
+
+		for _, tc := range []struct {
+			format  ArchiverAsync
+			results []error
+		}{
+			{format: Zip{}, results: []error{nil, errOpen, errOpen}},
+			{format: Zip{ContinueOnError: true}, results: []error{nil, errOpen, nil}},
+			{format: Tar{}, results: []error{nil, errOpen, errOpen}},
+			{format: Tar{ContinueOnError: true}, results: []error{nil, errOpen, nil}},
+		} {
+			jobs := make(chan ArchiveAsyncJob)
+			done := make(chan error)
+			go func() { done <- tc.format.ArchiveAsync(ctx, io.Discard, jobs) }()
+			for i, file := range files {
+				result := make(chan error)
+				jobs <- ArchiveAsyncJob{File: file, Result: result}
+				if err := <-result; !errors.Is(err, tc.results[i]) || (err != nil) != (tc.results[i] != nil) {
+					t.Errorf("%T: job %d: expected %v, got: %v", tc.format, i, tc.results[i], err)
+				}
+			}
+			close(jobs)
+			if err := <-done; !errors.Is(err, errOpen) {
+				t.Errorf("%T: expected error from ArchiveAsync, got: %v", tc.format, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,519 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExtractToDisk extracts the contents of archive, which must be readable by
+// format, into the directory destDir on disk. The directory is created if it
+// does not exist. Extraction will adhere to the settings specified in options;
+// a nil options value uses default settings.
+//
+// Names of entries in an archive cannot be trusted, so every entry is resolved
+// to a path inside destDir before anything is written. Entries with absolute
+// paths have their leading separators removed (like the tar command does),
+// and entries that would resolve to a path outside destDir, for example by
+// using ".." components (a "zip slip"), are refused with ErrInsecurePath.
+// Symbolic links are only created if their target stays within destDir, even
+// when it goes through links that were already extracted, and files are never
+// written through a symbolic link that already exists on disk, which prevents
+// an archive from first creating a link that points outside destDir and then
+// writing through it.
+//
+// This function is used primarily when extracting an archive to a folder on
+// disk; for more control over how each file is handled, call Extract on the
+// format directly.
+func ExtractToDisk(ctx context.Context, format Extractor, archive io.Reader, destDir string, options *ToDiskOptions) error {
+	if options == nil {
+		options = new(ToDiskOptions)
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("creating destination directory: %w", err)
+	}
+	root, err := filepath.Abs(destDir)
+	if err != nil {
+		return fmt.Errorf("resolving destination directory: %w", err)
+	}
+
+	x := diskExtractor{root: root, options: options}
+
+	err = format.Extract(ctx, archive, x.extractFile)
+	if err != nil {
+		return err
+	}
+
+	// setting permissions and modification times on directories is done
+	// last, because creating files within them would otherwise update their
+	// mtimes, and read-only directories couldn't have their contents written;
+	// the deepest go first so that a parent's permissions can't lock us out
+	if !options.ClearAttributes {
+		slices.SortStableFunc(x.dirAttrs, func(a, b dirAttrs) int {
+			return pathDepth(b.path) - pathDepth(a.path)
+		})
+		for _, da := range x.dirAttrs {
+			if err := os.Chmod(da.path, da.mode); err != nil {
+				return fmt.Errorf("setting permissions of directory %s: %w", da.path, err)
+			}
+			if err := os.Chtimes(da.path, da.modTime, da.modTime); err != nil {
+				return fmt.Errorf("setting modification time of directory %s: %w", da.path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ToDiskOptions specifies various options for extracting files to disk.
+type ToDiskOptions struct {
+	// What to do if a file being extracted already exists on disk.
+	// The default is to return an error. Existing directories are
+	// always merged with directories in the archive.
+	Overwrite OverwritePolicy
+
+	// Number of leading path components to remove from the name of
+	// each entry in the archive, like tar's --strip-components flag.
+	// Entries that have no components left after stripping are skipped.
+	StripComponents int
+
+	// If set, only these files and directories (and their contents)
+	// will be extracted. The names are matched against the entry
+	// names as they appear in the archive, before stripping any
+	// path components.
+	Files []string
+
+	// If true, file permissions and modification times from the
+	// archive will not be restored; files and directories will be
+	// created with default permissions and the current time.
+	ClearAttributes bool
+}
+
+// OverwritePolicy determines what happens when a file being
+// extracted to disk already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteError aborts the extraction with an error that
+	// wraps fs.ErrExist.
+	OverwriteError OverwritePolicy = iota
+
+	// OverwriteSkip leaves the existing file alone and does
+	// not extract the file from the archive.
+	OverwriteSkip
+
+	// OverwriteReplace removes the existing file and replaces
+	// it with the file from the archive.
+	OverwriteReplace
+
+	// OverwriteRename extracts the file from the archive using
+	// a new, unused name, like "file (1).txt".
+	OverwriteRename
+)
+
+// ErrInsecurePath is returned when an entry in an archive would be
+// written outside of the destination directory.
+var ErrInsecurePath = errors.New("insecure file path")
+
+// diskExtractor handles the files of an archive being extracted to disk.
+type diskExtractor struct {
+	root     string
+	options  *ToDiskOptions
+	dirAttrs []dirAttrs
+}
+
+// dirAttrs is a directory on disk and the permissions and
+// modification time it should have once extraction is complete.
+type dirAttrs struct {
+	path    string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// pathDepth returns how deep the cleaned path p is by counting its separators.
+func pathDepth(p string) int {
+	return strings.Count(p, string(filepath.Separator))
+}
+
+func (x *diskExtractor) extractFile(ctx context.Context, file FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !fileIsIncluded(x.options.Files, file.NameInArchive) {
+		// no need to walk into directories that nothing is extracted from
+		if file.IsDir() && !x.containsIncludedFile(file.NameInArchive) {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	relPath, err := x.localPath(file.NameInArchive)
+	if err != nil {
+		return err
+	}
+	if relPath == "" {
+		return nil // all path components were stripped
+	}
+	if err := x.checkParents(relPath); err != nil {
+		return err
+	}
+	target := filepath.Join(x.root, relPath)
+
+	if file.IsDir() {
+		return x.extractDir(target, file)
+	}
+
+	// regular files, symlinks and hard links may collide with existing files
+	target, err = x.resolveExisting(target)
+	if err != nil || target == "" {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("creating parent directory: %w", err)
+	}
+
+	switch {
+	case isSymlink(file):
+		return x.extractSymlink(target, relPath, file)
+	case isHardLink(file):
+		return x.extractHardLink(target, file)
+	case file.Mode().IsRegular():
+		return x.extractRegularFile(ctx, target, file)
+	}
+
+	// devices, pipes, sockets, etc. are not extracted
+	return nil
+}
+
+// containsIncludedFile returns true if any of the files to
+// extract are within the directory dir in the archive.
+func (x *diskExtractor) containsIncludedFile(dir string) bool {
+	dirPrefix := strings.TrimSuffix(dir, "/") + "/"
+	for _, fn := range x.options.Files {
+		if strings.HasPrefix(fn, dirPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// localPath converts nameInArchive to a relative path using the platform's
+// separators, applying StripComponents. An empty path is returned if no
+// components remain. ErrInsecurePath is returned if the path would escape
+// the destination directory.
+func (x *diskExtractor) localPath(nameInArchive string) (string, error) {
+	// absolute paths are made relative, like the tar command does
+	name := strings.TrimLeft(nameInArchive, "/")
+	name = path.Clean(name)
+	if name == "." {
+		return "", nil
+	}
+
+	if x.options.StripComponents > 0 {
+		parts := strings.Split(name, "/")
+		if len(parts) <= x.options.StripComponents {
+			return "", nil
+		}
+		name = path.Join(parts[x.options.StripComponents:]...)
+	}
+
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("%s: %w", nameInArchive, ErrInsecurePath)
+	}
+	return local, nil
+}
+
+// checkParents ensures that none of the parent directories of relPath that
+// already exist on disk are symbolic links or otherwise not directories, so
+// that nothing is ever written through a link. The last path component is
+// not checked.
+func (x *diskExtractor) checkParents(relPath string) error {
+	current := x.root
+	parts := strings.Split(filepath.Dir(relPath), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil // the rest of the path will be created by us
+		}
+		if err != nil {
+			return err
+		}
+		if isSymlink(info) {
+			return fmt.Errorf("%s: parent directory is a symbolic link: %w", relPath, ErrInsecurePath)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s: parent is not a directory", relPath)
+		}
+	}
+	return nil
+}
+
+// resolveExisting applies the overwrite policy if a file already exists at
+// target. It returns the path to extract the file to, or an empty path if the
+// file should be skipped.
+func (x *diskExtractor) resolveExisting(target string) (string, error) {
+	info, err := os.Lstat(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return target, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch x.options.Overwrite {
+	case OverwriteSkip:
+		return "", nil
+
+	case OverwriteReplace:
+		if info.IsDir() {
+			return "", fmt.Errorf("%s: cannot replace existing directory with a file: %w", target, fs.ErrExist)
+		}
+		// remove (rather than truncate) so that if it is a link,
+		// we don't write to whatever the link points to
+		if err := os.Remove(target); err != nil {
+			return "", fmt.Errorf("removing existing file: %w", err)
+		}
+		return target, nil
+
+	case OverwriteRename:
+		ext := filepath.Ext(target)
+		base := strings.TrimSuffix(target, ext)
+		for i := 1; ; i++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+			if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+				return candidate, nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return "", fmt.Errorf("%s: %w", target, fs.ErrExist)
+}
+
+func (x *diskExtractor) extractDir(target string, file FileInfo) error {
+	info, err := os.Lstat(target)
+	if err == nil && !info.IsDir() {
+		return fmt.Errorf("%s: cannot create directory over existing file: %w", target, fs.ErrExist)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	if x.options.ClearAttributes {
+		return nil
+	}
+	// the directory stays writable until all entries are extracted
+	x.dirAttrs = append(x.dirAttrs, dirAttrs{target, file.Mode().Perm(), file.ModTime()})
+	return nil
+}
+
+func (x *diskExtractor) extractRegularFile(ctx context.Context, target string, file FileInfo) error {
+	perm := fs.FileMode(0644)
+	if !x.options.ClearAttributes {
+		perm = file.Mode().Perm()
+	}
+
+	// O_EXCL guarantees we create a new file rather than following
+	// a link that may have been placed there in the meantime
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("creating file: %w", err)
+	}
+	if file.SparseData != nil {
+		err = copySparseFile(ctx, file, out)
+	} else {
+		err = openAndCopyFile(ctx, file, out)
+	}
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("%s: writing file: %w", file.NameInArchive, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("%s: closing file: %w", file.NameInArchive, err)
+	}
+
+	if x.options.ClearAttributes {
+		return nil
+	}
+	if err := os.Chmod(target, perm); err != nil {
+		return fmt.Errorf("setting permissions of file: %w", err)
+	}
+	if modTime := file.ModTime(); !modTime.IsZero() {
+		if err := os.Chtimes(target, modTime, modTime); err != nil {
+			return fmt.Errorf("setting modification time of file: %w", err)
+		}
+	}
+	return nil
+}
+
+// copySparseFile copies the contents of the sparse file to out, seeking
+// over its holes instead of writing them, so that they're holes on disk.
+func copySparseFile(ctx context.Context, file FileInfo, out *os.File) error {
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := contextReadCloser{ctx, f}
+	var pos int64
+	for _, region := range file.SparseData {
+		if region.Offset < pos || region.Length < 0 || region.Offset+region.Length > file.Size() {
+			return fmt.Errorf("invalid sparse region of %d bytes at offset %d", region.Length, region.Offset)
+		}
+		// the hole before the region is read as zeros
+		if _, err := io.CopyN(io.Discard, r, region.Offset-pos); err != nil {
+			return err
+		}
+		if _, err := out.Seek(region.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(out, r, region.Length); err != nil {
+			return err
+		}
+		pos = region.Offset + region.Length
+	}
+	// the hole at the end, if any, is made by extending the file
+	return out.Truncate(file.Size())
+}
+
+func (x *diskExtractor) extractSymlink(target, relPath string, file FileInfo) error {
+	if file.LinkTarget == "" {
+		return fmt.Errorf("%s: symbolic link has no target", file.NameInArchive)
+	}
+
+	// the link target is relative to the directory the link is in,
+	// and must not point outside the destination directory
+	linkTarget := filepath.FromSlash(file.LinkTarget)
+	if filepath.IsAbs(linkTarget) {
+		return fmt.Errorf("%s: symbolic link target %s: %w", file.NameInArchive, file.LinkTarget, ErrInsecurePath)
+	}
+	inside, err := x.linkStaysInside(relPath, linkTarget)
+	if err != nil {
+		return fmt.Errorf("%s: resolving symbolic link target: %w", file.NameInArchive, err)
+	}
+	if !inside {
+		return fmt.Errorf("%s: symbolic link target %s: %w", file.NameInArchive, file.LinkTarget, ErrInsecurePath)
+	}
+
+	if err := os.Symlink(linkTarget, target); err != nil {
+		return fmt.Errorf("creating symbolic link: %w", err)
+	}
+	return nil
+}
+
+// linkStaysInside reports whether linkTarget, the target of a symbolic link
+// at relPath, resolves to a path within the destination directory. Links
+// already on disk are followed, since a lexically harmless target can go
+// through them, like "a/x/../.." when a is a link to ".". A link may be
+// created later where the target names something that doesn't exist yet,
+// so ".." is not allowed after such a component.
+func (x *diskExtractor) linkStaysInside(relPath, linkTarget string) (bool, error) {
+	sep := string(filepath.Separator)
+	var resolved []string // components of the resolved path, relative to root
+	if dir := filepath.Dir(relPath); dir != "." {
+		resolved = strings.Split(dir, sep)
+	}
+	pending := strings.Split(linkTarget, sep)
+	var links int
+	var missing bool
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if missing || len(resolved) == 0 {
+				return false, nil
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		resolved = append(resolved, part)
+		if missing {
+			continue
+		}
+		current := filepath.Join(x.root, filepath.Join(resolved...))
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = true
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !isSymlink(info) {
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return false, nil
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			return false, err
+		}
+		if filepath.IsAbs(target) {
+			return false, nil
+		}
+		// the link's target takes its place in what's left to resolve
+		resolved = resolved[:len(resolved)-1]
+		pending = append(strings.Split(target, sep), pending...)
+	}
+	return true, nil
+}
+
+// maxSymlinks is how many links are followed when resolving
+// a link target, after which it's assumed to be a loop.
+const maxSymlinks = 255
+
+func (x *diskExtractor) extractHardLink(target string, file FileInfo) error {
+	// hard link targets are paths within the archive
+	relLinkTarget, err := x.localPath(file.LinkTarget)
+	if err != nil {
+		return fmt.Errorf("%s: hard link target: %w", file.NameInArchive, err)
+	}
+	if relLinkTarget == "" {
+		return fmt.Errorf("%s: hard link target %s was stripped", file.NameInArchive, file.LinkTarget)
+	}
+	if err := x.checkParents(relLinkTarget); err != nil {
+		return err
+	}
+	linkTarget := filepath.Join(x.root, relLinkTarget)
+
+	// linking to a symlink could be used to escape the destination
+	info, err := os.Lstat(linkTarget)
+	if err != nil {
+		return fmt.Errorf("%s: hard link target: %w", file.NameInArchive, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: hard link target %s is not a regular file: %w", file.NameInArchive, file.LinkTarget, ErrInsecurePath)
+	}
+
+	if err := os.Link(linkTarget, target); err != nil {
+		return fmt.Errorf("creating hard link: %w", err)
+	}
+	return nil
+}
+
+// isHardLink returns true if the file is a hard link. Only
+// tar archives are known to store hard links.
+func isHardLink(file FileInfo) bool {
+	hdr, ok := file.Header.(*tar.Header)
+	return ok && hdr.Typeflag == tar.TypeLink
+}
@@ -0,0 +1,115 @@
+package archives_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+func TestExtractToDiskReadOnlyDirs(t *testing.T) {
+	if os.Geteuid() == 0 {
+		// root can write into read-only directories, which would hide
+		// the failure, so run this test again as an unprivileged user
+		runUnprivileged(t)
+		return
+	}
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := makeTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "ro/", Typeflag: tar.TypeDir, Mode: 0555, ModTime: modTime}},
+		{hdr: tar.Header{Name: "ro/file.txt", Typeflag: tar.TypeReg, Mode: 0644, ModTime: modTime}, content: "hello"},
+		{hdr: tar.Header{Name: "ro/.ssh/", Typeflag: tar.TypeDir, Mode: 0500, ModTime: modTime}},
+		{hdr: tar.Header{Name: "ro/.ssh/id_ed25519", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, content: "key"},
+	})
+
+	dest := t.TempDir()
+	t.Cleanup(func() {
+		// make the directories writable again so they can be removed
+		filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+	})
+	err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, nil)
+	if err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+
+	verifyFileContent(t, dest, "ro/file.txt", "hello")
+	verifyFileContent(t, dest, "ro/.ssh/id_ed25519", "key")
+	for name, mode := range map[string]fs.FileMode{"ro": 0555, "ro/.ssh": 0500} {
+		info, err := os.Stat(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: expected mode %s, got %s", name, mode, info.Mode().Perm())
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s: expected modification time %s, got %s", name, modTime, info.ModTime())
+		}
+	}
+}
+
+// runUnprivileged runs the current test in a copy of the test
+// binary as the user nobody, and fails if that run fails.
+func runUnprivileged(t *testing.T) {
+	t.Helper()
+
+	// the test binary is usually in a directory only we can read
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "archives-unprivileged-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, filepath.Base(exe))
+	if err := copyExecutable(exe, bin); err != nil {
+		t.Fatal(err)
+	}
+
+	const nobody = 65534
+	cmd := exec.Command(bin, "-test.run=^"+t.Name()+"$", "-test.count=1")
+	cmd.Dir = dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: nobody, Gid: nobody},
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running as an unprivileged user: %v\n%s", err, out)
+	}
+}
+
+func copyExecutable(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,233 @@
+package archives_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mholt/archives"
+)
+
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.content))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("writing header for %s: %v", hdr.Name, err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("writing content for %s: %v", hdr.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractToDisk(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := makeTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "top/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: modTime}},
+		{hdr: tar.Header{Name: "top/a.txt", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, content: "hello"},
+		{hdr: tar.Header{Name: "top/sub/b.txt", Typeflag: tar.TypeReg, ModTime: modTime}, content: "world"},
+		{hdr: tar.Header{Name: "/top/abs.txt", Typeflag: tar.TypeReg, ModTime: modTime}, content: "abs"},
+	})
+
+	dest := t.TempDir()
+	err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, &archives.ToDiskOptions{
+		StripComponents: 1,
+	})
+	if err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+
+	verifyFileContent(t, dest, "a.txt", "hello")
+	verifyFileContent(t, dest, "sub/b.txt", "world")
+	verifyFileContent(t, dest, "abs.txt", "abs")
+
+	info, err := os.Stat(filepath.Join(dest, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("expected modification time %s, got %s", modTime, info.ModTime())
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", info.Mode())
+	}
+}
+
+func TestExtractToDiskInsecurePaths(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name: "zip slip",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "../evil.txt", Typeflag: tar.TypeReg}, content: "evil"},
+			},
+		},
+		{
+			name: "nested zip slip",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "a/../../evil.txt", Typeflag: tar.TypeReg}, content: "evil"},
+			},
+		},
+		{
+			name: "symlink escaping destination",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+			},
+		},
+		{
+			name: "write through symlink",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "."}},
+				{hdr: tar.Header{Name: "dir/evil.txt", Typeflag: tar.TypeReg}, content: "evil"},
+			},
+		},
+		{
+			name: "symlink through extracted symlink",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "x/", Typeflag: tar.TypeDir, Mode: 0755}},
+				{hdr: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+				{hdr: tar.Header{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "a/x/../.."}},
+			},
+		},
+		{
+			name: "symlink through symlink extracted later",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "x/", Typeflag: tar.TypeDir, Mode: 0755}},
+				{hdr: tar.Header{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "a/x/../.."}},
+				{hdr: tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."}},
+			},
+		},
+		{
+			name: "hard link escaping destination",
+			entries: []tarEntry{
+				{hdr: tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../outside.txt"}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("symlinks require privileges on Windows")
+			}
+			archive := makeTar(t, tc.entries)
+			dest := t.TempDir()
+			err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, nil)
+			if !errors.Is(err, archives.ErrInsecurePath) {
+				t.Errorf("expected ErrInsecurePath, got: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(filepath.Dir(dest), "evil.txt")); err == nil {
+				t.Errorf("file was written outside of destination")
+			}
+		})
+	}
+}
+
+func TestExtractToDiskChainedSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on Windows")
+	}
+	archive := makeTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "lib/real.txt", Typeflag: tar.TypeReg}, content: "real"},
+		{hdr: tar.Header{Name: "lib/current", Typeflag: tar.TypeSymlink, Linkname: "real.txt"}},
+		{hdr: tar.Header{Name: "bin/link", Typeflag: tar.TypeSymlink, Linkname: "../lib/current"}},
+		{hdr: tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "lib"}},
+		{hdr: tar.Header{Name: "bin/deep", Typeflag: tar.TypeSymlink, Linkname: "../up/../bin/link"}},
+	})
+
+	dest := t.TempDir()
+	err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, nil)
+	if err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+	verifyFileContent(t, dest, "bin/link", "real")
+	verifyFileContent(t, dest, "bin/deep", "real")
+}
+
+func TestExtractToDiskOverwrite(t *testing.T) {
+	archive := makeTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "file.txt", Typeflag: tar.TypeReg}, content: "new"},
+	})
+
+	for _, tc := range []struct {
+		policy    archives.OverwritePolicy
+		expectErr error
+		expect    map[string]string
+	}{
+		{policy: archives.OverwriteError, expectErr: fs.ErrExist, expect: map[string]string{"file.txt": "old"}},
+		{policy: archives.OverwriteSkip, expect: map[string]string{"file.txt": "old"}},
+		{policy: archives.OverwriteReplace, expect: map[string]string{"file.txt": "new"}},
+		{policy: archives.OverwriteRename, expect: map[string]string{"file.txt": "old", "file (1).txt": "new"}},
+	} {
+		dest := t.TempDir()
+		createFile(t, filepath.Join(dest, "file.txt"), "old")
+
+		err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, &archives.ToDiskOptions{
+			Overwrite: tc.policy,
+		})
+		if tc.expectErr == nil && err != nil {
+			t.Errorf("policy %d: unexpected error: %v", tc.policy, err)
+		} else if tc.expectErr != nil && !errors.Is(err, tc.expectErr) {
+			t.Errorf("policy %d: expected error %v, got %v", tc.policy, tc.expectErr, err)
+		}
+		for name, content := range tc.expect {
+			verifyFileContent(t, dest, name, content)
+		}
+	}
+}
+
+func TestExtractToDiskSelectedFiles(t *testing.T) {
+	archive := makeTar(t, []tarEntry{
+		{hdr: tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "a/1.txt", Typeflag: tar.TypeReg}, content: "1"},
+		{hdr: tar.Header{Name: "b/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: tar.Header{Name: "b/2.txt", Typeflag: tar.TypeReg}, content: "2"},
+		{hdr: tar.Header{Name: "b/3.txt", Typeflag: tar.TypeReg}, content: "3"},
+	})
+
+	dest := t.TempDir()
+	err := archives.ExtractToDisk(context.Background(), archives.Tar{}, bytes.NewReader(archive), dest, &archives.ToDiskOptions{
+		Files: []string{"b/3.txt"},
+	})
+	if err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+
+	verifyFileContent(t, dest, "b/3.txt", "3")
+	for _, name := range []string{"a", "b/2.txt"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to not be extracted", name)
+		}
+	}
+}
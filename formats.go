@@ -8,25 +8,20 @@ import (
 	"io"
 	"path"
 	"path/filepath"
-	"strings"
+	"sort"
 )
 
-// RegisterFormat registers a format. It should be called during init.
-// Duplicate formats by name are not allowed and will panic.
-func RegisterFormat(format Format) {
-	name := strings.Trim(strings.ToLower(format.Extension()), ".")
-	if _, ok := formats[name]; ok {
-		panic("format " + name + " is already registered")
-	}
-	formats[name] = format
-}
-
 // Identify iterates the registered formats and returns the one that
 // matches the given filename and/or stream. It is capable of identifying
 // compressed files (.gz, .xz...), archive files (.tar, .zip...), and
 // compressed archive files (tar.gz, tar.bz2...). The returned Format
 // value can be type-asserted to ascertain its capabilities.
 //
+// Multiple layers of compression (.gz.xz, .tar.gz.xz...) are peeled off
+// one at a time by matching the decompressed stream, in which case the
+// compression is a CompressionChain. Formats that match the stream are
+// preferred over formats that only match the filename.
+//
 // If no matching formats were found, special error NoMatch is returned.
 //
 // If stream is nil then it will only match on file name and the
@@ -39,8 +34,7 @@ func RegisterFormat(format Format) {
 // value will be returned at the original position by seeking.
 func Identify(ctx context.Context, filename string, stream io.Reader) (Format, io.Reader, error) {
 	var compression Compression
-	var archival Archival
-	var extraction Extraction
+	var archive Format
 
 	filename = path.Base(filepath.ToSlash(filename))
 
@@ -49,45 +43,47 @@ func Identify(ctx context.Context, filename string, stream io.Reader) (Format, i
 		return nil, nil, err
 	}
 
-	// try compression format first, since that's the outer "layer" if combined
-	for name, format := range formats {
-		cf, isCompression := format.(Compression)
-		if !isCompression {
-			continue
+	// try compression formats first, since they are the outer "layers" if
+	// combined; peel off as many layers as there are, wrapping the input
+	// stream with decompression each time so we can see what is within
+	var layers []Compression // outermost first
+	for len(layers) < maxCompressionLayers {
+		if len(layers) > 0 {
+			// stop as soon as the decompressed stream is an archive
+			archive, err = identifyArchive(ctx, filename, rewindableStream, compression, false)
+			if err != nil {
+				return nil, rewindableStream.reader(), err
+			}
+			if archive != nil {
+				break
+			}
 		}
 
-		matchResult, err := identifyOne(ctx, format, filename, rewindableStream, nil)
+		// only the outermost layer may be matched by filename, since a
+		// name can't tell how many layers of compression there are
+		layer, err := identifyCompression(ctx, filename, rewindableStream, compression, len(layers) == 0)
 		if err != nil {
-			return nil, rewindableStream.reader(), fmt.Errorf("matching %s: %w", name, err)
+			return nil, rewindableStream.reader(), err
 		}
-
-		// if matched, wrap input stream with decompression
-		// so we can see if it contains an archive within
-		if matchResult.Matched() {
-			compression = cf
+		if layer == nil {
+			break
+		}
+		layers = append(layers, layer)
+		compression = compressionFromLayers(layers)
+		if rewindableStream == nil {
 			break
 		}
 	}
 
 	// try archival and extraction formats next
-	for name, format := range formats {
-		ar, isArchive := format.(Archival)
-		ex, isExtract := format.(Extraction)
-		if !isArchive && !isExtract {
-			continue
-		}
-
-		matchResult, err := identifyOne(ctx, format, filename, rewindableStream, compression)
+	if archive == nil {
+		archive, err = identifyArchive(ctx, filename, rewindableStream, compression, true)
 		if err != nil {
-			return nil, rewindableStream.reader(), fmt.Errorf("matching %s: %w", name, err)
-		}
-
-		if matchResult.Matched() {
-			archival = ar
-			extraction = ex
-			break
+			return nil, rewindableStream.reader(), err
 		}
 	}
+	archival, _ := archive.(Archival)
+	extraction, _ := archive.(Extraction)
 
 	// the stream should be rewound by identifyOne; then return the most specific type of match
 	bufferedStream := rewindableStream.reader()
@@ -111,6 +107,189 @@ func Identify(ctx context.Context, filename string, stream io.Reader) (Format, i
 	}
 }
 
+// IdentifyAll is like Identify, but instead of stopping at the first match,
+// it matches every registered format against the filename and stream and
+// returns all candidates that matched, most confident first. This can reveal
+// ambiguous inputs, like a file that is valid in more than one format. Unlike
+// Identify, only the outermost layer of the input is matched: a compressed
+// archive yields a candidate for its compression format only.
+//
+// If no formats matched, the returned slice is empty and the error is NoMatch.
+// The returned io.Reader behaves like the one returned by Identify.
+func IdentifyAll(ctx context.Context, filename string, stream io.Reader) ([]Candidate, io.Reader, error) {
+	filename = path.Base(filepath.ToSlash(filename))
+
+	rewindableStream, err := newRewindReader(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var candidates []Candidate
+	for _, rf := range registeredFormats() {
+		name, format := rf.name, rf.format
+		matchResult, err := identifyOne(ctx, format, filename, rewindableStream, nil)
+		if err != nil {
+			return nil, rewindableStream.reader(), fmt.Errorf("matching %s: %w", name, err)
+		}
+		if matchResult.Matched() {
+			candidates = append(candidates, Candidate{
+				Format:      format,
+				MatchResult: matchResult,
+				Confidence:  matchConfidence(format, matchResult),
+			})
+		}
+	}
+
+	// formats were tried in order of priority, which breaks ties
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+
+	if len(candidates) == 0 {
+		return nil, rewindableStream.reader(), NoMatch
+	}
+	return candidates, rewindableStream.reader(), nil
+}
+
+// Candidate is a format that matched the input to IdentifyAll.
+type Candidate struct {
+	Format Format
+
+	// How the format matched: by name, stream, or both.
+	MatchResult
+
+	// How likely it is that the input is actually in this format,
+	// from 0 to 1. Matching the stream is much stronger evidence
+	// than matching the filename, and some formats can be matched
+	// more reliably than others: a long magic number is rarely a
+	// coincidence, but a two-byte header or a heuristic often is.
+	Confidence float64
+}
+
+// Confidence that an input is in a format when matched by name only.
+const nameMatchConfidence = 0.3
+
+// matchConfidence returns the confidence that an input is in format,
+// given how it matched.
+func matchConfidence(format Format, mr MatchResult) float64 {
+	var confidence float64
+	if mr.ByStream {
+		confidence = streamMatchConfidence(format)
+	}
+	if mr.ByName {
+		// independent evidence: the input is misidentified
+		// only if both the stream and name matches are wrong
+		confidence = 1 - (1-confidence)*(1-nameMatchConfidence)
+	}
+	return confidence
+}
+
+// streamMatchConfidence returns the confidence that an input
+// is in format when its stream matched.
+func streamMatchConfidence(format Format) float64 {
+	switch format.(type) {
+	case Brotli:
+		return 0.5 // no magic number; matched by trying to decode
+	case Zlib:
+		return 0.6 // two-byte header with a check
+	case Gz, Bz2:
+		return 0.8 // short magic number
+	}
+	return 0.95
+}
+
+// minInnerLayerConfidence is the minimum confidence of a stream match for a
+// compression format to be identified as an inner layer of compression.
+const minInnerLayerConfidence = 0.8
+
+// identifyCompression returns the compression format that matches the stream
+// (after decompressing it with comp, if not nil), or nil if none match. If
+// allowByName is true and no format matches the stream, a format that matches
+// the filename is returned instead.
+func identifyCompression(ctx context.Context, filename string, stream *rewindReader, comp Compression, allowByName bool) (Compression, error) {
+	var byName Compression
+	for _, rf := range registeredFormats() {
+		name, format := rf.name, rf.format
+		cf, isCompression := format.(Compression)
+		if !isCompression {
+			continue
+		}
+		if comp != nil && streamMatchConfidence(format) < minInnerLayerConfidence {
+			// decompressed data is often arbitrary enough to pass a weak
+			// check, so inner layers must have a proper magic number
+			continue
+		}
+
+		matchResult, err := identifyOne(ctx, format, filename, stream, comp)
+		if err != nil {
+			return nil, fmt.Errorf("matching %s: %w", name, err)
+		}
+		if matchResult.ByStream {
+			return cf, nil
+		}
+		if matchResult.ByName && byName == nil {
+			byName = cf
+		}
+	}
+	if allowByName {
+		return byName, nil
+	}
+	return nil, nil
+}
+
+// identifyArchive is like identifyCompression, but for archival and
+// extraction formats.
+func identifyArchive(ctx context.Context, filename string, stream *rewindReader, comp Compression, allowByName bool) (Format, error) {
+	var byName Format
+	for _, rf := range registeredFormats() {
+		name, format := rf.name, rf.format
+		_, isArchive := format.(Archival)
+		_, isExtract := format.(Extraction)
+		if !isArchive && !isExtract {
+			continue
+		}
+
+		matchResult, err := identifyOne(ctx, format, filename, stream, comp)
+		if err != nil {
+			return nil, fmt.Errorf("matching %s: %w", name, err)
+		}
+		if matchResult.ByStream {
+			return format, nil
+		}
+		if matchResult.ByName && byName == nil {
+			byName = format
+		}
+	}
+	if allowByName {
+		return byName, nil
+	}
+	return nil, nil
+}
+
+// compressionFromLayers returns the layers of compression, given
+// outermost first, as a single Compression: the layer itself if
+// there is only one, or a CompressionChain otherwise.
+func compressionFromLayers(layers []Compression) Compression {
+	chain := make(CompressionChain, len(layers))
+	for i, layer := range layers {
+		chain[len(layers)-1-i] = layer
+	}
+	return compressionFromChain(chain)
+}
+
+// compressionFromChain returns chain as a single Compression: nil if
+// it is empty, the only layer if there is just one, or the chain itself
+// otherwise.
+func compressionFromChain(chain CompressionChain) Compression {
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return chain
+}
+
 func identifyOne(ctx context.Context, format Format, filename string, stream *rewindReader, comp Compression) (mr MatchResult, err error) {
 	defer stream.rewind()
 
@@ -134,7 +313,7 @@ func identifyOne(ctx context.Context, format Format, filename string, stream *re
 		// Make sure we pass a nil io.Reader not a *rewindReader(nil)
 		var r io.Reader
 		if stream != nil {
-			r = stream
+			r = stream.matchReader()
 		}
 		mr, err = format.Match(ctx, filename, r)
 	}
@@ -177,10 +356,13 @@ func readAtMost(stream io.Reader, n int) ([]byte, error) {
 // format on top of an archival/extraction format and provides both
 // functionalities in a single type, allowing archival and extraction
 // operations transparently through compression and decompression. However,
-// compressed archives have some limitations; for example, files cannot be
-// inserted/appended because of complexities with modifying existing
-// compression state (perhaps this could be overcome, but I'm not about to
-// try it).
+// compressed archives have some limitations; for example, files can only be
+// inserted/appended if the compression format is a Concatenator, since
+// existing compression state can't be modified, so the files are compressed
+// as a new stream.
+//
+// For archives with more than one layer of compression, like .tar.gz.xz,
+// use a CompressionChain as the Compression.
 type CompressedArchive struct {
 	Archival
 	Extraction
@@ -249,12 +431,14 @@ func (ca CompressedArchive) Archive(ctx context.Context, output io.Writer, files
 		return fmt.Errorf("no archival format")
 	}
 	if ca.Compression != nil {
-		wc, err := ca.Compression.OpenWriter(output)
+		cw := &countingWriter{Writer: output}
+		ctx = withCompressedBytes(ctx, cw.n.Load)
+		wc, err := ca.Compression.OpenWriter(cw)
 		if err != nil {
 			return err
 		}
 		defer wc.Close()
-		output = wc
+		output = contextWriteCloser{ctx, wc}
 	}
 	return ca.Archival.Archive(ctx, output, files)
 }
@@ -269,12 +453,14 @@ func (ca CompressedArchive) ArchiveAsync(ctx context.Context, output io.Writer,
 		return fmt.Errorf("%T archive does not support async writing", ca.Archival)
 	}
 	if ca.Compression != nil {
-		wc, err := ca.Compression.OpenWriter(output)
+		cw := &countingWriter{Writer: output}
+		ctx = withCompressedBytes(ctx, cw.n.Load)
+		wc, err := ca.Compression.OpenWriter(cw)
 		if err != nil {
 			return err
 		}
 		defer wc.Close()
-		output = wc
+		output = contextWriteCloser{ctx, wc}
 	}
 	return do.ArchiveAsync(ctx, output, jobs)
 }
@@ -285,16 +471,74 @@ func (ca CompressedArchive) Extract(ctx context.Context, sourceArchive io.Reader
 		return fmt.Errorf("no extraction format")
 	}
 	if ca.Compression != nil {
-		rc, err := ca.Compression.OpenReader(sourceArchive)
+		cr := &countingReader{Reader: sourceArchive}
+		ctx = withCompressedBytes(ctx, cr.n.Load)
+		rc, err := ca.openDecompressor(cr)
 		if err != nil {
 			return err
 		}
 		defer rc.Close()
-		sourceArchive = rc
+		sourceArchive = contextReadCloser{ctx, rc}
 	}
 	return ca.Extraction.Extract(ctx, sourceArchive, handleFile)
 }
 
+// OpenArchiveReader returns a reader that iterates the entries of the compressed
+// archive while decompressing it. Closing the reader closes the decompressor.
+func (ca CompressedArchive) OpenArchiveReader(ctx context.Context, sourceArchive io.Reader) (ArchiveReader, error) {
+	if ca.Extraction == nil {
+		return nil, fmt.Errorf("no extraction format")
+	}
+	if ca.Compression == nil {
+		return OpenArchiveReader(ctx, ca.Extraction, sourceArchive)
+	}
+	rc, err := ca.openDecompressor(sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := OpenArchiveReader(ctx, ca.Extraction, contextReadCloser{ctx, rc})
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return compressedArchiveReader{ar, rc}, nil
+}
+
+// compressedArchiveReader is an ArchiveReader that
+// also closes the decompressor it reads from.
+type compressedArchiveReader struct {
+	ArchiveReader
+	decompressor io.Closer
+}
+
+func (r compressedArchiveReader) Close() error {
+	err := r.ArchiveReader.Close()
+	if err2 := r.decompressor.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// openDecompressor opens a decompressing reader over the compressed archive r.
+// If the extraction format has a maximum compression ratio configured, it is
+// enforced on the decompressed stream as a whole, since the compression of
+// the individual entries is not known.
+func (ca CompressedArchive) openDecompressor(r io.Reader) (io.ReadCloser, error) {
+	var maxRatio float64
+	if rl, ok := ca.Extraction.(resourceLimiter); ok {
+		maxRatio = rl.resourceLimits().MaxCompressionRatio
+	}
+	if maxRatio <= 0 {
+		return ca.Compression.OpenReader(r)
+	}
+	cr := &countingReader{Reader: r}
+	rc, err := ca.Compression.OpenReader(cr)
+	if err != nil {
+		return nil, err
+	}
+	return &ratioLimitedReader{ReadCloser: rc, cr: cr, maxRatio: maxRatio}, nil
+}
+
 // MatchResult returns true if the format was matched either
 // by name, stream, or both. Name usually refers to matching
 // by file extension, and stream usually refers to reading
@@ -389,6 +633,16 @@ func (rr *rewindReader) Read(p []byte) (n int, err error) {
 	return
 }
 
+// matchReader returns the reader to be matched by formats: the underlying
+// stream if it is an io.Seeker, in which case the stream isn't buffered and
+// formats can seek it too, or else rr.
+func (rr *rewindReader) matchReader() io.Reader {
+	if _, ok := rr.Reader.(io.Seeker); ok {
+		return rr.Reader
+	}
+	return rr
+}
+
 // rewind resets the stream to the beginning by causing
 // Read() to start reading from the beginning of the
 // stream, or, if buffering, the buffered bytes.
@@ -426,15 +680,13 @@ func (rr *rewindReader) reader() io.Reader {
 // NoMatch is a special error returned if there are no matching formats.
 var NoMatch = fmt.Errorf("no formats matched")
 
-// Registered formats.
-var formats = make(map[string]Format)
-
 // Interface guards
 var (
-	_ Format        = (*CompressedArchive)(nil)
-	_ Archiver      = (*CompressedArchive)(nil)
-	_ ArchiverAsync = (*CompressedArchive)(nil)
-	_ Extractor     = (*CompressedArchive)(nil)
-	_ Compressor    = (*CompressedArchive)(nil)
-	_ Decompressor  = (*CompressedArchive)(nil)
+	_ Format              = (*CompressedArchive)(nil)
+	_ Archiver            = (*CompressedArchive)(nil)
+	_ ArchiverAsync       = (*CompressedArchive)(nil)
+	_ Extractor           = (*CompressedArchive)(nil)
+	_ Compressor          = (*CompressedArchive)(nil)
+	_ Decompressor        = (*CompressedArchive)(nil)
+	_ ArchiveReaderOpener = (*CompressedArchive)(nil)
 )
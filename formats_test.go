@@ -87,7 +87,7 @@ func TestCompression(t *testing.T) {
 
 	var cannotIdentifyFromStream = map[string]bool{Brotli{}.Extension(): true}
 
-	for _, f := range formats {
+	for _, f := range Formats() {
 		// only test compressors
 		comp, ok := f.(Compression)
 		if !ok {
@@ -464,3 +464,39 @@ func TestIdentifyStreamNil(t *testing.T) {
 		t.Errorf("unexpected format found: expected=.tar.zst actual=%s", format.Extension())
 	}
 }
+
+func TestIdentifyAll(t *testing.T) {
+	ctx := context.Background()
+
+	// a tar archive whose first entry is named such that the archive
+	// also starts with the zip header, i.e. a tar/zip polyglot
+	buf := new(bytes.Buffer)
+	files := []FileInfo{memFile("PK\x03\x04polyglot", []byte("hello"))}
+	if err := (Tar{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates, stream, err := IdentifyAll(ctx, "upload.tar", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %v", candidates)
+	}
+	if _, ok := candidates[0].Format.(Tar); !ok || !candidates[0].ByName || !candidates[0].ByStream {
+		t.Errorf("expected tar matched by name and stream first, got %+v", candidates[0])
+	}
+	if _, ok := candidates[1].Format.(Zip); !ok || candidates[1].ByName || !candidates[1].ByStream {
+		t.Errorf("expected zip matched by stream second, got %+v", candidates[1])
+	}
+	if candidates[0].Confidence <= candidates[1].Confidence {
+		t.Errorf("expected tar to be more confident than zip, got %v and %v", candidates[0].Confidence, candidates[1].Confidence)
+	}
+	if read, err := io.ReadAll(stream); err != nil || !bytes.Equal(read, buf.Bytes()) {
+		t.Errorf("expected returned stream to be rewound (err=%v)", err)
+	}
+
+	if _, _, err := IdentifyAll(ctx, "", bytes.NewReader([]byte("nothing to see here"))); !errors.Is(err, NoMatch) {
+		t.Errorf("expected NoMatch, got: %v", err)
+	}
+}
@@ -284,6 +284,9 @@ func (DirFS) checkName(name, op string) error {
 // to be walked for every call to ReadDir() anyway, as archive contents are
 // often unordered). The first call to ReadDir(), i.e. near the start of the
 // walk, will be slow for large archives, but should be instantaneous after.
+// The index also answers Stat, and for uncompressed tar archives, it records
+// where the contents of each file are, so that Open reads them straight from
+// the archive, with support for seeking, instead of walking it again.
 // If you don't care about walking a file system in directory order, consider
 // calling Extract() on the underlying archive format type directly, which
 // walks the archive in entry order, without needing to do any sorting.
@@ -323,6 +326,10 @@ type ArchiveFS struct {
 	// amortizing cache speeds up walks (esp. ReadDir)
 	contents map[string]fs.FileInfo
 	dirs     map[string][]fs.DirEntry
+
+	// where the contents of the files of an uncompressed
+	// tar archive are, so they can be opened without a walk
+	offsets map[string]tarOffsets
 }
 
 // context always return a context, preferring f.Context if not nil.
@@ -352,6 +359,9 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 					return &dirFile{info: info, entries: entries}, nil
 				}
 			}
+			if offsets, ok := f.offsets[name]; ok {
+				return f.openSection(name, info, offsets)
+			}
 		} else {
 			if entries, found := f.dirs[name]; found {
 				return &dirFile{info: implicitDirInfo{implicitDirEntry{name}}, entries: entries}, nil
@@ -419,12 +429,18 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 	}
 
 	var decompressor io.ReadCloser
-	if decomp, ok := f.Format.(Decompressor); ok && decomp != nil {
+	if ca, ok := f.Format.(CompressedArchive); ok && ca.Compression != nil {
+		decompressor, err = ca.openDecompressor(inputStream)
+		if err != nil {
+			return nil, err
+		}
+		inputStream = contextReadCloser{f.context(), decompressor}
+	} else if decomp, ok := f.Format.(Decompressor); ok && decomp != nil {
 		decompressor, err = decomp.OpenReader(inputStream)
 		if err != nil {
 			return nil, err
 		}
-		inputStream = decompressor
+		inputStream = contextReadCloser{f.context(), decompressor}
 	}
 
 	// prepare the handler that we'll need if we have to iterate the
@@ -511,6 +527,38 @@ func (f ArchiveFS) Open(name string) (fs.File, error) {
 	return fsFile, nil
 }
 
+// openSection opens the file named name, whose contents are at offsets in
+// the archive, as a section of the archive, so that it can be read without
+// walking the archive, and read at offsets and sought.
+func (f ArchiveFS) openSection(name string, info fs.FileInfo, offsets tarOffsets) (fs.File, error) {
+	sf := sectionFile{info: info, ctx: f.context()}
+	if state := f.limits().newState(); state != nil {
+		sf.limits = &entryCount{state: state, name: name}
+	}
+	if f.Stream != nil {
+		sf.SectionReader = io.NewSectionReader(f.Stream, offsets.data, info.Size())
+		return sf, nil
+	}
+	archiveFile, err := os.Open(f.Path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	sf.SectionReader, sf.c = io.NewSectionReader(archiveFile, offsets.data, info.Size()), archiveFile
+	return sf, nil
+}
+
+// limits returns the limits of the archive's format, if it has any.
+func (f ArchiveFS) limits() Limits {
+	format := f.Format
+	if ca, ok := format.(CompressedArchive); ok {
+		format = ca.Extraction
+	}
+	if rl, ok := format.(resourceLimiter); ok {
+		return rl.resourceLimits()
+	}
+	return Limits{}
+}
+
 // Stat stats the named file from within the archive. If name is "." then
 // the archive file itself is statted and treated as a directory file.
 func (f ArchiveFS) Stat(name string) (fs.FileInfo, error) {
@@ -538,6 +586,9 @@ func (f ArchiveFS) Stat(name string) (fs.FileInfo, error) {
 		if info, ok := f.contents[name]; ok {
 			return info, nil
 		}
+		if _, ok := f.dirs[name]; ok {
+			return implicitDirInfo{implicitDirEntry{path.Base(name)}}, nil
+		}
 		return nil, &fs.PathError{Op: "stat", Path: name, Err: fmt.Errorf("stat(b) %s: %w", name, fs.ErrNotExist)}
 	}
 
@@ -609,6 +660,7 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 
 	f.contents = make(map[string]fs.FileInfo)
 	f.dirs = make(map[string][]fs.DirEntry)
+	f.offsets = make(map[string]tarOffsets)
 
 	var archiveFile *os.File
 	var err error
@@ -640,8 +692,14 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 			return &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
 		}
 
-		// index this file info for quick access
+		// index this file info for quick access, and where its contents
+		// are if they can be read straight from the archive
 		f.contents[file.NameInArchive] = file
+		if offsets, ok := tarOffsetsFromContext(ctx); ok && offsets.data >= 0 && file.Mode().IsRegular() {
+			f.offsets[file.NameInArchive] = offsets
+		} else {
+			delete(f.offsets, file.NameInArchive)
+		}
 
 		// amortize the DirEntry list per directory, and prefer the real entry's DirEntry over an implicit/fake
 		// one we may have created earlier; first try to find if it exists, and if so, replace the value;
@@ -700,12 +758,35 @@ func (f *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
 		// the whole thing anyway; so reset these to nil to avoid bugs
 		f.dirs = nil
 		f.contents = nil
+		f.offsets = nil
 		return nil, fmt.Errorf("extract: %w", err)
 	}
 
 	return f.dirs[name], nil
 }
 
+// ZipPrefix locates the data that precedes the archive, like the executable
+// stub of a self-extracting archive. The format must be Zip. See Zip.Prefix.
+func (f ArchiveFS) ZipPrefix() (ZipPrefix, error) {
+	z, ok := f.Format.(Zip)
+	if !ok {
+		return ZipPrefix{}, fmt.Errorf("%T is not a zip format", f.Format)
+	}
+	if f.Stream != nil {
+		return z.Prefix(f.Stream, f.Stream.Size())
+	}
+	archiveFile, err := os.Open(f.Path)
+	if err != nil {
+		return ZipPrefix{}, err
+	}
+	defer archiveFile.Close()
+	info, err := archiveFile.Stat()
+	if err != nil {
+		return ZipPrefix{}, err
+	}
+	return z.Prefix(archiveFile, info.Size())
+}
+
 // Sub returns an FS corresponding to the subtree rooted at dir.
 func (f *ArchiveFS) Sub(dir string) (fs.FS, error) {
 	if !fs.ValidPath(dir) {
@@ -1078,10 +1159,21 @@ func (info dirFileInfo) Mode() fs.FileMode { return info.FileInfo.Mode() | fs.Mo
 func (dirFileInfo) IsDir() bool            { return true }
 
 // fileInArchive represents a file that is opened from within an archive.
-// It implements fs.File.
+// It implements fs.File. If ctx is set, reads honor its cancellation, so
+// that reading a single large file can be interrupted.
 type fileInArchive struct {
 	io.ReadCloser
 	info fs.FileInfo
+	ctx  context.Context
+}
+
+func (af fileInArchive) Read(p []byte) (int, error) {
+	if af.ctx != nil {
+		if err := af.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	return af.ReadCloser.Read(p)
 }
 
 func (af fileInArchive) Stat() (fs.FileInfo, error) { return af.info, nil }
@@ -1,9 +1,11 @@
 package archives
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -14,6 +16,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -360,3 +363,117 @@ func TestFileSystem(t *testing.T) {
 		checkFS(t, fsys)
 	})
 }
+
+func TestArchiveFS_TarIndex(t *testing.T) {
+	ctx := context.Background()
+	contents := map[string][]byte{
+		"a.txt": []byte("hello"),
+		"dir/" + strings.Repeat("long", 50) + ".txt": bytes.Repeat([]byte("x"), 1000), // PAX header
+		"dir/sub/c.bin": bytes.Repeat([]byte{1, 2, 3}, 500),
+	}
+	var files []FileInfo
+	for _, name := range []string{"a.txt", "dir/" + strings.Repeat("long", 50) + ".txt", "dir/sub/c.bin"} {
+		files = append(files, memFile(name, contents[name]))
+	}
+	buf := new(bytes.Buffer)
+	if err := (Tar{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// each member's header is where it's recorded to be
+	var count int
+	err := Tar{}.Extract(ctx, bytes.NewReader(archive), func(ctx context.Context, file FileInfo) error {
+		count++
+		offsets, ok := tarOffsetsFromContext(ctx)
+		if !ok {
+			return fmt.Errorf("%s: no offsets", file.NameInArchive)
+		}
+		hdr, err := tar.NewReader(bytes.NewReader(archive[offsets.header:])).Next()
+		if err != nil || hdr.Name != file.NameInArchive {
+			t.Errorf("%s: expected a header at %d, got %v (%v)", file.NameInArchive, offsets.header, hdr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(files) {
+		t.Errorf("expected %d files, got %d", len(files), count)
+	}
+
+	filename := filepath.Join(t.TempDir(), "test.tar")
+	if err := os.WriteFile(filename, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, fsys := range []*ArchiveFS{
+		{Path: filename, Format: Tar{}},
+		{Stream: io.NewSectionReader(bytes.NewReader(archive), 0, int64(len(archive))), Format: Tar{}},
+	} {
+		if _, err := fsys.ReadDir("."); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := fsys.Stat("dir/sub"); err != nil || !info.IsDir() {
+			t.Errorf("expected the implicit directory dir/sub, got %v (%v)", info, err)
+		}
+		for name, want := range contents {
+			f, err := fsys.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, ok := f.(io.ReadSeeker)
+			if !ok {
+				t.Fatalf("%s: expected the file to be read from a section of the archive, got %T", name, f)
+			}
+			if _, err := rs.Seek(2, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(rs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want[2:]) {
+				t.Errorf("%s: contents do not match", name)
+			}
+			if info, err := f.Stat(); err != nil || info.Size() != int64(len(want)) {
+				t.Errorf("%s: expected size %d, got %v (%v)", name, len(want), info, err)
+			}
+			if err := f.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// files read from a section are limited, and honor cancellation, like others
+	cancelCtx, cancel := context.WithCancel(ctx)
+	fsys := &ArchiveFS{Path: filename, Format: Tar{Limits: Limits{MaxEntrySize: 100}}, Context: cancelCtx}
+	if _, err := fsys.ReadDir("."); err != nil {
+		t.Fatal(err)
+	}
+	for _, read := range []func(io.ReadSeeker) error{
+		func(rs io.ReadSeeker) error { _, err := io.ReadAll(rs); return err },
+		func(rs io.ReadSeeker) error { _, err := rs.(io.ReaderAt).ReadAt(make([]byte, 200), 0); return err },
+	} {
+		f, err := fsys.Open("dir/sub/c.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, ok := f.(io.ReadSeeker)
+		if !ok {
+			t.Fatalf("expected the file to be read from a section of the archive, got %T", f)
+		}
+		if err := read(rs); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected ErrLimitExceeded, got: %v", err)
+		}
+		f.Close()
+	}
+	f, err := fsys.Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cancel()
+	if _, err := io.ReadAll(f); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package archives
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -73,17 +76,51 @@ func (gz Gz) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 func (gz Gz) OpenReader(r io.Reader) (io.ReadCloser, error) {
 	if gz.Multithreaded {
 		gzR, err := pgzip.NewReader(r)
-		if gzR != nil && gz.DisableMultistream {
+		if err != nil {
+			return nil, classifyError("", err)
+		}
+		if gz.DisableMultistream {
 			gzR.Multistream(false)
 		}
-		return gzR, err
+		return newClassifyingReader(gzR, ""), nil
 	}
 
 	gzR, err := gzip.NewReader(r)
-	if gzR != nil && gz.DisableMultistream {
+	if err != nil {
+		return nil, classifyError("", err)
+	}
+	if gz.DisableMultistream {
 		gzR.Multistream(false)
 	}
-	return gzR, err
+	return newClassifyingReader(gzR, ""), nil
+}
+
+// Streams returns the offsets of the gzip members in r, which are found by
+// decompressing them, since members don't record their compressed size.
+func (gz Gz) Streams(r io.ReaderAt, size int64) ([]int64, error) {
+	if gz.DisableMultistream {
+		return nil, errors.New("reading concatenated members is disabled")
+	}
+	// the decompressor reads no more than it needs from a byte reader,
+	// so the position of the next member is what it hasn't read
+	cr := &countingReader{Reader: io.NewSectionReader(r, 0, size)}
+	br := bufio.NewReader(cr)
+	var offsets []int64
+	zr := new(gzip.Reader)
+	for {
+		offset := cr.n.Load() - int64(br.Buffered())
+		if offset == size {
+			return offsets, nil
+		}
+		if err := zr.Reset(br); err != nil {
+			return nil, fmt.Errorf("member at %d: %w", offset, classifyError("", err))
+		}
+		zr.Multistream(false)
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return nil, fmt.Errorf("member at %d: %w", offset, classifyError("", err))
+		}
+		offsets = append(offsets, offset)
+	}
 }
 
 // magic number at the beginning of gzip files
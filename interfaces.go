@@ -62,6 +62,19 @@ type Decompressor interface {
 	OpenReader(r io.Reader) (io.ReadCloser, error)
 }
 
+// Concatenator is a compression format whose compressed streams can be
+// concatenated, decompressing to the concatenation of their contents, as
+// gzip members, zstd and lz4 frames, and xz and bzip2 streams can. Files
+// can be inserted into archives compressed with a Concatenator, by
+// compressing them as a new stream; see CompressedArchive.Insert.
+type Concatenator interface {
+	Compression
+
+	// Streams returns the offsets at which the concatenated streams of
+	// compressed data in r, which is size bytes long, start, in order.
+	Streams(r io.ReaderAt, size int64) ([]int64, error)
+}
+
 // Archiver can create a new archive.
 type Archiver interface {
 	// Archive writes an archive file to output with the given files.
@@ -114,3 +127,29 @@ type Inserter interface {
 	// Context cancellation must be honored.
 	Insert(ctx context.Context, archive io.ReadWriteSeeker, files []FileInfo) error
 }
+
+// ArchiveReader reads the entries of an archive one at a time. It is
+// a pull-style alternative to Extractor's callback-driven walk.
+type ArchiveReader interface {
+	// Next advances to the next entry in the archive and returns it.
+	// At the end of the archive, Next returns io.EOF.
+	//
+	// The contents of an entry can be read by opening it, but they
+	// are only guaranteed to be valid until the next call to Next,
+	// as entries may be read from the same sequential stream.
+	Next() (FileInfo, error)
+
+	// Close releases any resources associated with the reader.
+	// It does not close the input stream.
+	Close() error
+}
+
+// ArchiveReaderOpener can open an archive for reading its entries
+// one at a time.
+type ArchiveReaderOpener interface {
+	// OpenArchiveReader returns a reader that iterates the entries
+	// of archive. The reader must be closed when finished.
+	//
+	// Context cancellation must be honored.
+	OpenArchiveReader(ctx context.Context, archive io.Reader) (ArchiveReader, error)
+}
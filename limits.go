@@ -0,0 +1,231 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/nwaples/rardecode/v2"
+)
+
+// Limits restricts the resources that reading an archive may consume. They
+// are a defense against "decompression bombs": small, malicious archives that
+// expand to enormous amounts of data or contain an enormous number of entries.
+//
+// Sizes are enforced by counting the bytes actually read from each entry
+// rather than by trusting the sizes recorded in the archive's headers, which
+// can be forged. When a limit is crossed, reading stops and an error wrapping
+// ErrLimitExceeded (specifically, a *LimitError) is returned.
+//
+// A zero value for any field means no limit.
+type Limits struct {
+	// Maximum number of entries that may be read from the archive.
+	MaxEntries int
+
+	// Maximum number of uncompressed bytes that may be read from
+	// all entries in the archive combined.
+	MaxTotalSize int64
+
+	// Maximum number of uncompressed bytes that may be read from
+	// any single entry.
+	MaxEntrySize int64
+
+	// Maximum ratio of uncompressed to compressed bytes for any
+	// single entry, if the archive format records compressed sizes
+	// (e.g. zip and rar); for compressed archives like .tar.gz,
+	// the ratio applies to the decompressed stream as a whole.
+	// Small amounts of data can legitimately be very compressible,
+	// so the ratio is only enforced after the first 1 MiB.
+	MaxCompressionRatio float64
+}
+
+// ErrLimitExceeded is returned when reading an archive would exceed one
+// of the configured Limits.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError describes which limit was exceeded. It wraps ErrLimitExceeded.
+type LimitError struct {
+	// The name of the entry being read when the limit
+	// was exceeded, if applicable.
+	Entry string
+
+	// The limit that was exceeded, e.g. "entries" or "entry size".
+	Limit string
+
+	// The value of the limit.
+	Max any
+}
+
+func (e *LimitError) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf("%s: %s %v: %v", e.Entry, ErrLimitExceeded, e.Limit, e.Max)
+	}
+	return fmt.Sprintf("%s %v: %v", ErrLimitExceeded, e.Limit, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrLimitExceeded }
+
+// ratioGracePeriod is the number of uncompressed bytes that can
+// be read before the maximum compression ratio is enforced.
+const ratioGracePeriod = 1 << 20
+
+// wrapHandler returns a FileHandler that enforces the limits on the files
+// passed to handleFile. If no limits are set, handleFile is returned as-is.
+func (l Limits) wrapHandler(handleFile FileHandler) FileHandler {
+	state := l.newState()
+	if state == nil {
+		return handleFile
+	}
+	return func(ctx context.Context, file FileInfo) error {
+		file, err := state.entry(file)
+		if err != nil {
+			return err
+		}
+		return handleFile(ctx, file)
+	}
+}
+
+// newState returns a new state for enforcing the limits
+// while reading one archive, or nil if no limits are set.
+func (l Limits) newState() *limitState {
+	if l == (Limits{}) {
+		return nil
+	}
+	return &limitState{Limits: l}
+}
+
+// limitState is the running count of resources used while reading one archive.
+type limitState struct {
+	Limits
+	entries   int
+	totalRead int64
+}
+
+// entry accounts for a new entry read from the archive, returning an error
+// if there are too many entries. The returned file is the same as the input,
+// except that reads from it will enforce the limits. A nil limitState
+// returns the file unmodified.
+func (state *limitState) entry(file FileInfo) (FileInfo, error) {
+	if state == nil {
+		return file, nil
+	}
+	state.entries++
+	if state.MaxEntries > 0 && state.entries > state.MaxEntries {
+		return file, &LimitError{Entry: file.NameInArchive, Limit: "entries", Max: state.MaxEntries}
+	}
+	if file.Open != nil {
+		open := file.Open
+		file.Open = func() (fs.File, error) {
+			f, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return &limitedFile{
+				File: f,
+				entryCount: entryCount{
+					state:          state,
+					name:           file.NameInArchive,
+					compressedSize: compressedSize(file),
+				},
+			}, nil
+		}
+	}
+	return file, nil
+}
+
+// limitedFile is an fs.File that counts the bytes read from
+// an entry and fails once a limit has been exceeded.
+type limitedFile struct {
+	fs.File
+	entryCount
+}
+
+func (lf *limitedFile) Read(p []byte) (int, error) {
+	n, err := lf.File.Read(p)
+	if err := lf.count(n); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+// entryCount is the count of bytes read from an entry.
+type entryCount struct {
+	state          *limitState
+	name           string
+	compressedSize int64 // 0 if unknown
+	read           int64
+}
+
+// count accounts for n more bytes read from the entry, returning
+// an error if that exceeds a limit.
+func (c *entryCount) count(n int) error {
+	c.read += int64(n)
+	c.state.totalRead += int64(n)
+
+	l := c.state.Limits
+	if l.MaxEntrySize > 0 && c.read > l.MaxEntrySize {
+		return &LimitError{Entry: c.name, Limit: "entry size", Max: l.MaxEntrySize}
+	}
+	if l.MaxTotalSize > 0 && c.state.totalRead > l.MaxTotalSize {
+		return &LimitError{Entry: c.name, Limit: "total size", Max: l.MaxTotalSize}
+	}
+	if l.MaxCompressionRatio > 0 && c.compressedSize > 0 && c.read > ratioGracePeriod &&
+		float64(c.read)/float64(c.compressedSize) > l.MaxCompressionRatio {
+		return &LimitError{Entry: c.name, Limit: "compression ratio", Max: l.MaxCompressionRatio}
+	}
+	return nil
+}
+
+// compressedSize returns the compressed size of the file as recorded
+// in its header, or 0 if the format does not record it.
+func compressedSize(file FileInfo) int64 {
+	switch hdr := file.Header.(type) {
+	case zip.FileHeader:
+		return int64(hdr.CompressedSize64)
+	case *rardecode.FileHeader:
+		return hdr.PackedSize
+	}
+	return 0
+}
+
+// resourceLimiter is implemented by formats that support Limits.
+type resourceLimiter interface {
+	resourceLimits() Limits
+}
+
+// ratioLimitedReader enforces a maximum compression ratio on a whole
+// decompressed stream. The compressed input is counted by cr.
+type ratioLimitedReader struct {
+	io.ReadCloser
+	cr       *countingReader
+	maxRatio float64
+	read     int64
+}
+
+func (r *ratioLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	if compressed := r.cr.n.Load(); r.read > ratioGracePeriod && compressed > 0 &&
+		float64(r.read)/float64(compressed) > r.maxRatio {
+		return n, &LimitError{Limit: "compression ratio", Max: r.maxRatio}
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read from the underlying reader.
+// The count is atomic, since some decompressors read their input in
+// a separate goroutine.
+type countingReader struct {
+	io.Reader
+	n atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.Reader.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,118 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+// memFile returns a FileInfo for an in-memory file with the given contents.
+func memFile(name string, contents []byte) FileInfo {
+	return FileInfo{
+		FileInfo:      memFileInfo{name: name, size: int64(len(contents))},
+		NameInArchive: name,
+		Open: func() (fs.File, error) {
+			return fileInArchive{io.NopCloser(bytes.NewReader(contents)), memFileInfo{name: name, size: int64(len(contents))}, nil}, nil
+		},
+	}
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string    { return fi.name }
+func (fi memFileInfo) Size() int64     { return fi.size }
+func (memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (memFileInfo) ModTime() time.Time { return time.Time{} }
+func (memFileInfo) IsDir() bool        { return false }
+func (memFileInfo) Sys() any           { return nil }
+
+// readAllEntries extracts the archive with format and reads every file in it.
+func readAllEntries(format Extractor, archive []byte) error {
+	return format.Extract(context.Background(), bytes.NewReader(archive), func(_ context.Context, f FileInfo) error {
+		if f.IsDir() {
+			return nil
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	})
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	zeros := make([]byte, 4<<20)
+	files := []FileInfo{
+		memFile("a.txt", []byte("hello")),
+		memFile("zeros.bin", zeros),
+		memFile("b.txt", []byte("world")),
+	}
+
+	zipBuf := new(bytes.Buffer)
+	if err := (Zip{Compression: 8}).Archive(ctx, zipBuf, files); err != nil {
+		t.Fatal(err)
+	}
+	tarGzBuf := new(bytes.Buffer)
+	if err := (CompressedArchive{Archival: Tar{}, Compression: Gz{}}).Archive(ctx, tarGzBuf, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		format    Extractor
+		archive   []byte
+		expectErr bool
+	}{
+		{name: "zip without limits", format: Zip{}, archive: zipBuf.Bytes()},
+		{name: "zip max entries", format: Zip{Limits: Limits{MaxEntries: 2}}, archive: zipBuf.Bytes(), expectErr: true},
+		{name: "zip max entry size", format: Zip{Limits: Limits{MaxEntrySize: 1 << 20}}, archive: zipBuf.Bytes(), expectErr: true},
+		{name: "zip max total size", format: Zip{Limits: Limits{MaxTotalSize: 4<<20 + 6}}, archive: zipBuf.Bytes(), expectErr: true},
+		{name: "zip total size within limit", format: Zip{Limits: Limits{MaxTotalSize: 4<<20 + 10}}, archive: zipBuf.Bytes()},
+		{name: "zip compression ratio", format: Zip{Limits: Limits{MaxCompressionRatio: 100}}, archive: zipBuf.Bytes(), expectErr: true},
+		{
+			name:      "tar.gz max entry size",
+			format:    CompressedArchive{Extraction: Tar{Limits: Limits{MaxEntrySize: 1 << 20}}, Compression: Gz{}},
+			archive:   tarGzBuf.Bytes(),
+			expectErr: true,
+		},
+		{
+			name:      "tar.gz compression ratio",
+			format:    CompressedArchive{Extraction: Tar{Limits: Limits{MaxCompressionRatio: 100}}, Compression: Gz{}},
+			archive:   tarGzBuf.Bytes(),
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := readAllEntries(tc.format, tc.archive)
+			if tc.expectErr && !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("expected ErrLimitExceeded, got: %v", err)
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("ArchiveFS", func(t *testing.T) {
+		fsys := &ArchiveFS{
+			Stream: io.NewSectionReader(bytes.NewReader(zipBuf.Bytes()), 0, int64(zipBuf.Len())),
+			Format: Zip{Limits: Limits{MaxEntries: 2, MaxEntrySize: 1 << 20}},
+		}
+		if _, err := fsys.ReadDir("."); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected ErrLimitExceeded from ReadDir, got: %v", err)
+		}
+		if _, err := fs.ReadFile(fsys, "zeros.bin"); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("expected ErrLimitExceeded from ReadFile, got: %v", err)
+		}
+	})
+}
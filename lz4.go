@@ -1,8 +1,12 @@
 package archives
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -51,7 +55,93 @@ func (lz Lz4) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 }
 
 func (Lz4) OpenReader(r io.Reader) (io.ReadCloser, error) {
-	return io.NopCloser(lz4.NewReader(r)), nil
+	src := bufio.NewReader(r)
+	return newClassifyingReader(io.NopCloser(&lz4Reader{lz4.NewReader(src), src}), ""), nil
+}
+
+// lz4Reader reads concatenated LZ4 frames, as the lz4 command
+// does; the reader of the lz4 package reads only the first.
+type lz4Reader struct {
+	zr  *lz4.Reader
+	src *bufio.Reader
+}
+
+func (r *lz4Reader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.zr.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if _, err := r.src.Peek(1); err != nil {
+			return n, err // io.EOF if there are no more frames
+		}
+		r.zr.Reset(r.src)
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Streams returns the offsets of the LZ4 frames in r, including skippable
+// frames, which are found by reading the sizes of their blocks.
+func (Lz4) Streams(r io.ReaderAt, size int64) ([]int64, error) {
+	r = io.NewSectionReader(r, 0, size)
+	var offsets []int64
+	for offset := int64(0); offset < size; {
+		end, err := lz4FrameEnd(r, offset)
+		if err != nil {
+			return nil, fmt.Errorf("frame at %d: %w", offset, err)
+		}
+		offsets = append(offsets, offset)
+		offset = end
+	}
+	return offsets, nil
+}
+
+// lz4FrameEnd returns the end of the frame that starts at offset in r.
+func lz4FrameEnd(r io.ReaderAt, offset int64) (int64, error) {
+	var hdr [5]byte // magic and flags
+	if _, err := r.ReadAt(hdr[:], offset); err != nil {
+		return 0, noEOF(err)
+	}
+	if binary.LittleEndian.Uint32(hdr[:])&^0xf == skippableFrameMagic {
+		var size [4]byte
+		if _, err := r.ReadAt(size[:], offset+4); err != nil {
+			return 0, noEOF(err)
+		}
+		return offset + 8 + int64(binary.LittleEndian.Uint32(size[:])), nil
+	}
+	if !bytes.Equal(hdr[:4], lz4Header) {
+		return 0, errors.New("not an LZ4 frame")
+	}
+
+	flags := hdr[4]
+	pos := offset + 7 // magic, flags, block descriptor, and header checksum
+	if flags&0x08 != 0 {
+		pos += 8 // content size
+	}
+	if flags&0x01 != 0 {
+		pos += 4 // dictionary ID
+	}
+	for {
+		var block [4]byte
+		if _, err := r.ReadAt(block[:], pos); err != nil {
+			return 0, noEOF(err)
+		}
+		pos += 4
+		size := binary.LittleEndian.Uint32(block[:])
+		if size == 0 {
+			break // end mark
+		}
+		pos += int64(size &^ 0x80000000) // the high bit is set if it's not compressed
+		if flags&0x10 != 0 {
+			pos += 4 // block checksum
+		}
+	}
+	if flags&0x04 != 0 {
+		pos += 4 // content checksum
+	}
+	return pos, nil
 }
 
 var lz4Header = []byte{0x04, 0x22, 0x4d, 0x18}
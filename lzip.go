@@ -43,11 +43,12 @@ func (Lzip) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 }
 
 func (Lzip) OpenReader(r io.Reader) (io.ReadCloser, error) {
-	lzr, err := lzip.NewReader(r)
+	src := &sourceErrorRecorder{Reader: r}
+	lzr, err := lzip.NewReader(src)
 	if err != nil {
-		return nil, err
+		return nil, src.classify("", err)
 	}
-	return io.NopCloser(lzr), err
+	return classifyingReader{io.NopCloser(lzr), "", src.classify}, nil
 }
 
 // magic number at the beginning of lzip files
@@ -47,7 +47,7 @@ func (MinLZ) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 
 func (MinLZ) OpenReader(r io.Reader) (io.ReadCloser, error) {
 	mr := minlz.NewReader(r)
-	return io.NopCloser(mr), nil
+	return newClassifyingReader(io.NopCloser(mr), ""), nil
 }
 
 var mzHeader = []byte("\xff\x06\x00\x00MinLz")
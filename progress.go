@@ -0,0 +1,286 @@
+package archives
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"sync/atomic"
+)
+
+// Observer receives events that describe the progress of an operation on an
+// archive: Archive, ArchiveAsync, Extract, or Insert. It is attached to an
+// operation through its context with WithObserver, so it works with every
+// format (including CompressedArchive) without changing the files passed in
+// or out of the operation.
+//
+// Events are delivered synchronously from the goroutine doing the work, so
+// Observe should return quickly.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc is a function that implements Observer.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) Observe(e Event) { f(e) }
+
+// WithObserver returns a copy of ctx that causes operations it is passed to
+// to report their progress to obs.
+func WithObserver(ctx context.Context, obs Observer) context.Context {
+	return context.WithValue(ctx, observerKey{}, obs)
+}
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EntryStarted is emitted when an entry is about to
+	// be written to or read from the archive.
+	EntryStarted EventType = iota + 1
+
+	// BytesCopied is emitted each time some of the contents
+	// of the current entry are written or read.
+	BytesCopied
+
+	// EntryFinished is emitted after an entry has been
+	// successfully written or read.
+	EntryFinished
+
+	// EntryFailed is emitted when writing or reading an
+	// entry fails; the error is in the event's Err field.
+	EntryFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EntryStarted:
+		return "entry started"
+	case BytesCopied:
+		return "bytes copied"
+	case EntryFinished:
+		return "entry finished"
+	case EntryFailed:
+		return "entry failed"
+	}
+	return "unknown event"
+}
+
+// Event describes the progress of an operation on an archive. Byte counts
+// are of uncompressed entry contents, except for CompressedBytes. Totals
+// are 0 when they are not known in advance, for example when extracting a
+// tar archive, which has no index, or when archiving asynchronously.
+type Event struct {
+	Type EventType
+
+	// The entry the event is about, and its size
+	// as reported by its FileInfo.
+	Entry     string
+	EntrySize int64
+
+	// The number of bytes of the entry's contents
+	// that have been written or read so far.
+	EntryBytes int64
+
+	// The error that caused the entry to fail, if
+	// Type is EntryFailed.
+	Err error
+
+	// The number of entries that have finished or failed,
+	// and the total number of entries, if known.
+	EntriesDone  int
+	EntriesTotal int
+
+	// The number of bytes written or read from all entries
+	// so far, and the total number of bytes, if known.
+	BytesDone  int64
+	BytesTotal int64
+
+	// When operating on a CompressedArchive, the number of
+	// bytes of the compressed stream that have been written
+	// or read so far; 0 otherwise.
+	CompressedBytes int64
+}
+
+type (
+	observerKey        struct{}
+	progressKey        struct{}
+	compressedBytesKey struct{}
+)
+
+// progress tracks the progress of one operation and reports it to an
+// Observer. A nil *progress is valid and does nothing, which is what
+// operations get if no Observer is attached.
+type progress struct {
+	observer   Observer
+	compressed func() int64 // nil unless operating on a CompressedArchive
+
+	entriesDone, entriesTotal int
+	bytesDone, bytesTotal     int64
+
+	entry      string
+	entrySize  int64
+	entryBytes int64
+}
+
+// startProgress begins tracking the progress of an operation if ctx has an
+// Observer. The returned context carries the progress so that copying entry
+// contents can be reported further down the call stack (see progressFrom).
+func startProgress(ctx context.Context) (context.Context, *progress) {
+	obs, _ := ctx.Value(observerKey{}).(Observer)
+	if obs == nil {
+		return ctx, nil
+	}
+	p := &progress{observer: obs}
+	p.compressed, _ = ctx.Value(compressedBytesKey{}).(func() int64)
+	return context.WithValue(ctx, progressKey{}, p), p
+}
+
+// progressFrom returns the progress of the operation ctx belongs to, or nil.
+func progressFrom(ctx context.Context) *progress {
+	p, _ := ctx.Value(progressKey{}).(*progress)
+	return p
+}
+
+// withCompressedBytes returns a copy of ctx through which the progress of
+// the operation will report the number of compressed bytes as returned by n.
+func withCompressedBytes(ctx context.Context, n func() int64) context.Context {
+	if ctx.Value(observerKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, compressedBytesKey{}, n)
+}
+
+// setTotals sets the total number of entries and bytes of the operation.
+func (p *progress) setTotals(entries int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.entriesTotal, p.bytesTotal = entries, bytes
+}
+
+// setTotalsFromFiles sets the totals of the operation to the number
+// of files and the combined size of the regular files among them.
+func (p *progress) setTotalsFromFiles(files []FileInfo) {
+	if p == nil {
+		return
+	}
+	var size int64
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			size += file.Size()
+		}
+	}
+	p.setTotals(len(files), size)
+}
+
+// start reports that file is about to be processed.
+func (p *progress) start(file FileInfo) {
+	if p == nil {
+		return
+	}
+	p.entry, p.entrySize, p.entryBytes = file.NameInArchive, file.Size(), 0
+	p.emit(EntryStarted, nil)
+}
+
+// copied reports that n bytes of the current entry were processed.
+func (p *progress) copied(n int64) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.entryBytes += n
+	p.bytesDone += n
+	p.emit(BytesCopied, nil)
+}
+
+// finish reports that the current entry is done, or failed if err is not
+// nil. Errors used to control walks, like fs.SkipDir, are not failures.
+func (p *progress) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.entriesDone++
+	if err != nil && !errors.Is(err, fs.SkipDir) && !errors.Is(err, fs.SkipAll) {
+		p.emit(EntryFailed, err)
+		return
+	}
+	p.emit(EntryFinished, nil)
+}
+
+func (p *progress) emit(t EventType, err error) {
+	e := Event{
+		Type:         t,
+		Entry:        p.entry,
+		EntrySize:    p.entrySize,
+		EntryBytes:   p.entryBytes,
+		Err:          err,
+		EntriesDone:  p.entriesDone,
+		EntriesTotal: p.entriesTotal,
+		BytesDone:    p.bytesDone,
+		BytesTotal:   p.bytesTotal,
+	}
+	if p.compressed != nil {
+		e.CompressedBytes = p.compressed()
+	}
+	p.observer.Observe(e)
+}
+
+// wrapHandler returns a FileHandler that reports the progress of each
+// file passed to handleFile, including the bytes read from it. If p is
+// nil, handleFile is returned as-is.
+func (p *progress) wrapHandler(handleFile FileHandler) FileHandler {
+	if p == nil {
+		return handleFile
+	}
+	return func(ctx context.Context, file FileInfo) error {
+		if file.Open != nil {
+			open := file.Open
+			file.Open = func() (fs.File, error) {
+				f, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return p.observeFile(f), nil
+			}
+		}
+		p.start(file)
+		err := handleFile(ctx, file)
+		p.finish(err)
+		return err
+	}
+}
+
+// observeFile returns f such that reads from it are reported
+// as progress of the current entry.
+func (p *progress) observeFile(f fs.File) fs.File {
+	if p == nil {
+		return f
+	}
+	return observedFile{f, p}
+}
+
+// observedFile is an fs.File whose reads are reported as progress.
+type observedFile struct {
+	fs.File
+	p *progress
+}
+
+func (f observedFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.p.copied(int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+// The count is atomic, since some compressors write their output in
+// a separate goroutine.
+type countingWriter struct {
+	io.Writer
+	n atomic.Int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.Writer.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,99 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestObserver(t *testing.T) {
+	files := []FileInfo{
+		memFile("a.txt", []byte("first")),
+		memFile("b.txt", bytes.Repeat([]byte("second"), 10000)),
+		memFile("c.txt", []byte("third")),
+	}
+	var totalSize int64
+	for _, f := range files {
+		totalSize += f.Size()
+	}
+
+	for _, tc := range []struct {
+		name   string
+		format interface {
+			Archiver
+			Extractor
+		}
+		totalKnown bool // whether totals are known when extracting
+		compressed bool
+	}{
+		{name: "zip", format: Zip{}, totalKnown: true},
+		{name: "tar", format: Tar{}},
+		{name: "tar.gz", format: CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: Gz{}}, compressed: true},
+		{name: "tar.gz multithreaded", format: CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: Gz{Multithreaded: true}}, compressed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []Event
+			ctx := WithObserver(context.Background(), ObserverFunc(func(e Event) {
+				events = append(events, e)
+			}))
+
+			buf := new(bytes.Buffer)
+			if err := tc.format.Archive(ctx, buf, files); err != nil {
+				t.Fatal(err)
+			}
+			checkEvents(t, "archiving", events, len(files), totalSize, true, tc.compressed)
+
+			events = nil
+			if err := readAllEntries(tc.format, buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 0 {
+				t.Errorf("expected no events without an observer, got %d", len(events))
+			}
+			err := tc.format.Extract(ctx, bytes.NewReader(buf.Bytes()), func(ctx context.Context, f FileInfo) error {
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				_, err = io.Copy(io.Discard, rc)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkEvents(t, "extracting", events, len(files), totalSize, tc.totalKnown, tc.compressed)
+		})
+	}
+}
+
+func checkEvents(t *testing.T, op string, events []Event, entries int, size int64, totalKnown, compressed bool) {
+	t.Helper()
+
+	var started, finished int
+	for _, e := range events {
+		switch e.Type {
+		case EntryStarted:
+			started++
+		case EntryFinished:
+			finished++
+		case EntryFailed:
+			t.Errorf("%s: unexpected failure: %s: %v", op, e.Entry, e.Err)
+		}
+	}
+	if started != entries || finished != entries {
+		t.Errorf("%s: expected %d entries started and finished, got %d and %d", op, entries, started, finished)
+	}
+
+	last := events[len(events)-1]
+	if last.EntriesDone != entries || last.BytesDone != size {
+		t.Errorf("%s: expected %d entries and %d bytes done, got %d and %d", op, entries, size, last.EntriesDone, last.BytesDone)
+	}
+	if totalKnown && (last.EntriesTotal != entries || last.BytesTotal != size) {
+		t.Errorf("%s: expected totals of %d entries and %d bytes, got %d and %d", op, entries, size, last.EntriesTotal, last.BytesTotal)
+	}
+	if compressed != (last.CompressedBytes > 0) {
+		t.Errorf("%s: unexpected compressed byte count: %d", op, last.CompressedBytes)
+	}
+}
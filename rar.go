@@ -7,13 +7,13 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/nwaples/rardecode/v2"
+	"golang.org/x/text/encoding"
 )
 
 func init() {
@@ -28,10 +28,23 @@ type rarReader interface {
 
 type Rar struct {
 	// If true, errors encountered during reading or writing
-	// a file within an archive will be logged and the
-	// operation will continue on remaining files.
+	// a file within an archive will be skipped and the
+	// operation will continue on remaining files. The errors
+	// are returned together as a *SkippedEntriesError when
+	// the operation completes.
 	ContinueOnError bool
 
+	// If set, OnError is called with each error encountered
+	// during reading or writing a file within an archive,
+	// along with the name of the file. If it returns nil, the
+	// file is skipped and the operation continues, and the
+	// error is included in the *SkippedEntriesError returned
+	// when the operation completes; otherwise the operation
+	// stops and returns OnError's error. OnError takes
+	// precedence over ContinueOnError. Context cancellation and
+	// exceeded Limits always stop the operation.
+	OnError func(entry string, err error) error
+
 	// Password to open archives.
 	Password string
 
@@ -51,11 +64,31 @@ type Rar struct {
 	// Typically this should be a DirFS pointing at the directory containing
 	// the volumes of the archive.
 	FS fs.FS
+
+	// For archives whose filenames are not UTF-8 encoded (which
+	// RAR 5 archives always are), specify the character encoding
+	// here.
+	TextEncoding encoding.Encoding
+
+	// If true and TextEncoding is not set, the encoding of
+	// filenames that are not UTF-8 is detected with
+	// DetectTextEncoding, and reported in the TextEncoding
+	// field of the FileInfos decoded with it. If the archive
+	// is opened by Name or is an io.Seeker, it is detected
+	// from all of them in the archive; otherwise from those
+	// read so far.
+	DetectTextEncoding bool
+
+	// Limits on the resources that may be consumed while
+	// extracting, to defend against decompression bombs.
+	Limits Limits
 }
 
 func (Rar) Extension() string { return ".rar" }
 func (Rar) MediaType() string { return "application/vnd.rar" }
 
+func (r Rar) resourceLimits() Limits { return r.Limits }
+
 func (r Rar) Match(_ context.Context, filename string, stream io.Reader) (MatchResult, error) {
 	var mr MatchResult
 
@@ -83,34 +116,21 @@ func (r Rar) Match(_ context.Context, filename string, stream io.Reader) (MatchR
 // Archive is not implemented for RAR because it is patent-encumbered.
 
 func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
-	var options []rardecode.Option
-	if r.Password != "" {
-		options = append(options, rardecode.Password(r.Password))
-	}
-
-	if r.FS != nil {
-		options = append(options, rardecode.FileSystem(r.FS))
-	}
-
-	var (
-		rr  rarReader
-		err error
-	)
-
-	// If a name has been provided, then the sourceArchive stream is ignored
-	// and the archive is opened directly via the filesystem (or provided FS).
-	if r.Name != "" {
-		var or *rardecode.ReadCloser
-		if or, err = rardecode.OpenReader(r.Name, options...); err == nil {
-			rr = or
-			defer or.Close()
-		}
-	} else {
-		rr, err = rardecode.NewReader(sourceArchive, options...)
+	textDec, err := r.textDecoder(sourceArchive)
+	if err != nil {
+		return err
 	}
+	rr, closer, err := r.openReader(sourceArchive)
 	if err != nil {
 		return err
 	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	handleFile = r.Limits.wrapHandler(handleFile)
+	ctx, p := startProgress(ctx)
+	handleFile = p.wrapHandler(handleFile)
+	errs := &entryErrorHandler{continueOnError: r.ContinueOnError, onError: r.OnError}
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -125,25 +145,20 @@ func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 			break
 		}
 		if err != nil {
-			if r.ContinueOnError {
-				log.Printf("[ERROR] Advancing to next file in rar archive: %v", err)
-				continue
+			if err := errs.handle(ctx, "", classifyError("", err)); err != nil {
+				return err
 			}
-			return err
+			// there's no reliable way to find the next entry
+			// after a bad header, so skip the rest of the archive
+			break
 		}
+		textEnc := decodeRarText(textDec, hdr)
 		if fileIsIncluded(skipDirs, hdr.Name) {
 			continue
 		}
 
-		info := rarFileInfo{hdr}
-		file := FileInfo{
-			FileInfo:      info,
-			Header:        hdr,
-			NameInArchive: hdr.Name,
-			Open: func() (fs.File, error) {
-				return fileInArchive{io.NopCloser(rr), info}, nil
-			},
-		}
+		file := rarEntryFileInfo(ctx, rr, hdr)
+		file.TextEncoding = textEnc
 
 		err = handleFile(ctx, file)
 		if errors.Is(err, fs.SkipAll) {
@@ -151,13 +166,156 @@ func (r Rar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
 			skipDirs.add(hdr.Name)
 		} else if err != nil {
-			return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
+			if err := errs.handle(ctx, hdr.Name, err); err != nil {
+				return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
+			}
 		}
 	}
 
+	return errs.err()
+}
+
+// openReader opens a rar reader over sourceArchive, or over the file
+// named r.Name if set, in which case the returned closer must be closed.
+func (r Rar) openReader(sourceArchive io.Reader) (rarReader, io.Closer, error) {
+	var options []rardecode.Option
+	if r.Password != "" {
+		options = append(options, rardecode.Password(r.Password))
+	}
+
+	if r.FS != nil {
+		options = append(options, rardecode.FileSystem(r.FS))
+	}
+
+	// If a name has been provided, then the sourceArchive stream is ignored
+	// and the archive is opened directly via the filesystem (or provided FS).
+	if r.Name != "" {
+		or, err := rardecode.OpenReader(r.Name, options...)
+		if err != nil {
+			return nil, nil, classifyError("", err)
+		}
+		return or, or, nil
+	}
+	rr, err := rardecode.NewReader(sourceArchive, options...)
+	if err != nil {
+		return nil, nil, classifyError("", err)
+	}
+	return rr, nil, nil
+}
+
+// OpenArchiveReader returns a reader that iterates the entries of the rar archive.
+func (r Rar) OpenArchiveReader(ctx context.Context, sourceArchive io.Reader) (ArchiveReader, error) {
+	textDec, err := r.textDecoder(sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+	rr, closer, err := r.openReader(sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+	return &rarArchiveReader{ctx: ctx, rr: rr, closer: closer, textDec: textDec, limits: r.Limits.newState()}, nil
+}
+
+// textDecoder returns a textDecoder for the names in the archive. If the
+// encoding is to be detected and the archive is opened by name or
+// sourceArchive is an io.Seeker, the decoder sees all the names in it
+// first, after which sourceArchive is sought back to where it was.
+func (r Rar) textDecoder(sourceArchive io.Reader) (*textDecoder, error) {
+	dec := newTextDecoder(r.TextEncoding, r.DetectTextEncoding)
+	if dec.detector == nil {
+		return dec, nil
+	}
+	var start int64
+	rs, seekable := sourceArchive.(io.Seeker)
+	if r.Name == "" {
+		if !seekable {
+			return dec, nil
+		}
+		var err error
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return dec, nil // not actually seekable, so detect as it's read
+		}
+	}
+
+	rr, closer, err := r.openReader(sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		hdr, err := rr.Next()
+		if err != nil {
+			break // any error will be reported when reading for real
+		}
+		dec.seeNonUTF8(hdr.Name)
+	}
+	if closer != nil {
+		closer.Close()
+	}
+	if r.Name == "" {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking back to start of archive: %w", err)
+		}
+	}
+	dec.detector = nil // seen them all
+	return dec, nil
+}
+
+// decodeRarText decodes the name of hdr into UTF-8 with dec, if it
+// is not UTF-8, and returns the encoding it was decoded from.
+func decodeRarText(dec *textDecoder, hdr *rardecode.FileHeader) encoding.Encoding {
+	dec.seeNonUTF8(hdr.Name)
+	name := dec.decodeNonUTF8(hdr.Name)
+	if name == hdr.Name {
+		return nil
+	}
+	hdr.Name = name
+	return dec.enc
+}
+
+// rarArchiveReader implements ArchiveReader for rar archives.
+type rarArchiveReader struct {
+	ctx     context.Context
+	rr      rarReader
+	closer  io.Closer
+	textDec *textDecoder
+	limits  *limitState
+}
+
+func (r *rarArchiveReader) Next() (FileInfo, error) {
+	if err := r.ctx.Err(); err != nil {
+		return FileInfo{}, err // honor context cancellation
+	}
+	hdr, err := r.rr.Next()
+	if err != nil {
+		return FileInfo{}, classifyError("", err)
+	}
+	textEnc := decodeRarText(r.textDec, hdr)
+	file := rarEntryFileInfo(r.ctx, r.rr, hdr)
+	file.TextEncoding = textEnc
+	return r.limits.entry(file)
+}
+
+func (r *rarArchiveReader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
 	return nil
 }
 
+// rarEntryFileInfo returns a FileInfo for the current entry of rr, described by hdr.
+// Reads from the opened file honor ctx cancellation.
+func rarEntryFileInfo(ctx context.Context, rr rarReader, hdr *rardecode.FileHeader) FileInfo {
+	info := rarFileInfo{hdr}
+	return FileInfo{
+		FileInfo:      info,
+		Header:        hdr,
+		NameInArchive: hdr.Name,
+		Open: func() (fs.File, error) {
+			return fileInArchive{newClassifyingReader(io.NopCloser(rr), hdr.Name), info, ctx}, nil
+		},
+	}
+}
+
 // rarFileInfo satisfies the fs.FileInfo interface for RAR entries.
 type rarFileInfo struct {
 	fh *rardecode.FileHeader
@@ -175,5 +333,8 @@ var (
 	rarHeaderV5_0 = []byte("Rar!\x1a\x07\x01\x00") // v5.0
 )
 
-// Interface guard
-var _ Extractor = Rar{}
+// Interface guards
+var (
+	_ Extractor           = Rar{}
+	_ ArchiveReaderOpener = Rar{}
+)
@@ -0,0 +1,92 @@
+package archives
+
+import (
+	"context"
+	"io"
+)
+
+// OpenArchiveReader returns an ArchiveReader that iterates the entries of
+// archive one at a time, which must be readable by format. This can be more
+// convenient than Extract when reading multiple archives at once, or when
+// feeding entries into another pipeline. The reader must be closed when
+// finished.
+//
+// If format does not implement ArchiveReaderOpener, its Extract method is
+// run in the background and each entry is handed off to Next; the walk does
+// not proceed until Next is called again, so entry contents remain valid
+// until then.
+func OpenArchiveReader(ctx context.Context, format Extractor, archive io.Reader) (ArchiveReader, error) {
+	if opener, ok := format.(ArchiveReaderOpener); ok {
+		return opener.OpenArchiveReader(ctx, archive)
+	}
+	return newExtractorReader(ctx, format, archive), nil
+}
+
+// extractorReader adapts any Extractor to the ArchiveReader interface
+// by running Extract in a goroutine that pauses after each entry.
+type extractorReader struct {
+	cancel  context.CancelFunc
+	files   chan FileInfo
+	resume  chan struct{}
+	done    chan struct{}
+	err     error // result of Extract; only read after done is closed
+	waiting bool  // whether the handler is waiting to be resumed
+}
+
+func newExtractorReader(ctx context.Context, format Extractor, archive io.Reader) *extractorReader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &extractorReader{
+		cancel: cancel,
+		files:  make(chan FileInfo),
+		resume: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(r.done)
+		r.err = format.Extract(ctx, archive, func(ctx context.Context, file FileInfo) error {
+			select {
+			case r.files <- file:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			// don't let the walk proceed (and invalidate the file)
+			// until the next entry is requested
+			select {
+			case <-r.resume:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return r
+}
+
+func (r *extractorReader) Next() (FileInfo, error) {
+	if r.waiting {
+		r.waiting = false
+		select {
+		case r.resume <- struct{}{}:
+		case <-r.done:
+		}
+	}
+	select {
+	case file := <-r.files:
+		r.waiting = true
+		return file, nil
+	case <-r.done:
+		if r.err != nil {
+			return FileInfo{}, r.err
+		}
+		return FileInfo{}, io.EOF
+	}
+}
+
+func (r *extractorReader) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// Interface guard
+var _ ArchiveReader = (*extractorReader)(nil)
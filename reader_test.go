@@ -0,0 +1,147 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// extractorOnly hides any methods of the format other than Extract,
+// to exercise the generic ArchiveReader implementation.
+type extractorOnly struct{ Extractor }
+
+func TestOpenArchiveReader(t *testing.T) {
+	ctx := context.Background()
+	files := []FileInfo{
+		memFile("a.txt", []byte("first")),
+		memFile("b.txt", []byte("second")),
+		memFile("c.txt", []byte("third")),
+	}
+	expect := map[string]string{"a.txt": "first", "b.txt": "second", "c.txt": "third"}
+
+	zipBuf := new(bytes.Buffer)
+	if err := (Zip{}).Archive(ctx, zipBuf, files); err != nil {
+		t.Fatal(err)
+	}
+	tarBuf := new(bytes.Buffer)
+	if err := (Tar{}).Archive(ctx, tarBuf, files); err != nil {
+		t.Fatal(err)
+	}
+	tarZstBuf := new(bytes.Buffer)
+	tarZst := CompressedArchive{Archival: Tar{}, Extraction: Tar{}, Compression: Zstd{}}
+	if err := tarZst.Archive(ctx, tarZstBuf, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		format  Extractor
+		archive []byte
+	}{
+		{name: "tar", format: Tar{}, archive: tarBuf.Bytes()},
+		{name: "zip", format: Zip{}, archive: zipBuf.Bytes()},
+		{name: "tar.zst", format: tarZst, archive: tarZstBuf.Bytes()},
+		{name: "generic", format: extractorOnly{Tar{}}, archive: tarBuf.Bytes()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ar, err := OpenArchiveReader(ctx, tc.format, bytes.NewReader(tc.archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ar.Close()
+
+			var count int
+			for {
+				file, err := ar.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				count++
+
+				f, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				contents, err := io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(contents) != expect[file.NameInArchive] {
+					t.Errorf("%s: expected %q, got %q", file.NameInArchive, expect[file.NameInArchive], contents)
+				}
+			}
+			if count != len(files) {
+				t.Errorf("expected %d entries, got %d", len(files), count)
+			}
+		})
+	}
+
+	t.Run("interleaved", func(t *testing.T) {
+		ar1, err := OpenArchiveReader(ctx, Tar{}, bytes.NewReader(tarBuf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ar1.Close()
+		ar2, err := OpenArchiveReader(ctx, extractorOnly{Tar{}}, bytes.NewReader(tarBuf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ar2.Close()
+
+		for range files {
+			f1, err := ar1.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			f2, err := ar2.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f1.NameInArchive != f2.NameInArchive {
+				t.Errorf("expected same entry, got %s and %s", f1.NameInArchive, f2.NameInArchive)
+			}
+		}
+	})
+
+	t.Run("close early", func(t *testing.T) {
+		ar, err := OpenArchiveReader(ctx, extractorOnly{Tar{}}, bytes.NewReader(tarBuf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ar.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ar.Close(); err != nil {
+			t.Errorf("closing: %v", err)
+		}
+	})
+}
+
+func TestRarOpenArchiveReaderMultiVolume(t *testing.T) {
+	rar := Rar{
+		Name: "test.part01.rar",
+		FS:   DirFS("testdata"),
+	}
+	ar, err := rar.OpenArchiveReader(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+
+	file, err := ar.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.NameInArchive != "test.txt" {
+		t.Errorf("expected test.txt, got %s", file.NameInArchive)
+	}
+	if _, err := ar.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package archives
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RegisterFormat registers a format with the default priority of 0.
+// It should be called during init. Duplicate formats by name are not
+// allowed and will panic.
+func RegisterFormat(format Format) {
+	RegisterFormatWithPriority(format, 0)
+}
+
+// RegisterFormatWithPriority registers a format with the given priority.
+// Formats with a higher priority are matched first by Identify and are
+// listed first by Formats; formats with the same priority are ordered by
+// name, so identification does not depend on the order of registration.
+// Formats whose headers are weak or absent, and which are therefore prone
+// to false positives, should be registered with a negative priority.
+//
+// Duplicate formats by name are not allowed and will panic.
+func RegisterFormatWithPriority(format Format, priority int) {
+	name := formatName(format)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, rf := range registry {
+		if rf.name == name {
+			panic("format " + name + " is already registered")
+		}
+	}
+	registry = append(registry, registeredFormat{name: name, format: format, priority: priority})
+	sort.SliceStable(registry, func(i, j int) bool {
+		if registry[i].priority != registry[j].priority {
+			return registry[i].priority > registry[j].priority
+		}
+		return registry[i].name < registry[j].name
+	})
+}
+
+// UnregisterFormat removes the format with the same name (extension) as
+// format from the registry, so that it is no longer identified or looked
+// up. It reports whether the format was registered.
+func UnregisterFormat(format Format) bool {
+	name := formatName(format)
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for i, rf := range registry {
+		if rf.name == name {
+			registry = append(registry[:i:i], registry[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Formats returns the registered formats, in the order they are matched.
+func Formats() []Format {
+	rfs := registeredFormats()
+	formats := make([]Format, len(rfs))
+	for i, rf := range rfs {
+		formats[i] = rf.format
+	}
+	return formats
+}
+
+// FormatByExtension returns the format for the given file extension, with
+// or without the leading dot, such as ".zip", "tar.zst", or ".gz.xz". An
+// extension made of an archive format followed by compression formats
+// yields a CompressedArchive, and one made only of compression formats
+// yields the Compression (a CompressionChain if there is more than one).
+// Common short aliases like .tgz, .tbz2, .txz, and .tzst are understood.
+// If no registered formats match, an error wrapping NoMatch is returned.
+func FormatByExtension(ext string) (Format, error) {
+	ext = strings.ToLower(strings.Trim(ext, "."))
+	if alias, ok := extensionAliases[ext]; ok {
+		ext = alias
+	}
+
+	parts := strings.Split(ext, ".")
+	formats := make([]Format, len(parts))
+	for i, part := range parts {
+		format, ok := lookupFormat(part)
+		if !ok {
+			return nil, fmt.Errorf("extension .%s: %w", ext, NoMatch)
+		}
+		formats[i] = format
+	}
+
+	// everything after the first part must be compression
+	var chain CompressionChain
+	for _, format := range formats[1:] {
+		comp, ok := format.(Compression)
+		if !ok {
+			return nil, fmt.Errorf("extension .%s: %s is not a compression format: %w", ext, format.Extension(), NoMatch)
+		}
+		chain = append(chain, comp)
+	}
+
+	first := formats[0]
+	archival, isArchival := first.(Archival)
+	extraction, isExtraction := first.(Extraction)
+	if isArchival || isExtraction {
+		if len(chain) == 0 {
+			return first, nil
+		}
+		return CompressedArchive{archival, extraction, compressionFromChain(chain)}, nil
+	}
+
+	comp, ok := first.(Compression)
+	if !ok {
+		return nil, fmt.Errorf("extension .%s: %w", ext, NoMatch)
+	}
+	if len(chain) == 0 {
+		return comp, nil
+	}
+	return append(CompressionChain{comp}, chain...), nil
+}
+
+// FormatByMediaType returns the registered format with the given media
+// (MIME) type, such as "application/zstd". Parameters of the media type
+// are ignored, and so is case. If no registered formats have the media
+// type, an error wrapping NoMatch is returned.
+func FormatByMediaType(mediaType string) (Format, error) {
+	mt, _, _ := strings.Cut(mediaType, ";")
+	mt = strings.ToLower(strings.TrimSpace(mt))
+	if alias, ok := mediaTypeAliases[mt]; ok {
+		mt = alias
+	}
+	for _, rf := range registeredFormats() {
+		if strings.ToLower(rf.format.MediaType()) == mt {
+			return rf.format, nil
+		}
+	}
+	return nil, fmt.Errorf("media type %s: %w", mediaType, NoMatch)
+}
+
+// FormatForFilename returns an Archiver that is ready to use for creating
+// an archive with the given filename, based on its extension. For example,
+// "out.tar.gz" and "out.tgz" both yield a CompressedArchive of Tar and Gz,
+// and "out.zip" yields Zip. The longest extension that names registered
+// formats is used, so "backup.2024.tar.zst" works as expected. If the
+// extension does not name a format that can create archives, an error
+// wrapping NoMatch is returned.
+func FormatForFilename(filename string) (Archiver, error) {
+	base := strings.ToLower(path.Base(filepath.ToSlash(filename)))
+	for i := 0; i < len(base); i++ {
+		if base[i] != '.' {
+			continue
+		}
+		format, err := FormatByExtension(base[i:])
+		if err != nil {
+			continue
+		}
+		// the longest extension that names formats decides
+		if ca, ok := format.(CompressedArchive); ok && ca.Archival == nil {
+			break
+		}
+		if archiver, ok := format.(Archiver); ok {
+			return archiver, nil
+		}
+		break
+	}
+	return nil, fmt.Errorf("%s: no archive format for writing: %w", filename, NoMatch)
+}
+
+// extensionAliases maps short extensions to the ones they stand for.
+var extensionAliases = map[string]string{
+	"tgz":  "tar.gz",
+	"tbz":  "tar.bz2",
+	"tbz2": "tar.bz2",
+	"tb2":  "tar.bz2",
+	"txz":  "tar.xz",
+	"tzst": "tar.zst",
+	"tlz4": "tar.lz4",
+}
+
+// mediaTypeAliases maps media types that are commonly used but not
+// returned by any format's MediaType method to the ones that are.
+var mediaTypeAliases = map[string]string{
+	"application/x-gzip":           "application/gzip",
+	"application/x-zstd":           "application/zstd",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-rar-compressed": "application/vnd.rar",
+	"application/x-bzip":           "application/x-bzip2",
+}
+
+// lookupFormat returns the registered format with the given name.
+func lookupFormat(name string) (Format, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, rf := range registry {
+		if rf.name == name {
+			return rf.format, true
+		}
+	}
+	return nil, false
+}
+
+// registeredFormats returns a copy of the registry, in priority order.
+func registeredFormats() []registeredFormat {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]registeredFormat(nil), registry...)
+}
+
+func formatName(format Format) string {
+	return strings.Trim(strings.ToLower(format.Extension()), ".")
+}
+
+type registeredFormat struct {
+	name     string
+	format   Format
+	priority int
+}
+
+// Registered formats, sorted by priority and then by name.
+var (
+	registry   []registeredFormat
+	registryMu sync.RWMutex
+)
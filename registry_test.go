@@ -0,0 +1,115 @@
+package archives
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	formats := Formats()
+	if len(formats) == 0 {
+		t.Fatal("expected registered formats")
+	}
+	if last := formats[len(formats)-1]; !reflect.DeepEqual(last, Brotli{}) {
+		t.Errorf("expected brotli to be matched last, got %T", last)
+	}
+	if !reflect.DeepEqual(formats, Formats()) {
+		t.Error("expected the same order every time")
+	}
+}
+
+func TestFormatByExtension(t *testing.T) {
+	for _, tc := range []struct {
+		ext    string
+		expect Format
+	}{
+		{ext: ".zip", expect: Zip{}},
+		{ext: "zst", expect: Zstd{}},
+		{ext: ".tar.zst", expect: CompressedArchive{Tar{}, Tar{}, Zstd{}}},
+		{ext: ".TGZ", expect: CompressedArchive{Tar{}, Tar{}, Gz{}}},
+		{ext: ".tbz2", expect: CompressedArchive{Tar{}, Tar{}, Bz2{}}},
+		{ext: ".tar.gz.xz", expect: CompressedArchive{Tar{}, Tar{}, CompressionChain{Gz{}, Xz{}}}},
+		{ext: ".gz.zst", expect: CompressionChain{Gz{}, Zstd{}}},
+		{ext: ".rar.gz", expect: CompressedArchive{nil, Rar{}, Gz{}}},
+	} {
+		format, err := FormatByExtension(tc.ext)
+		if err != nil {
+			t.Errorf("%s: %v", tc.ext, err)
+			continue
+		}
+		if !reflect.DeepEqual(format, tc.expect) {
+			t.Errorf("%s: expected %#v, got %#v", tc.ext, tc.expect, format)
+		}
+	}
+
+	for _, ext := range []string{".txt", ".tar.txt", ".gz.tar", ".zip.tar"} {
+		if _, err := FormatByExtension(ext); !errors.Is(err, NoMatch) {
+			t.Errorf("%s: expected NoMatch, got: %v", ext, err)
+		}
+	}
+}
+
+func TestFormatByMediaType(t *testing.T) {
+	for mediaType, expect := range map[string]Format{
+		"application/zstd":                   Zstd{},
+		"Application/Zip":                    Zip{},
+		"application/x-gzip; charset=binary": Gz{},
+	} {
+		format, err := FormatByMediaType(mediaType)
+		if err != nil {
+			t.Errorf("%s: %v", mediaType, err)
+			continue
+		}
+		if !reflect.DeepEqual(format, expect) {
+			t.Errorf("%s: expected %T, got %T", mediaType, expect, format)
+		}
+	}
+	if _, err := FormatByMediaType("text/plain"); !errors.Is(err, NoMatch) {
+		t.Errorf("expected NoMatch, got: %v", err)
+	}
+}
+
+func TestFormatForFilename(t *testing.T) {
+	for filename, expect := range map[string]Archiver{
+		"out.zip":             Zip{},
+		"/tmp/out.tbz2":       CompressedArchive{Tar{}, Tar{}, Bz2{}},
+		"backup.2024.tar.zst": CompressedArchive{Tar{}, Tar{}, Zstd{}},
+		"site.v1.2.txz":       CompressedArchive{Tar{}, Tar{}, Xz{}},
+	} {
+		archiver, err := FormatForFilename(filename)
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if !reflect.DeepEqual(archiver, expect) {
+			t.Errorf("%s: expected %#v, got %#v", filename, expect, archiver)
+		}
+	}
+
+	// these name formats that cannot create archives
+	for _, filename := range []string{"notes.txt.gz", "out.rar", "out.rar.gz", "README"} {
+		if _, err := FormatForFilename(filename); !errors.Is(err, NoMatch) {
+			t.Errorf("%s: expected NoMatch, got: %v", filename, err)
+		}
+	}
+}
+
+func TestUnregisterFormat(t *testing.T) {
+	if !UnregisterFormat(Lz4{}) {
+		t.Fatal("expected lz4 to be registered")
+	}
+	defer RegisterFormat(Lz4{})
+
+	if UnregisterFormat(Lz4{}) {
+		t.Error("expected lz4 to be unregistered already")
+	}
+	if _, err := FormatByExtension(".lz4"); !errors.Is(err, NoMatch) {
+		t.Errorf("expected NoMatch after unregistering, got: %v", err)
+	}
+	for _, format := range Formats() {
+		if reflect.DeepEqual(format, Lz4{}) {
+			t.Error("expected lz4 not to be listed")
+		}
+	}
+}
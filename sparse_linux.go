@@ -0,0 +1,61 @@
+package archives
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileSparseData returns the regions of the file on disk named filename, with
+// info, that hold data, if it has holes; otherwise, or if they can't be found,
+// it returns nil.
+func fileSparseData(filename string, info fs.FileInfo) []SparseRegion {
+	// a file with holes takes up less space on disk than its size
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int64(st.Blocks)*512 >= info.Size() {
+		return nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil // it will fail to be archived, if it still can't be opened
+	}
+	defer f.Close()
+	regions, err := sparseRegions(f, info.Size())
+	if err != nil {
+		return nil
+	}
+	return regions
+}
+
+// sparseRegions returns the regions of f, which is size bytes long, that
+// hold data, found by seeking to its data and holes, or nil if it has no holes.
+func sparseRegions(f *os.File, size int64) ([]SparseRegion, error) {
+	regions := []SparseRegion{}
+	for offset := int64(0); offset < size; {
+		data, err := f.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) || (err == nil && data >= size) {
+			break // the rest is a hole
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		hole = min(hole, size)
+		regions = append(regions, SparseRegion{Offset: data, Length: hole - data})
+		offset = hole
+	}
+	if len(regions) == 1 && regions[0] == (SparseRegion{Offset: 0, Length: size}) {
+		return nil, nil
+	}
+	return regions, nil
+}
+
+// Values of whence for lseek(2) that seek to the next data or hole.
+const (
+	seekData = 3
+	seekHole = 4
+)
@@ -0,0 +1,78 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseFilesOnDisk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// a file of 4 MiB with data only at the start and in the middle
+	filename := filepath.Join(dir, "sparse.img")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(4 << 20); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("data"), 1024)
+	for _, offset := range []int64{0, 2 << 20} {
+		if _, err := f.WriteAt(data, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := FilesFromDisk(ctx, nil, map[string]string{filename: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].SparseData == nil {
+		t.Skip("the file system doesn't report holes in files")
+	}
+	var stored int64
+	for _, region := range files[0].SparseData {
+		stored += region.Length
+	}
+	if stored >= 4<<20 {
+		t.Fatalf("expected holes in sparse regions, got %v", files[0].SparseData)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := (Tar{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 1<<20 {
+		t.Errorf("expected the holes not to be stored, but the archive is %d bytes", buf.Len())
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := ExtractToDisk(ctx, Tar{}, buf, out, nil); err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := os.ReadFile(filepath.Join(out, "sparse.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(extracted, contents) {
+		t.Error("contents of extracted file do not match")
+	}
+	ef, err := os.Open(filepath.Join(out, "sparse.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ef.Close()
+	if regions, err := sparseRegions(ef, 4<<20); err != nil || regions == nil {
+		t.Errorf("expected the extracted file to have holes, got regions %v (error: %v)", regions, err)
+	}
+}
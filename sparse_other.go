@@ -0,0 +1,8 @@
+//go:build !linux
+
+package archives
+
+import "io/fs"
+
+// fileSparseData returns nil, since holes in files are only found on Linux.
+func fileSparseData(filename string, info fs.FileInfo) []SparseRegion { return nil }
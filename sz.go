@@ -113,7 +113,7 @@ func (sz Sz) OpenReader(r io.Reader) (io.ReadCloser, error) {
 	if sz.S2.MaxBlockSize != 0 {
 		opts = append(opts, s2.ReaderMaxBlockSize(sz.S2.MaxBlockSize))
 	}
-	return io.NopCloser(s2.NewReader(r, opts...)), nil
+	return newClassifyingReader(io.NopCloser(s2.NewReader(r, opts...)), ""), nil
 }
 
 // Compression level for S2 (Snappy/Sz extension).
@@ -7,8 +7,9 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"strings"
+
+	"golang.org/x/text/encoding"
 )
 
 func init() {
@@ -16,17 +17,43 @@ func init() {
 }
 
 type Tar struct {
-	// If true, use GNU header format
+	// If true, use GNU header format, which can't store
+	// extended attributes, so they're left out
 	FormatGNU bool
 
 	// If true, preserve only numeric user and group id
 	NumericUIDGID bool
 
 	// If true, errors encountered during reading or writing
-	// a file within an archive will be logged and the
-	// operation will continue on remaining files.
+	// a file within an archive will be skipped and the
+	// operation will continue on remaining files. The errors
+	// are returned together as a *SkippedEntriesError when
+	// the operation completes.
 	ContinueOnError bool
 
+	// If set, OnError is called with each error encountered
+	// during reading or writing a file within an archive,
+	// along with the name of the file. If it returns nil, the
+	// file is skipped and the operation continues, and the
+	// error is included in the *SkippedEntriesError returned
+	// when the operation completes; otherwise the operation
+	// stops and returns OnError's error. OnError takes
+	// precedence over ContinueOnError. Context cancellation and
+	// exceeded Limits always stop the operation.
+	OnError func(entry string, err error) error
+
+	// If true, extracting recovers from corrupt headers, as
+	// long as errors are skipped (see ContinueOnError and
+	// OnError), by looking for the next valid header on a
+	// block boundary and continuing from there. Each range
+	// of the archive skipped this way is reported as a
+	// *SkippedRangeError. Files whose contents end early,
+	// because the archive does, are extracted with Truncated
+	// set. This is meant for recovering damaged archives:
+	// unless the archive is an io.Seeker, the contents of
+	// each file are buffered to find out if they end early.
+	Salvage bool
+
 	// User ID of the file owner
 	Uid int
 
@@ -38,11 +65,30 @@ type Tar struct {
 
 	// Group name of the file owner
 	Gname string
+
+	// For archives whose filenames and link targets are not
+	// UTF-8 encoded, specify the character encoding here.
+	// (PAX archives always encode them as UTF-8.)
+	TextEncoding encoding.Encoding
+
+	// If true and TextEncoding is not set, the encoding of
+	// filenames and link targets that are not UTF-8 is
+	// detected with DetectTextEncoding, and reported in the
+	// TextEncoding field of the FileInfos decoded with it. If
+	// the archive is an io.Seeker, it is detected from all
+	// of them in the archive; otherwise from those read so far.
+	DetectTextEncoding bool
+
+	// Limits on the resources that may be consumed while
+	// extracting, to defend against decompression bombs.
+	Limits Limits
 }
 
 func (Tar) Extension() string { return ".tar" }
 func (Tar) MediaType() string { return "application/x-tar" }
 
+func (t Tar) resourceLimits() Limits { return t.Limits }
+
 func (t Tar) Match(_ context.Context, filename string, stream io.Reader) (MatchResult, error) {
 	var mr MatchResult
 
@@ -62,34 +108,61 @@ func (t Tar) Match(_ context.Context, filename string, stream io.Reader) (MatchR
 }
 
 func (t Tar) Archive(ctx context.Context, output io.Writer, files []FileInfo) error {
-	tw := tar.NewWriter(output)
+	tw := newTarWriter(output)
 	defer tw.Close()
 
+	ctx, p := startProgress(ctx)
+	p.setTotalsFromFiles(files)
+
+	errs := t.entryErrorHandler()
 	for _, file := range files {
-		if err := t.writeFileToArchive(ctx, tw, file); err != nil {
-			if t.ContinueOnError && ctx.Err() == nil { // context errors should always abort
-				log.Printf("[ERROR] %v", err)
-				continue
+		p.start(file)
+		err := t.writeFileToArchive(ctx, tw, file)
+		p.finish(err)
+		if err != nil {
+			if err := errs.handle(ctx, file.NameInArchive, err); err != nil {
+				return err
 			}
-			return err
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 func (t Tar) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan ArchiveAsyncJob) error {
-	tw := tar.NewWriter(output)
+	tw := newTarWriter(output)
 	defer tw.Close()
 
+	ctx, p := startProgress(ctx)
+
+	errs := t.entryErrorHandler()
+	var abortErr error
 	for job := range jobs {
-		job.Result <- t.writeFileToArchive(ctx, tw, job.File)
+		if abortErr != nil {
+			// keep receiving jobs so senders don't block, but don't write them
+			job.Result <- abortErr
+			continue
+		}
+		p.start(job.File)
+		err := t.writeFileToArchive(ctx, tw, job.File)
+		p.finish(err)
+		job.Result <- err
+		if err != nil {
+			abortErr = errs.handle(ctx, job.File.NameInArchive, err)
+		}
+	}
+	if abortErr != nil {
+		return abortErr
 	}
 
-	return nil
+	return errs.err()
+}
+
+func (t Tar) entryErrorHandler() *entryErrorHandler {
+	return &entryErrorHandler{continueOnError: t.ContinueOnError, onError: t.OnError}
 }
 
-func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileInfo) error {
+func (t Tar) writeFileToArchive(ctx context.Context, tw *tarWriter, file FileInfo) error {
 	if err := ctx.Err(); err != nil {
 		return err // honor context cancellation
 	}
@@ -104,6 +177,15 @@ func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileIn
 	}
 	if t.FormatGNU {
 		hdr.Format = tar.FormatGNU
+	} else if file.Xattrs != nil {
+		setTarXattrs(hdr, file.Xattrs)
+	}
+	if file.Owner != nil {
+		hdr.Uid, hdr.Gid = file.Owner.Uid, file.Owner.Gid
+		hdr.Uname, hdr.Gname = file.Owner.Uname, file.Owner.Gname
+	}
+	if !file.AccessTime.IsZero() {
+		hdr.AccessTime = file.AccessTime
 	}
 	if t.NumericUIDGID {
 		hdr.Uname = ""
@@ -122,6 +204,13 @@ func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileIn
 		hdr.Gname = t.Gname
 	}
 
+	if file.SparseData != nil && hdr.Typeflag == tar.TypeReg {
+		if err := t.writeSparseFile(ctx, tw, hdr, file); err != nil {
+			return fmt.Errorf("file %s: %w", file.NameInArchive, err)
+		}
+		return nil
+	}
+
 	if err := tw.WriteHeader(hdr); err != nil {
 		return fmt.Errorf("file %s: writing header: %w", file.NameInArchive, err)
 	}
@@ -132,7 +221,7 @@ func (t Tar) writeFileToArchive(ctx context.Context, tw *tar.Writer, file FileIn
 		return nil
 	}
 
-	if err := openAndCopyFile(file, tw); err != nil {
+	if err := openAndCopyFile(ctx, file, tw); err != nil {
 		return fmt.Errorf("file %s: writing data: %w", file.NameInArchive, err)
 	}
 
@@ -189,48 +278,104 @@ func (t Tar) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileIn
 		return err
 	}
 
-	tw := tar.NewWriter(into)
+	tw := newTarWriter(into)
 	defer tw.Close()
 
+	return t.appendFiles(ctx, tw, files)
+}
+
+// appendFiles writes files to tw, which appends them to an archive.
+func (t Tar) appendFiles(ctx context.Context, tw *tarWriter, files []FileInfo) error {
+	ctx, p := startProgress(ctx)
+	p.setTotalsFromFiles(files)
+
+	errs := t.entryErrorHandler()
 	for i, file := range files {
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
-		err = t.writeFileToArchive(ctx, tw, file)
+		p.start(file)
+		err := t.writeFileToArchive(ctx, tw, file)
+		p.finish(err)
 		if err != nil {
-			if t.ContinueOnError && ctx.Err() == nil {
-				log.Printf("[ERROR] appending file %d into archive: %s: %v", i, file.Name(), err)
-				continue
+			if err := errs.handle(ctx, file.NameInArchive, err); err != nil {
+				return fmt.Errorf("appending file %d into archive: %s: %w", i, file.Name(), err)
 			}
-			return fmt.Errorf("appending file %d into archive: %s: %w", i, file.Name(), err)
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
-	tr := tar.NewReader(sourceArchive)
+	textDec, err := t.textDecoder(sourceArchive)
+	if err != nil {
+		return err
+	}
+	src := newTarSource(sourceArchive)
+	defer src.Close()
+	tr := tar.NewReader(src)
+	handleFile = t.Limits.wrapHandler(handleFile)
+	ctx, p := startProgress(ctx)
+	handleFile = p.wrapHandler(handleFile)
+	errs := t.entryErrorHandler()
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
 
+	// if the archive is seekable, the reader skips contents by seeking,
+	// and members can be read again from their positions
+	seekable := src.seeker != nil
+
 	for {
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
 
+		src.header(tr)
+		headerPos := src.next
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			if t.ContinueOnError && ctx.Err() == nil {
-				log.Printf("[ERROR] Advancing to next file in tar archive: %v", err)
-				continue
+			err = classifyError("", err)
+			if !t.Salvage {
+				if err := errs.handle(ctx, "", err); err != nil {
+					return err
+				}
+				// the reader can't advance past a bad header, so
+				// skipping it means skipping the rest of the archive
+				break
 			}
-			return err
+			skipped, found, resyncErr := src.resync(ctx)
+			if resyncErr != nil {
+				return fmt.Errorf("looking for next header: %w", resyncErr)
+			}
+			if skipped.Length > 0 {
+				skipped.Err = err
+				err = skipped
+			}
+			if err := errs.handle(ctx, "", err); err != nil {
+				return err
+			}
+			if !found {
+				break
+			}
+			// start reading again from the header that was found
+			tr = tar.NewReader(src)
+			continue
+		}
+		sparseData := src.member(hdr)
+		fileCtx := ctx
+		if seekable {
+			offsets := tarOffsets{header: headerPos, data: -1}
+			if !tarIsSparse(hdr) {
+				offsets.data = src.pos
+			}
+			fileCtx = withTarOffsets(ctx, offsets)
 		}
+		textEnc := decodeTarText(textDec, hdr)
 		if fileIsIncluded(skipDirs, hdr.Name) {
 			continue
 		}
@@ -239,18 +384,19 @@ func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 			continue
 		}
 
-		info := hdr.FileInfo()
-		file := FileInfo{
-			FileInfo:      info,
-			Header:        hdr,
-			NameInArchive: hdr.Name,
-			LinkTarget:    hdr.Linkname,
-			Open: func() (fs.File, error) {
-				return fileInArchive{io.NopCloser(tr), info}, nil
-			},
+		file := tarFileInfo(fileCtx, tr, hdr)
+		file.TextEncoding = textEnc
+		file.SparseData = sparseData
+		if t.Salvage {
+			if err := src.contents(fileCtx, tr, hdr, &file); err != nil {
+				if err := errs.handle(ctx, hdr.Name, err); err != nil {
+					return err
+				}
+				continue
+			}
 		}
 
-		err = handleFile(ctx, file)
+		err = handleFile(fileCtx, file)
 		if errors.Is(err, fs.SkipAll) {
 			// At first, I wasn't sure if fs.SkipAll implied that the rest of the entries
 			// should still be iterated and just "skipped" (i.e. no-ops) or if the walk
@@ -262,17 +408,162 @@ func (t Tar) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
 			skipDirs.add(hdr.Name)
 		} else if err != nil {
-			return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
+			if err := errs.handle(ctx, hdr.Name, err); err != nil {
+				return fmt.Errorf("handling file: %s: %w", hdr.Name, err)
+			}
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
+// OpenArchiveReader returns a reader that iterates the entries of the tar archive.
+func (t Tar) OpenArchiveReader(ctx context.Context, sourceArchive io.Reader) (ArchiveReader, error) {
+	textDec, err := t.textDecoder(sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+	src := newTarSource(sourceArchive)
+	return &tarArchiveReader{
+		ctx:     ctx,
+		src:     src,
+		tr:      tar.NewReader(src),
+		textDec: textDec,
+		limits:  t.Limits.newState(),
+	}, nil
+}
+
+// textDecoder returns a textDecoder for the names in sourceArchive. If
+// the encoding is to be detected and sourceArchive is an io.Seeker, the
+// decoder sees all the names in it first, after which sourceArchive is
+// sought back to where it was.
+func (t Tar) textDecoder(sourceArchive io.Reader) (*textDecoder, error) {
+	dec := newTextDecoder(t.TextEncoding, t.DetectTextEncoding)
+	rs, ok := sourceArchive.(io.ReadSeeker)
+	if dec.detector == nil || !ok {
+		return dec, nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return dec, nil // not actually seekable, so detect as it's read
+	}
+	tr := tar.NewReader(rs) // skips over contents by seeking
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break // any error will be reported when reading for real
+		}
+		dec.seeNonUTF8(hdr.Name, hdr.Linkname)
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking back to start of archive: %w", err)
+	}
+	dec.detector = nil // seen them all
+	return dec, nil
+}
+
+// decodeTarText decodes the name and link target of hdr into UTF-8 with dec,
+// if they are not UTF-8, and returns the encoding they were decoded from.
+func decodeTarText(dec *textDecoder, hdr *tar.Header) encoding.Encoding {
+	dec.seeNonUTF8(hdr.Name, hdr.Linkname)
+	name, linkname := dec.decodeNonUTF8(hdr.Name), dec.decodeNonUTF8(hdr.Linkname)
+	if name == hdr.Name && linkname == hdr.Linkname {
+		return nil
+	}
+	hdr.Name, hdr.Linkname = name, linkname
+	return dec.enc
+}
+
+// tarArchiveReader implements ArchiveReader for tar archives.
+type tarArchiveReader struct {
+	ctx     context.Context
+	src     *tarSource
+	tr      *tar.Reader
+	textDec *textDecoder
+	limits  *limitState
+}
+
+func (r *tarArchiveReader) Next() (FileInfo, error) {
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return FileInfo{}, err // honor context cancellation
+		}
+		r.src.header(r.tr)
+		hdr, err := r.tr.Next()
+		if err != nil {
+			return FileInfo{}, classifyError("", err)
+		}
+		sparseData := r.src.member(hdr)
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			// ignore the pax global header from git-generated tarballs
+			continue
+		}
+		textEnc := decodeTarText(r.textDec, hdr)
+		file := tarFileInfo(r.ctx, r.tr, hdr)
+		file.TextEncoding = textEnc
+		file.SparseData = sparseData
+		return r.limits.entry(file)
+	}
+}
+
+func (r *tarArchiveReader) Close() error { return r.src.Close() }
+
+// tarFileInfo returns a FileInfo for the current entry of tr, described by hdr.
+// Reads from the opened file honor ctx cancellation.
+func tarFileInfo(ctx context.Context, tr *tar.Reader, hdr *tar.Header) FileInfo {
+	info := hdr.FileInfo()
+	return FileInfo{
+		FileInfo:      info,
+		Header:        hdr,
+		NameInArchive: hdr.Name,
+		LinkTarget:    hdr.Linkname,
+		Owner:         &FileOwner{Uid: hdr.Uid, Gid: hdr.Gid, Uname: hdr.Uname, Gname: hdr.Gname},
+		AccessTime:    hdr.AccessTime,
+		Xattrs:        tarXattrs(hdr),
+		Open: func() (fs.File, error) {
+			return fileInArchive{newClassifyingReader(io.NopCloser(tr), hdr.Name), info, ctx}, nil
+		},
+	}
+}
+
+// tarXattrs returns the extended attributes of the member hdr,
+// which are stored as SCHILY.xattr.* PAX records, or nil if none.
+func tarXattrs(hdr *tar.Header) map[string]string {
+	var xattrs map[string]string
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, tarXattrPrefix); ok {
+			if xattrs == nil {
+				xattrs = make(map[string]string)
+			}
+			xattrs[name] = value
+		}
+	}
+	return xattrs
+}
+
+// setTarXattrs makes xattrs the extended attributes of the member hdr.
+func setTarXattrs(hdr *tar.Header, xattrs map[string]string) {
+	hdr.Xattrs = nil // replaced by the records
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, tarXattrPrefix) {
+			delete(hdr.PAXRecords, key)
+		}
+	}
+	if hdr.PAXRecords == nil && len(xattrs) > 0 {
+		hdr.PAXRecords = make(map[string]string, len(xattrs))
+	}
+	for name, value := range xattrs {
+		hdr.PAXRecords[tarXattrPrefix+name] = value
+	}
+}
+
+const tarXattrPrefix = "SCHILY.xattr."
+
 // Interface guards
 var (
-	_ Archiver      = (*Tar)(nil)
-	_ ArchiverAsync = (*Tar)(nil)
-	_ Extractor     = (*Tar)(nil)
-	_ Inserter      = (*Tar)(nil)
+	_ Archiver            = (*Tar)(nil)
+	_ ArchiverAsync       = (*Tar)(nil)
+	_ Extractor           = (*Tar)(nil)
+	_ Inserter            = (*Tar)(nil)
+	_ ArchiveReaderOpener = (*Tar)(nil)
 )
@@ -0,0 +1,240 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// A tar archive has no index, so finding a member means reading the headers
+// of those before it. If the archive is read from an io.Seeker, though, the
+// positions of its members are known as they're read, and a member whose
+// contents are stored in one piece can be read again straight from there.
+
+// tarOffsets locates a member of a tar archive in its input.
+type tarOffsets struct {
+	// The position of the member's first header block,
+	// including any PAX or GNU extension headers.
+	header int64
+
+	// The position of the member's contents, or -1 if they are
+	// sparse and so can't be read straight from the archive.
+	data int64
+}
+
+// tarIsSparse reports whether the contents of the member hdr are sparse,
+// in which case they're stored as the data of its regions, not as is.
+func tarIsSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// tarDataSize returns the length of the data stored for the member hdr,
+// which members of types that have only a header lack, whatever their size.
+func tarDataSize(hdr *tar.Header) int64 {
+	switch hdr.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		return 0
+	}
+	return hdr.Size
+}
+
+// tarBlocks returns the length of size bytes of data padded to whole blocks.
+func tarBlocks(size int64) int64 {
+	return (size + tarBlockSize - 1) / tarBlockSize * tarBlockSize
+}
+
+// withTarOffsets returns a context for handling the member at offsets.
+func withTarOffsets(ctx context.Context, offsets tarOffsets) context.Context {
+	return context.WithValue(ctx, tarOffsetsKey{}, offsets)
+}
+
+// tarOffsetsFromContext returns the offsets of the tar member being handled,
+// which are known if the archive is read from an io.Seeker.
+func tarOffsetsFromContext(ctx context.Context) (tarOffsets, bool) {
+	offsets, ok := ctx.Value(tarOffsetsKey{}).(tarOffsets)
+	return offsets, ok
+}
+
+type tarOffsetsKey struct{}
+
+// sectionFile is a file whose contents are read straight from a section of
+// the archive, which allows seeking and reading at offsets. Like files read
+// from the archive, reads honor ctx cancellation and the limits, if any.
+type sectionFile struct {
+	*io.SectionReader
+	info   fs.FileInfo
+	ctx    context.Context
+	limits *entryCount // nil if there are no limits
+	c      io.Closer   // closes the archive, if not nil
+}
+
+func (sf sectionFile) Read(p []byte) (int, error) {
+	if err := sf.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := sf.SectionReader.Read(p)
+	return n, sf.count(n, err)
+}
+
+func (sf sectionFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := sf.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := sf.SectionReader.ReadAt(p, off)
+	return n, sf.count(n, err)
+}
+
+// count accounts for n bytes read, which returned err.
+func (sf sectionFile) count(n int, err error) error {
+	if sf.limits == nil {
+		return err
+	}
+	if err := sf.limits.count(n); err != nil {
+		return err
+	}
+	return err
+}
+
+func (sf sectionFile) Stat() (fs.FileInfo, error) { return sf.info, nil }
+
+func (sf sectionFile) Close() error {
+	if sf.c == nil {
+		return nil
+	}
+	return sf.c.Close()
+}
+
+// tarSource is what the tar reader reads an archive from. It keeps track of
+// its position, and keeps the headers of each member as they're read, since
+// they hold what the tar reader doesn't report, like the map of a sparse
+// file, and reading can start again after them if they're corrupt.
+type tarSource struct {
+	r      io.Reader
+	seeker io.Seeker // r, if it can seek
+
+	start int64 // position of the start of the archive
+	pos   int64 // position of the next byte read
+	end   int64 // length of r, or -1 if unknown
+
+	// where the next header is expected, or -1 if that's
+	// only known once the previous member is read
+	next int64
+
+	// what was read from keepFrom on, if it's not -1,
+	// which is the headers of the member being read
+	keepFrom int64
+	kept     []byte
+
+	pending []byte       // read again before reading from r
+	buf     *spillBuffer // contents of the current member, if salvaging
+}
+
+func newTarSource(r io.Reader) *tarSource {
+	ts := &tarSource{r: r, end: -1, keepFrom: -1}
+	if s, ok := r.(io.Seeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			ts.seeker, ts.pos = s, pos
+			if end, err := streamSizeBySeeking(s); err == nil {
+				ts.end = end
+			}
+		}
+	}
+	ts.start, ts.next = ts.pos, ts.pos
+	return ts
+}
+
+func (ts *tarSource) Read(p []byte) (int, error) {
+	var n int
+	var err error
+	if len(ts.pending) > 0 {
+		n = copy(p, ts.pending)
+		ts.pending = ts.pending[n:]
+	} else {
+		n, err = ts.r.Read(p)
+	}
+	if ts.keepFrom >= 0 {
+		if skip := ts.keepFrom - ts.pos; skip < int64(n) {
+			ts.kept = append(ts.kept, p[max(skip, 0):n]...)
+		}
+	}
+	ts.pos += int64(n)
+	return n, err
+}
+
+// Seek skips forward from the current position, which is all the tar
+// reader needs. It doesn't skip past what is to be kept, so that it's read.
+func (ts *tarSource) Seek(offset int64, whence int) (int64, error) {
+	if ts.seeker == nil || whence != io.SeekCurrent || offset < 0 {
+		return 0, errors.ErrUnsupported
+	}
+	if ts.keepFrom >= 0 {
+		offset = min(offset, max(ts.keepFrom-ts.pos, 0))
+	}
+	n := min(offset, int64(len(ts.pending)))
+	ts.pending = ts.pending[n:]
+	if offset > n {
+		if _, err := ts.seeker.Seek(offset-n, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+	ts.pos += offset
+	return ts.pos, nil
+}
+
+// header prepares for tr to read the next member,
+// keeping its headers as they're read.
+func (ts *tarSource) header(tr *tar.Reader) {
+	if ts.buf != nil {
+		ts.buf.Close()
+		ts.buf = nil
+	}
+	if ts.next < 0 {
+		// the previous member's sparse map couldn't be read, so
+		// its contents are read to find where they end
+		io.Copy(io.Discard, tr)
+		ts.next = ts.start + tarBlocks(ts.pos-ts.start)
+	}
+	ts.keepFrom, ts.kept = ts.next, ts.kept[:0]
+}
+
+// member records that the header hdr was read, after which its contents are
+// the next thing read, and returns the regions that hold data if it's sparse.
+func (ts *tarSource) member(hdr *tar.Header) []SparseRegion {
+	kept := ts.kept
+	ts.keepFrom = -1
+	if !tarIsSparse(hdr) {
+		ts.next = ts.pos + tarBlocks(tarDataSize(hdr))
+		return nil
+	}
+	regions, ok := tarSparseData(hdr, kept)
+	if !ok {
+		ts.next = -1
+		return nil
+	}
+	var size int64
+	for _, region := range regions {
+		size += region.Length
+	}
+	ts.next = ts.pos + tarBlocks(size)
+	return regions
+}
+
+func (ts *tarSource) Close() error {
+	if ts.buf == nil {
+		return nil
+	}
+	return ts.buf.Close()
+}
+
+const tarBlockSize = 512
@@ -0,0 +1,111 @@
+package archives
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// The archive/tar reader can't go on after a corrupt header, since it can't
+// tell where the next one is. But every header starts on a block boundary
+// and can be recognized by its magic and checksum, so when salvaging, the
+// blocks after the corrupt header, including those the tar reader read (see
+// tarSource), are searched for the next header, where a new reader starts.
+
+// contents makes file, the member hdr being read by tr, read its contents
+// only as far as the archive holds them, and sets Truncated if they end early.
+func (ts *tarSource) contents(ctx context.Context, tr *tar.Reader, hdr *tar.Header, file *FileInfo) error {
+	size := tarDataSize(hdr)
+	if size == 0 {
+		return nil
+	}
+	info := file.FileInfo
+	if ts.end >= 0 && !tarIsSparse(hdr) {
+		// what's left of the archive is what's left of the contents
+		avail := max(ts.end-ts.pos, 0)
+		if avail >= size {
+			return nil
+		}
+		file.Truncated = true
+		r := io.LimitReader(tr, avail)
+		file.Open = func() (fs.File, error) {
+			return fileInArchive{newClassifyingReader(io.NopCloser(r), hdr.Name), info, ctx}, nil
+		}
+		return nil
+	}
+	ts.buf = new(spillBuffer)
+	if _, err := io.Copy(ts.buf, contextReadCloser{ctx, io.NopCloser(tr)}); errors.Is(err, io.ErrUnexpectedEOF) {
+		file.Truncated = true
+	} else if err != nil {
+		return classifyError(hdr.Name, err)
+	}
+	buf := ts.buf
+	file.Open = func() (fs.File, error) {
+		return fileInArchive{io.NopCloser(buf.reader()), info, ctx}, nil
+	}
+	return nil
+}
+
+// resync looks for the first valid header after the corrupt one where the
+// next header was expected, and makes it the next thing read. It returns the
+// range of the archive that was skipped, to the header or to the end of the
+// archive, and whether a header was found.
+func (ts *tarSource) resync(ctx context.Context) (*SkippedRangeError, bool, error) {
+	from := ts.keepFrom
+	skipped := &SkippedRangeError{Offset: from}
+	scan := from + tarBlockSize
+
+	// what was read after the corrupt block is read again
+	if ts.pos > scan {
+		ts.pending = append(ts.kept[scan-from:], ts.pending...)
+		ts.pos = scan
+	}
+	ts.keepFrom, ts.kept = -1, nil
+
+	block := make([]byte, tarBlockSize)
+	_, err := io.CopyN(io.Discard, ts, max(scan-ts.pos, 0))
+	for err == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+		if _, err = io.ReadFull(ts, block); err == nil && tarHeaderValid(block) {
+			ts.pos -= tarBlockSize
+			ts.pending = append(block, ts.pending...)
+			ts.next = ts.pos
+			skipped.Length = ts.pos - from
+			return skipped, true, nil
+		}
+	}
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	skipped.Length = max(ts.pos-from, 0)
+	return skipped, false, nil
+}
+
+// tarHeaderValid reports whether block is a ustar or GNU header,
+// judging by its magic and checksum.
+func tarHeaderValid(block []byte) bool {
+	if magic := string(block[257:265]); magic != "ustar\x0000" && magic != "ustar  \x00" {
+		return false
+	}
+	want, err := strconv.ParseInt(strings.Trim(string(block[148:156]), " \x00"), 8, 64)
+	if err != nil {
+		return false
+	}
+	// the checksum is of the header with spaces in place
+	// of the checksum, and some write it with signed bytes
+	var unsigned, signed int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		unsigned += int64(b)
+		signed += int64(int8(b))
+	}
+	return want == unsigned || want == signed
+}
@@ -0,0 +1,125 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestTarSalvage(t *testing.T) {
+	ctx := context.Background()
+
+	names := []string{"a.txt", "b.txt", "c.txt"}
+	contents := map[string][]byte{
+		"a.txt": []byte("first"),
+		"b.txt": bytes.Repeat([]byte("second\n"), 200),
+		"c.txt": bytes.Repeat([]byte("third\n"), 300),
+	}
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	headers := make(map[string]int64)
+	for _, name := range names {
+		tw.Flush()
+		headers[name] = int64(buf.Len())
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(contents[name])), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(contents[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// corrupt the header of the second file
+	corrupt := bytes.Clone(archive)
+	copy(corrupt[headers["b.txt"]:], "garbage")
+
+	// readers of the archive that can seek, and that can't
+	readers := map[string]func([]byte) io.Reader{
+		"Seeker":     func(b []byte) io.Reader { return bytes.NewReader(b) },
+		"Sequential": func(b []byte) io.Reader { return struct{ io.Reader }{bytes.NewReader(b)} },
+	}
+
+	type extracted struct {
+		data      []byte
+		truncated bool
+	}
+	extract := func(format Tar, r io.Reader) (map[string]extracted, []string, error) {
+		files := make(map[string]extracted)
+		var order []string
+		err := format.Extract(ctx, r, func(_ context.Context, f FileInfo) error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			files[f.NameInArchive] = extracted{data, f.Truncated}
+			order = append(order, f.NameInArchive)
+			return nil
+		})
+		return files, order, err
+	}
+
+	for readerName, newReader := range readers {
+		t.Run("CorruptHeader/"+readerName, func(t *testing.T) {
+			files, order, err := extract(Tar{Salvage: true, ContinueOnError: true}, newReader(corrupt))
+			if fmt.Sprint(order) != "[a.txt c.txt]" {
+				t.Fatalf("expected a.txt and c.txt to be extracted, got %v", order)
+			}
+			for _, name := range order {
+				if !bytes.Equal(files[name].data, contents[name]) || files[name].truncated {
+					t.Errorf("%s: contents do not match", name)
+				}
+			}
+			var skipped *SkippedRangeError
+			if !errors.As(err, &skipped) {
+				t.Fatalf("expected a *SkippedRangeError, got: %v", err)
+			}
+			if skipped.Offset != headers["b.txt"] || skipped.Length != headers["c.txt"]-headers["b.txt"] {
+				t.Errorf("expected %d bytes skipped at %d, got %d at %d",
+					headers["c.txt"]-headers["b.txt"], headers["b.txt"], skipped.Length, skipped.Offset)
+			}
+			if !errors.Is(err, tar.ErrHeader) {
+				t.Errorf("expected the header error to be reported, got: %v", err)
+			}
+		})
+
+		t.Run("Truncated/"+readerName, func(t *testing.T) {
+			cut := headers["c.txt"] + tarBlockSize + 100
+			files, order, err := extract(Tar{Salvage: true, ContinueOnError: true}, newReader(archive[:cut]))
+			if fmt.Sprint(order) != fmt.Sprint(names) {
+				t.Fatalf("expected %v to be extracted, got %v", names, order)
+			}
+			if c := files["c.txt"]; !c.truncated || !bytes.Equal(c.data, contents["c.txt"][:100]) {
+				t.Errorf("expected c.txt to be truncated to 100 bytes, got %d bytes (truncated: %t)", len(c.data), c.truncated)
+			}
+			if files["b.txt"].truncated {
+				t.Error("expected b.txt not to be truncated")
+			}
+			if !errors.Is(err, ErrTruncated) {
+				t.Errorf("expected the archive to be reported as truncated, got: %v", err)
+			}
+		})
+	}
+
+	t.Run("WithoutSalvage", func(t *testing.T) {
+		_, order, err := extract(Tar{ContinueOnError: true}, bytes.NewReader(corrupt))
+		if fmt.Sprint(order) != "[a.txt]" {
+			t.Errorf("expected only a.txt to be extracted, got %v", order)
+		}
+		if !errors.Is(err, tar.ErrHeader) {
+			t.Errorf("expected the header error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sparse files are stored in the PAX 1.0 format of GNU tar: the member's
+// contents are the map of the regions that hold data, as decimal numbers on
+// lines of their own, padded to a whole block, followed by the data of those
+// regions. Its name and size are recorded in GNU.sparse.* PAX records, and
+// its header has a placeholder name. The tar reader reads these, but the tar
+// writer leaves out GNU.sparse.* records, so the headers are written here.
+
+// tarWriter is a tar.Writer that members can
+// also be written to its output around.
+type tarWriter struct {
+	*tar.Writer
+	w io.Writer
+}
+
+func newTarWriter(w io.Writer) *tarWriter {
+	return &tarWriter{Writer: tar.NewWriter(w), w: w}
+}
+
+// writeSparseFile writes the sparse file, whose header is hdr, to tw as a
+// PAX 1.0 sparse member, with only the regions of its contents that hold data.
+func (t Tar) writeSparseFile(ctx context.Context, tw *tarWriter, hdr *tar.Header, file FileInfo) error {
+	var pos, dataSize int64
+	for _, region := range file.SparseData {
+		if region.Offset < pos || region.Length < 0 || region.Offset+region.Length > hdr.Size {
+			return fmt.Errorf("invalid sparse region of %d bytes at offset %d", region.Length, region.Offset)
+		}
+		pos = region.Offset + region.Length
+		dataSize += region.Length
+	}
+	regions := file.SparseData
+	if pos < hdr.Size {
+		// GNU tar takes the size of the file from where the
+		// last region ends, so a hole at the end needs one
+		regions = append(slices.Clip(regions), SparseRegion{Offset: hdr.Size})
+	}
+	sparseMap := append(strconv.AppendInt(nil, int64(len(regions)), 10), '\n')
+	for _, region := range regions {
+		sparseMap = append(strconv.AppendInt(sparseMap, region.Offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, region.Length, 10), '\n')
+	}
+	sparseMap = append(sparseMap, make([]byte, tarBlocks(int64(len(sparseMap)))-int64(len(sparseMap)))...)
+
+	records := map[string]string{
+		"GNU.sparse.major":    "1",
+		"GNU.sparse.minor":    "0",
+		"GNU.sparse.name":     hdr.Name,
+		"GNU.sparse.realsize": strconv.FormatInt(hdr.Size, 10),
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, "GNU.sparse.") && key != "path" && key != "size" {
+			records[key] = value
+		}
+	}
+
+	// fields that the ustar header can't hold go in the records
+	dir, base := path.Split(hdr.Name)
+	member := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Join(dir, "GNUSparseFile.0", base),
+		Mode:     hdr.Mode & 0o7777777,
+		Uid:      hdr.Uid,
+		Gid:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		Size:     int64(len(sparseMap)) + dataSize,
+		ModTime:  hdr.ModTime.Round(time.Second),
+	}
+	if !tarFitsUstar(member.Name, 100) {
+		member.Name = "GNUSparseFile.0" // the real name is recorded
+	}
+	if !tarFitsUstar(member.Uname, 32) {
+		records["uname"], member.Uname = member.Uname, ""
+	}
+	if !tarFitsUstar(member.Gname, 32) {
+		records["gname"], member.Gname = member.Gname, ""
+	}
+	if member.Uid < 0 || member.Uid > tarMaxOctal7 {
+		records["uid"], member.Uid = strconv.Itoa(member.Uid), 0
+	}
+	if member.Gid < 0 || member.Gid > tarMaxOctal7 {
+		records["gid"], member.Gid = strconv.Itoa(member.Gid), 0
+	}
+	if member.Size > tarMaxOctal11 {
+		records["size"], member.Size = strconv.FormatInt(member.Size, 10), 0
+	}
+	if mtime := member.ModTime.Unix(); mtime < 0 || mtime > tarMaxOctal11 {
+		records["mtime"], member.ModTime = strconv.FormatInt(mtime, 10), time.Unix(0, 0)
+	}
+
+	// the PAX header, its records, the member's header and the map
+	var paxData []byte
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		paxData = append(paxData, tarPAXRecord(key, records[key])...)
+	}
+	paxName := path.Join(dir, "PaxHeaders.0", base)
+	if !tarFitsUstar(paxName, 100) {
+		paxName = "PaxHeaders.0"
+	}
+	var headers bytes.Buffer
+	headers.Write(tarUstarBlock(&tar.Header{Typeflag: tar.TypeXHeader, Name: paxName, Size: int64(len(paxData))}))
+	headers.Write(paxData)
+	headers.Write(make([]byte, tarBlocks(int64(len(paxData)))-int64(len(paxData))))
+	headers.Write(tarUstarBlock(member))
+	headers.Write(sparseMap)
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := headers.WriteTo(tw.w); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+	if err := writeSparseData(ctx, tw.w, file); err != nil {
+		return fmt.Errorf("writing data: %w", err)
+	}
+	if pad := tarBlocks(dataSize) - dataSize; pad > 0 {
+		if _, err := tw.w.Write(make([]byte, pad)); err != nil {
+			return fmt.Errorf("writing data: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeSparseData writes the regions of the sparse file that hold data to w.
+func writeSparseData(ctx context.Context, w io.Writer, file FileInfo) error {
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	seeker, canSeek := f.(io.Seeker)
+	// only the data is progress, as holes are skipped if f can seek
+	r := contextReadCloser{ctx, progressFrom(ctx).observeFile(f)}
+	holes := contextReadCloser{ctx, f}
+	var pos int64
+	for _, region := range file.SparseData {
+		if canSeek {
+			_, err = seeker.Seek(region.Offset, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, holes, region.Offset-pos)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, region.Length); err != nil {
+			return err
+		}
+		pos = region.Offset + region.Length
+	}
+	return nil
+}
+
+// tarSparseData returns the regions of the sparse member hdr that hold data,
+// from its PAX records, or else from kept, which is what was read from its
+// first header up to its contents, and whether they could be read.
+func tarSparseData(hdr *tar.Header, kept []byte) ([]SparseRegion, bool) {
+	var numbers []string
+	switch {
+	case hdr.PAXRecords["GNU.sparse.major"] != "1" && hdr.PAXRecords["GNU.sparse.map"] != "":
+		// PAX 0.0 and 0.1, which the reader records as 0.1
+		numbers = strings.Split(hdr.PAXRecords["GNU.sparse.map"], ",")
+	default:
+		// the map follows the member's header, after those for PAX
+		// records and GNU long names
+		for {
+			if len(kept) < tarBlockSize {
+				return nil, false
+			}
+			switch kept[156] {
+			case tar.TypeXHeader, tar.TypeXGlobalHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+				size, ok := tarParseNumber(kept[124:136])
+				if !ok || size > int64(len(kept)) {
+					return nil, false
+				}
+				kept = kept[min(tarBlockSize+tarBlocks(size), int64(len(kept))):]
+				continue
+			case tar.TypeGNUSparse:
+				var ok bool
+				if numbers, ok = tarGNUSparseMap(kept); !ok {
+					return nil, false
+				}
+			default:
+				// PAX 1.0: the count of regions, then their offsets and lengths
+				lines := strings.Split(string(kept[tarBlockSize:]), "\n")
+				count, err := strconv.Atoi(lines[0])
+				if err != nil || count < 0 || 2*count >= len(lines) {
+					return nil, false
+				}
+				numbers = lines[1 : 1+2*count]
+			}
+			break
+		}
+	}
+
+	if len(numbers)%2 != 0 {
+		return nil, false
+	}
+	regions := make([]SparseRegion, 0, len(numbers)/2)
+	var pos int64
+	for i := 0; i < len(numbers); i += 2 {
+		offset, err1 := strconv.ParseInt(numbers[i], 10, 64)
+		length, err2 := strconv.ParseInt(numbers[i+1], 10, 64)
+		if err1 != nil || err2 != nil || offset < pos || length < 0 || offset > hdr.Size-length {
+			return nil, false
+		}
+		if length > 0 {
+			regions = append(regions, SparseRegion{Offset: offset, Length: length})
+		}
+		pos = offset + length
+	}
+	return regions, true
+}
+
+// tarGNUSparseMap returns the offsets and lengths of the regions in the map
+// of an old GNU sparse member, which starts in its header, in blocks, and
+// goes on in the blocks after it while they're marked as extended.
+func tarGNUSparseMap(blocks []byte) ([]string, bool) {
+	var numbers []string
+	entries, extended := blocks[386:482], blocks[482]
+	for {
+		for len(entries) >= 24 && entries[0] != 0 {
+			offset, ok1 := tarParseNumber(entries[:12])
+			length, ok2 := tarParseNumber(entries[12:24])
+			if !ok1 || !ok2 {
+				return nil, false
+			}
+			numbers = append(numbers, strconv.FormatInt(offset, 10), strconv.FormatInt(length, 10))
+			entries = entries[24:]
+		}
+		blocks = blocks[tarBlockSize:]
+		if extended == 0 {
+			return numbers, true
+		}
+		if len(blocks) < tarBlockSize {
+			return nil, false
+		}
+		entries, extended = blocks[:504], blocks[504]
+	}
+}
+
+// tarParseNumber parses a numeric field of a header,
+// which is in octal, or else base-256 if it's large.
+func tarParseNumber(field []byte) (int64, bool) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		var n int64
+		for i, b := range field {
+			if i == 0 {
+				b &= 0x7f
+			}
+			if n > (1<<63-1)>>8 {
+				return 0, false
+			}
+			n = n<<8 | int64(b)
+		}
+		return n, true
+	}
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(s, 8, 64)
+	return n, err == nil
+}
+
+// tarUstarBlock returns the ustar header block for hdr,
+// whose fields must fit, as tarFitsUstar reports.
+func tarUstarBlock(hdr *tar.Header) []byte {
+	block := make([]byte, tarBlockSize)
+	octal := func(field []byte, n int64) {
+		copy(field, fmt.Sprintf("%0*o", len(field)-1, n))
+	}
+	copy(block[0:100], hdr.Name)
+	octal(block[100:108], hdr.Mode)
+	octal(block[108:116], int64(hdr.Uid))
+	octal(block[116:124], int64(hdr.Gid))
+	octal(block[124:136], hdr.Size)
+	var mtime int64
+	if !hdr.ModTime.IsZero() {
+		mtime = hdr.ModTime.Unix()
+	}
+	octal(block[136:148], mtime)
+	block[156] = hdr.Typeflag
+	copy(block[257:265], "ustar\x0000")
+	copy(block[265:297], hdr.Uname)
+	copy(block[297:329], hdr.Gname)
+	octal(block[329:337], 0)
+	octal(block[337:345], 0)
+
+	// the checksum is of the block with spaces in place of itself
+	copy(block[148:156], "        ")
+	var sum int64
+	for _, b := range block {
+		sum += int64(b)
+	}
+	copy(block[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return block
+}
+
+// tarFitsUstar reports whether s fits in a
+// string field of a ustar header of size bytes.
+func tarFitsUstar(s string, size int) bool {
+	if len(s) > size {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 || s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// tarPAXRecord formats a PAX record, which starts with its own length.
+func tarPAXRecord(key, value string) string {
+	size := len(key) + len(value) + len(" =\n")
+	size += len(strconv.Itoa(size))
+	record := strconv.Itoa(size) + " " + key + "=" + value + "\n"
+	if len(record) != size {
+		// the length had one more digit than was counted
+		record = strconv.Itoa(len(record)) + " " + key + "=" + value + "\n"
+	}
+	return record
+}
+
+// Largest numbers that the numeric fields of a ustar header can hold.
+const (
+	tarMaxOctal7  = 1<<21 - 1
+	tarMaxOctal11 = 1<<33 - 1
+)
@@ -0,0 +1,192 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTarSparse(t *testing.T) {
+	ctx := context.Background()
+
+	contents := make([]byte, 1<<20)
+	copy(contents, bytes.Repeat([]byte("head"), 1024))
+	copy(contents[300000:], bytes.Repeat([]byte("middle"), 1000))
+	regions := []SparseRegion{{Offset: 0, Length: 4096}, {Offset: 300000, Length: 6000}}
+
+	// a name too long and not ASCII enough for a ustar header
+	longName := strings.Repeat("dïr/", 30) + "sparse.img"
+	sparse := memFile(longName, contents)
+	sparse.SparseData = regions
+	files := []FileInfo{memFile("before.txt", []byte("before")), sparse, memFile("after.txt", []byte("after"))}
+
+	buf := new(bytes.Buffer)
+	if err := (Tar{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 64<<10 {
+		t.Errorf("expected the holes not to be stored, but the archive is %d bytes", buf.Len())
+	}
+	archive := buf.Bytes()
+
+	t.Run("Progress", func(t *testing.T) {
+		// only the data of the sparse file is progress, whether
+		// its holes are skipped by seeking or by reading them
+		filename := filepath.Join(t.TempDir(), "sparse.img")
+		if err := os.WriteFile(filename, contents, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		seekable := sparse
+		seekable.Open = func() (fs.File, error) { return os.Open(filename) }
+		var want int64
+		for _, region := range regions {
+			want += region.Length
+		}
+		for name, file := range map[string]FileInfo{"Seeker": seekable, "Sequential": sparse} {
+			var done int64
+			ctx := WithObserver(ctx, ObserverFunc(func(e Event) { done = e.BytesDone }))
+			if err := (Tar{}).Archive(ctx, io.Discard, []FileInfo{file}); err != nil {
+				t.Fatal(err)
+			}
+			if done != want {
+				t.Errorf("%s: expected %d bytes of progress, got %d", name, want, done)
+			}
+		}
+	})
+
+	check := func(t *testing.T, file FileInfo) {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch file.NameInArchive {
+		case longName:
+			if !bytes.Equal(data, contents) {
+				t.Errorf("%s: contents do not match", file.NameInArchive)
+			}
+			if !reflect.DeepEqual(file.SparseData, regions) {
+				t.Errorf("expected sparse regions %v, got %v", regions, file.SparseData)
+			}
+		case "before.txt", "after.txt":
+			if want := strings.TrimSuffix(file.NameInArchive, ".txt"); string(data) != want {
+				t.Errorf("%s: expected %q, got %q", file.NameInArchive, want, data)
+			}
+			if file.SparseData != nil {
+				t.Errorf("%s: expected no sparse regions, got %v", file.NameInArchive, file.SparseData)
+			}
+		default:
+			t.Errorf("unexpected file %q", file.NameInArchive)
+		}
+	}
+
+	readers := map[string]io.Reader{
+		"Seeker":     bytes.NewReader(archive),
+		"Sequential": struct{ io.Reader }{bytes.NewReader(archive)},
+	}
+	for name, r := range readers {
+		t.Run("Extract/"+name, func(t *testing.T) {
+			var count int
+			err := Tar{}.Extract(ctx, r, func(_ context.Context, file FileInfo) error {
+				count++
+				check(t, file)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(files) {
+				t.Errorf("expected %d files, got %d", len(files), count)
+			}
+		})
+	}
+
+	t.Run("ArchiveReader", func(t *testing.T) {
+		ar, err := Tar{}.OpenArchiveReader(ctx, bytes.NewReader(archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ar.Close()
+		for range files {
+			file, err := ar.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, file)
+		}
+	})
+
+	t.Run("ExtractToDisk", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := ExtractToDisk(ctx, Tar{}, bytes.NewReader(archive), dir, nil); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(longName)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, contents) {
+			t.Error("contents of file extracted to disk do not match")
+		}
+	})
+}
+
+// The archives in testdata were created by GNU tar, in its old GNU format
+// and in PAX format with version 0.1 of its sparse map, from a file of 200000
+// bytes with data at offsets 0 and 100000 only.
+func TestTarSparseGNU(t *testing.T) {
+	want := make([]byte, 200000)
+	copy(want, bytes.Repeat([]byte("head"), 100))
+	copy(want[100000:], bytes.Repeat([]byte("middle"), 100))
+
+	for _, name := range []string{"sparse-gnu.tar", "sparse-pax01.tar"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			var got []string
+			err = Tar{}.Extract(context.Background(), f, func(_ context.Context, file FileInfo) error {
+				got = append(got, file.NameInArchive)
+				if file.NameInArchive != "sparse.img" {
+					return nil
+				}
+				rc, err := file.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				if err != nil {
+					return err
+				}
+				if !bytes.Equal(data, want) {
+					t.Error("contents do not match")
+				}
+				if len(file.SparseData) != 2 || file.SparseData[0].Offset != 0 ||
+					file.SparseData[1].Offset > 100000 || file.SparseData[1].Offset+file.SparseData[1].Length < 100600 {
+					t.Errorf("expected two sparse regions holding the data, got %v", file.SparseData)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != "[sparse.img after.txt]" {
+				t.Errorf("expected sparse.img and after.txt, got %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package archives
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// Archive formats that predate Unicode store names in whatever encoding
+// the system that made them used, without saying which: zip archives in
+// the DOS code page of the locale (CP437 in the US, CP866 in Russia) or
+// the Windows one, and tar and RAR archives in the locale's encoding. An
+// encoding can be guessed from the names, since text in one encoding
+// decoded as another tends to look like gibberish: box drawing characters,
+// symbols, and accented letters or ideographs in unlikely combinations.
+
+// DetectTextEncoding returns the encoding that the texts, which are not
+// UTF-8, are most plausibly in, or nil if they decode in none of the
+// encodings it knows: code pages 437, 850 and 866, Windows-1252 and 1251,
+// Shift JIS, EUC-JP, GBK, Big5 and EUC-KR. The more texts there are, the
+// more reliable the guess, so it's best to give all the names in an
+// archive at once.
+func DetectTextEncoding(texts []string) encoding.Encoding {
+	var d textEncodingDetector
+	for _, text := range texts {
+		d.add(text)
+	}
+	return d.best()
+}
+
+// textEncodingDetector detects the encoding of texts as they are added.
+type textEncodingDetector struct {
+	scores  [len(textEncodingCandidates)]int // of each candidate
+	invalid [len(textEncodingCandidates)]bool
+}
+
+func (d *textEncodingDetector) add(text string) {
+	for i, candidate := range textEncodingCandidates {
+		if d.invalid[i] {
+			continue
+		}
+		decoded, err := candidate.enc.NewDecoder().String(text)
+		if err != nil || strings.ContainsRune(decoded, utf8.RuneError) {
+			d.invalid[i] = true
+			continue
+		}
+		d.scores[i] += textPlausibility(decoded, candidate.common)
+	}
+}
+
+// best returns the most plausible encoding of the texts added so far.
+func (d *textEncodingDetector) best() encoding.Encoding {
+	best := -1
+	for i := range textEncodingCandidates {
+		// candidates are in order of preference, which breaks ties
+		if !d.invalid[i] && (best < 0 || d.scores[i] > d.scores[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return textEncodingCandidates[best].enc
+}
+
+// textPlausibility scores how plausible it is that text is what was
+// written, rather than the result of decoding it with the wrong encoding.
+// Each character adds about 1 per byte it takes in legacy encodings if it
+// is plausible, and subtracts if not. Characters in common are likelier.
+func textPlausibility(text, common string) int {
+	runes := []rune(text)
+	nextToASCIILetter := func(i int) bool {
+		return i > 0 && isASCIILetter(runes[i-1]) || i+1 < len(runes) && isASCIILetter(runes[i+1])
+	}
+
+	var score int
+	for i, r := range runes {
+		switch {
+		case r < utf8.RuneSelf:
+			if unicode.IsControl(r) {
+				score -= 2
+			}
+		case unicode.IsControl(r):
+			score -= 2
+		case unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]):
+			score-- // capitals rarely follow lowercase letters
+
+		// accented letters are usually among unaccented ones, while
+		// letters of other alphabets are not
+		case r >= 0xc0 && r <= 0xff && r != 0xd7 && r != 0xf7:
+			if nextToASCIILetter(i) {
+				score++
+			} else {
+				score--
+			}
+		case r >= 0x400 && r <= 0x45f, r == 0x490, r == 0x491:
+			if nextToASCIILetter(i) {
+				score--
+			} else {
+				score++
+			}
+
+		case r >= 0x3040 && r <= 0x30ff: // hiragana and katakana
+			score += 2
+		case r >= 0xff61 && r <= 0xff9f: // halfwidth katakana
+			score--
+		case unicode.Is(unicode.Han, r), r >= 0xac00 && r <= 0xd7a3: // Hangul syllables
+			score++
+			if strings.ContainsRune(common, r) {
+				score++
+			}
+		case r == '’':
+			// the apostrophe that word processors produce
+			if nextToASCIILetter(i) {
+				score++
+			}
+		case strings.ContainsRune("‘“”–—…«»№", r):
+			// other punctuation that word processors produce
+		default:
+			score--
+		}
+	}
+	return score
+}
+
+func isASCIILetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// textEncodingCandidates are the encodings DetectTextEncoding chooses
+// from, in order of preference, with some of the most common characters
+// of their language that are not ASCII.
+var textEncodingCandidates = [...]struct {
+	enc    encoding.Encoding
+	common string
+}{
+	{enc: charmap.CodePage437}, // the original encoding of zip archives
+	{enc: charmap.CodePage850},
+	{enc: charmap.Windows1252},
+	{enc: japanese.ShiftJIS, common: commonJapanese},
+	{enc: japanese.EUCJP, common: commonJapanese},
+	{enc: simplifiedchinese.GBK, common: commonSimplifiedChinese},
+	{enc: traditionalchinese.Big5, common: commonTraditionalChinese},
+	{enc: korean.EUCKR, common: commonKorean},
+	{enc: charmap.CodePage866},
+	{enc: charmap.Windows1251},
+}
+
+const (
+	commonJapanese = "日一人年大十二本中長出三時行見月分後前生五間上東四今金九入学高円子外八六下来気小七山話女北午百書先名川千水半男西電校語土木聞食車何南万毎白天母火右読友左休父雨" +
+		"新規写真資料文書画像動画音楽報告会議"
+	commonSimplifiedChinese = "的一是不了在人有我他这个们中来上大为和国地到以说时要就出会可也你对生能而子那得于着下自之年过发后作里用道行所然家种事成方多经么去法学如都同现当没动面起看定天分还进好小部其些主样理心她本前开但因只从想实日" +
+		"文件新建图片资料照片视频音乐文档载目录备份报告表格项工总结议通知"
+	commonTraditionalChinese = "的一是不了在人有我他這個們中來上大為和國地到以說時要就出會可也你對生能而子那得於著下自之年過發後作裏用道行所然家種事成方多經麼去法學如都同現當沒動面起看定天分還進好小部其些主樣理心她本前開但因只從想實日" +
+		"文件新建圖片資料照片視頻音樂文檔載目錄備份報告表格項工總結議通知"
+	commonKorean = "이다의는에하고을가로한지서사자리기대정수도일시아인나부전보제구우스어니요해주비마장신상화라문드트" +
+		"진새폴더파일료고회음악동영바탕면"
+)
+
+// textDecoder decodes the names and comments of the entries of an archive
+// that are not UTF-8 from an encoding that is given, or detected from
+// them. When an archive can only be read once, the encoding is detected
+// from the texts seen so far, so it may change as more are seen.
+type textDecoder struct {
+	enc      encoding.Encoding // given, or the best guess so far
+	detector *textEncodingDetector
+}
+
+// newTextDecoder returns a textDecoder that decodes from enc if not nil,
+// or else from the encoding detected from the texts it sees if detect
+// is true, or else not at all.
+func newTextDecoder(enc encoding.Encoding, detect bool) *textDecoder {
+	dec := &textDecoder{enc: enc}
+	if enc == nil && detect {
+		dec.detector = new(textEncodingDetector)
+	}
+	return dec
+}
+
+// see adds the texts, which are not UTF-8, to those the
+// encoding is detected from, if it is being detected.
+func (d *textDecoder) see(texts ...string) {
+	if d.detector == nil {
+		return
+	}
+	var added bool
+	for _, text := range texts {
+		if text != "" {
+			d.detector.add(text)
+			added = true
+		}
+	}
+	if added {
+		d.enc = d.detector.best()
+	}
+}
+
+// seeNonUTF8 is like see, but only adds the texts that are not valid
+// UTF-8, for formats that don't say which texts are.
+func (d *textDecoder) seeNonUTF8(texts ...string) {
+	for _, text := range texts {
+		if !utf8.ValidString(text) {
+			d.see(text)
+		}
+	}
+}
+
+// decode decodes text, which is not UTF-8, into UTF-8. It returns
+// text unchanged if there is no encoding or text can't be decoded.
+func (d *textDecoder) decode(text string) string {
+	if d.enc == nil || text == "" {
+		return text
+	}
+	decoded, err := d.enc.NewDecoder().String(text)
+	if err != nil {
+		return text
+	}
+	return decoded
+}
+
+// decodeNonUTF8 is like decode, but only decodes text that is not valid
+// UTF-8, for formats that don't say which texts are.
+func (d *textDecoder) decodeNonUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return d.decode(text)
+}
@@ -0,0 +1,131 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestDetectTextEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		enc   encoding.Encoding
+		names []string
+	}{
+		{enc: charmap.CodePage437, names: []string{"Müller/Größe.txt", "Bücher"}},
+		{enc: charmap.CodePage437, names: []string{"Año nuevo/señal.pdf", "información.txt"}},
+		{enc: charmap.Windows1252, names: []string{"café.doc", "Élan vital.txt", "Ça va.txt"}},
+		{enc: charmap.Windows1252, names: []string{"Don’t panic.txt"}},
+		{enc: charmap.CodePage866, names: []string{"Документы/отчёт за март.doc", "Фото"}},
+		{enc: charmap.Windows1251, names: []string{"привет.txt"}},
+		{enc: charmap.Windows1251, names: []string{"ОТЧЕТ.doc"}},
+		{enc: japanese.ShiftJIS, names: []string{"写真/ファイル.jpg", "新しいフォルダ"}},
+		{enc: japanese.EUCJP, names: []string{"会議資料.pdf"}},
+		{enc: simplifiedchinese.GBK, names: []string{"新建文件夹/图片.png", "资料"}},
+		{enc: simplifiedchinese.GBK, names: []string{"中文.txt"}},
+		{enc: traditionalchinese.Big5, names: []string{"會議記錄.doc"}},
+		{enc: korean.EUCKR, names: []string{"새 폴더/사진.jpg", "문서"}},
+		{enc: korean.EUCKR, names: []string{"서울"}},
+	} {
+		encoded := encodeTexts(t, tc.enc, tc.names...)
+		if got := DetectTextEncoding(encoded); got != tc.enc {
+			t.Errorf("%v: expected %v, got %v", tc.names, tc.enc, got)
+		}
+	}
+
+	if got := DetectTextEncoding(nil); got != charmap.CodePage437 {
+		t.Errorf("expected the first candidate without texts, got %v", got)
+	}
+}
+
+func TestDetectTextEncodingInArchives(t *testing.T) {
+	ctx := context.Background()
+	names := []string{"Документы/", "Документы/отчёт за март.doc", "Фото.jpg", "readme.txt"}
+	encoded := encodeTexts(t, charmap.CodePage866, names...)
+
+	zipBuf := new(bytes.Buffer)
+	zw := zip.NewWriter(zipBuf)
+	for _, name := range encoded {
+		if _, err := zw.CreateHeader(&zip.FileHeader{Name: name, NonUTF8: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tarBuf := new(bytes.Buffer)
+	tw := tar.NewWriter(tarBuf)
+	for _, name := range encoded {
+		hdr := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Format: tar.FormatGNU}
+		if strings.HasSuffix(name, "/") {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		format  Extractor
+		archive []byte
+	}{
+		{name: "zip", format: Zip{DetectTextEncoding: true}, archive: zipBuf.Bytes()},
+		{name: "tar", format: Tar{DetectTextEncoding: true}, archive: tarBuf.Bytes()},
+	} {
+		// streams are decoded as they are read, and seekable
+		// archives after seeing all their names
+		for _, stream := range []bool{false, true} {
+			var input io.Reader = bytes.NewReader(tc.archive)
+			if stream {
+				input = struct{ io.Reader }{input}
+			}
+			var got []string
+			err := tc.format.Extract(ctx, input, func(_ context.Context, f FileInfo) error {
+				got = append(got, f.NameInArchive)
+				if want := encoding.Encoding(charmap.CodePage866); f.NameInArchive != "readme.txt" && f.TextEncoding != want {
+					t.Errorf("%s: %s: expected text encoding %v, got %v", tc.name, f.NameInArchive, want, f.TextEncoding)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(names) {
+				t.Fatalf("%s: expected %d files, got %d", tc.name, len(names), len(got))
+			}
+			for i := range names {
+				if got[i] != names[i] {
+					t.Errorf("%s: expected name %q, got %q", tc.name, names[i], got[i])
+				}
+			}
+		}
+	}
+}
+
+// encodeTexts returns texts encoded with enc.
+func encodeTexts(t *testing.T, enc encoding.Encoding, texts ...string) []string {
+	t.Helper()
+	encoded := make([]string, len(texts))
+	for i, text := range texts {
+		var err error
+		if encoded[i], err = enc.NewEncoder().String(text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return encoded
+}
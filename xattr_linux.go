@@ -0,0 +1,51 @@
+package archives
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// fileXattrs returns the extended attributes of the file on disk named
+// filename, which include its POSIX ACLs, or nil if it has none.
+func fileXattrs(filename string) (map[string]string, error) {
+	names, err := xattrCall(func(buf []byte) (int, error) { return syscall.Listxattr(filename, buf) })
+	if errors.Is(err, syscall.ENOTSUP) || len(names) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimSuffix(string(names), "\x00"), "\x00") {
+		value, err := xattrCall(func(buf []byte) (int, error) { return syscall.Getxattr(filename, name, buf) })
+		if errors.Is(err, syscall.ENODATA) {
+			continue // removed since it was listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs[name] = string(value)
+	}
+	return xattrs, nil
+}
+
+// xattrCall returns what call puts in a buffer, after calling it to find
+// out how big the buffer must be, and again if it grew in the meantime.
+func xattrCall(call func([]byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := call(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		size, err = call(buf)
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:size], nil
+	}
+}
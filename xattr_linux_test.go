@@ -0,0 +1,52 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestFilesFromDiskXattrs(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(filename, []byte("contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(filename, "user.archives-test", []byte("value\x00with\xffbytes"), 0); err != nil {
+		t.Skipf("the file system doesn't support user extended attributes: %v", err)
+	}
+
+	files, err := FilesFromDisk(ctx, nil, map[string]string{filename: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Xattrs != nil {
+		t.Errorf("expected no extended attributes unless asked for, got %q", files[0].Xattrs)
+	}
+
+	files, err = FilesFromDisk(ctx, &FromDiskOptions{Xattrs: true}, map[string]string{filename: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files[0].Xattrs["user.archives-test"]; got != "value\x00with\xffbytes" {
+		t.Fatalf("expected the extended attribute to be read, got %q", files[0].Xattrs)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := (Tar{}).Archive(ctx, buf, files); err != nil {
+		t.Fatal(err)
+	}
+	err = Tar{}.Extract(ctx, buf, func(_ context.Context, file FileInfo) error {
+		if !reflect.DeepEqual(file.Xattrs, files[0].Xattrs) {
+			t.Errorf("expected extended attributes %q, got %q", files[0].Xattrs, file.Xattrs)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
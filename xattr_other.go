@@ -0,0 +1,6 @@
+//go:build !linux
+
+package archives
+
+// fileXattrs returns nil, since extended attributes are only read on Linux.
+func fileXattrs(filename string) (map[string]string, error) { return nil, nil }
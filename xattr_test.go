@@ -0,0 +1,65 @@
+package archives
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTarXattrs(t *testing.T) {
+	ctx := context.Background()
+
+	xattrs := map[string]string{
+		"user.comment":            "hello",
+		"security.capability":     "\x01\x00\x00\x02\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		"system.posix_acl_access": "\x02\x00\x00\x00\x01\x00\x06\x00\xff\xff\xff\xff",
+		"user.ünïcode":            "välue",
+	}
+	plain := memFile("plain.txt", []byte("plain"))
+	plain.Xattrs = xattrs
+	sparse := memFile("sparse.img", make([]byte, 1<<16))
+	sparse.SparseData = []SparseRegion{}
+	sparse.Xattrs = xattrs
+	files := []FileInfo{plain, sparse, memFile("none.txt", []byte("none"))}
+
+	extract := func(t *testing.T, format Tar) map[string]map[string]string {
+		buf := new(bytes.Buffer)
+		if err := format.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]map[string]string)
+		err := format.Extract(ctx, buf, func(_ context.Context, file FileInfo) error {
+			got[file.NameInArchive] = file.Xattrs
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := extract(t, Tar{})
+	for _, name := range []string{"plain.txt", "sparse.img"} {
+		if !reflect.DeepEqual(got[name], xattrs) {
+			t.Errorf("%s: expected extended attributes %q, got %q", name, xattrs, got[name])
+		}
+	}
+	if got["none.txt"] != nil {
+		t.Errorf("none.txt: expected no extended attributes, got %q", got["none.txt"])
+	}
+
+	// the GNU format has no place for them
+	got = extract(t, Tar{FormatGNU: true})
+	if got["plain.txt"] != nil {
+		t.Errorf("expected no extended attributes in GNU format, got %q", got["plain.txt"])
+	}
+
+	// those of the file replace those of a header it was read with
+	hdr := &tar.Header{Name: "from-tar", Typeflag: tar.TypeReg, PAXRecords: map[string]string{"SCHILY.xattr.user.old": "x", "comment": "kept"}}
+	setTarXattrs(hdr, map[string]string{"user.new": "y"})
+	if want := map[string]string{"SCHILY.xattr.user.new": "y", "comment": "kept"}; !reflect.DeepEqual(hdr.PAXRecords, want) {
+		t.Errorf("expected records %q, got %q", want, hdr.PAXRecords)
+	}
+}
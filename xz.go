@@ -3,7 +3,11 @@ package archives
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"slices"
 	"strings"
 
 	fastxz "github.com/mikelolasagasti/xz"
@@ -45,11 +49,105 @@ func (Xz) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 func (Xz) OpenReader(r io.Reader) (io.ReadCloser, error) {
 	xr, err := fastxz.NewReader(r, 0)
 	if err != nil {
-		return nil, err
+		return nil, classifyError("", err)
 	}
-	return io.NopCloser(xr), err
+	return newClassifyingReader(io.NopCloser(xr), ""), nil
+}
+
+// Streams returns the offsets of the xz streams in r, which are found from
+// the end, since the footer and index of a stream record its length.
+func (Xz) Streams(r io.ReaderAt, size int64) ([]int64, error) {
+	r = io.NewSectionReader(r, 0, size)
+	var offsets []int64
+	for end := size; end > 0; {
+		// streams may be followed by padding of null bytes, in fours
+		var word [4]byte
+		if end >= 4 {
+			if _, err := r.ReadAt(word[:], end-4); err != nil {
+				return nil, noEOF(err)
+			}
+			if word == [4]byte{} {
+				end -= 4
+				continue
+			}
+		}
+		start, err := xzStreamStart(r, end)
+		if err != nil {
+			return nil, fmt.Errorf("stream ending at %d: %w", end, err)
+		}
+		offsets = append(offsets, start)
+		end = start
+	}
+	slices.Reverse(offsets)
+	return offsets, nil
+}
+
+// xzStreamStart returns the start of the stream that ends at end in r.
+func xzStreamStart(r io.ReaderAt, end int64) (int64, error) {
+	var footer [xzHeaderLen]byte
+	if end < 2*xzHeaderLen {
+		return 0, errors.New("too short to be a stream")
+	}
+	if _, err := r.ReadAt(footer[:], end-xzHeaderLen); err != nil {
+		return 0, noEOF(err)
+	}
+	if !bytes.Equal(footer[10:], xzFooterMagic) {
+		return 0, errors.New("no stream footer")
+	}
+
+	// the index records the length of each block in the stream
+	indexLen := (int64(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	indexStart := end - xzHeaderLen - indexLen
+	if indexStart < xzHeaderLen {
+		return 0, errors.New("index is too long")
+	}
+	index := make([]byte, indexLen)
+	if _, err := r.ReadAt(index, indexStart); err != nil {
+		return 0, noEOF(err)
+	}
+	if index[0] != 0 {
+		return 0, errors.New("no index")
+	}
+	buf := index[1:]
+	records, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, errors.New("malformed index")
+	}
+	buf = buf[n:]
+	var blocksLen int64
+	for i := uint64(0); i < records; i++ {
+		unpaddedLen, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, errors.New("malformed index")
+		}
+		buf = buf[n:]
+		if _, n = binary.Uvarint(buf); n <= 0 { // uncompressed size
+			return 0, errors.New("malformed index")
+		}
+		buf = buf[n:]
+		blocksLen += (int64(unpaddedLen) + 3) &^ 3 // blocks are padded to four bytes
+	}
+
+	start := indexStart - blocksLen - xzHeaderLen
+	if start < 0 {
+		return 0, errors.New("blocks are too long")
+	}
+	header := make([]byte, len(xzHeader))
+	if _, err := r.ReadAt(header, start); err != nil {
+		return 0, noEOF(err)
+	}
+	if !bytes.Equal(header, xzHeader) {
+		return 0, errors.New("no stream header")
+	}
+	return start, nil
 }
 
 // magic number at the beginning of xz files; see section 2.1.1.1
 // of https://tukaani.org/xz/xz-file-format.txt
 var xzHeader = []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}
+
+// magic number at the end of xz streams, whose headers and footers are
+// the same length
+var xzFooterMagic = []byte("YZ")
+
+const xzHeaderLen = 12
@@ -7,15 +7,14 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"os"
-	"path"
 	"strings"
 
 	szip "github.com/STARRY-S/zip"
 	"golang.org/x/text/encoding"
 
 	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/zip"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
@@ -24,63 +23,202 @@ import (
 func init() {
 	RegisterFormat(Zip{})
 
+	for method, comp := range zipCompressors {
+		zip.RegisterCompressor(method, comp)
+	}
+	for method, dcomp := range zipDecompressors {
+		zip.RegisterDecompressor(method, dcomp)
+	}
+}
+
+// Compressors and decompressors for the additional methods, which
+// are registered with the zip package but also needed for encrypted
+// entries, which are compressed and decompressed by this package.
+var (
 	// TODO: What about custom flate levels too
-	zip.RegisterCompressor(ZipMethodBzip2, func(out io.Writer) (io.WriteCloser, error) {
-		return bzip2.NewWriter(out, &bzip2.WriterConfig{ /*TODO: Level: z.CompressionLevel*/ })
-	})
-	zip.RegisterCompressor(ZipMethodZstd, func(out io.Writer) (io.WriteCloser, error) {
-		return zstd.NewWriter(out)
-	})
-	zip.RegisterCompressor(ZipMethodXz, func(out io.Writer) (io.WriteCloser, error) {
-		return xz.NewWriter(out)
-	})
-
-	zip.RegisterDecompressor(ZipMethodBzip2, func(r io.Reader) io.ReadCloser {
-		bz2r, err := bzip2.NewReader(r, nil)
-		if err != nil {
-			return nil
-		}
-		return bz2r
-	})
-	zip.RegisterDecompressor(ZipMethodZstd, func(r io.Reader) io.ReadCloser {
-		zr, err := zstd.NewReader(r)
-		if err != nil {
-			return nil
-		}
-		return zr.IOReadCloser()
-	})
-	zip.RegisterDecompressor(ZipMethodXz, func(r io.Reader) io.ReadCloser {
-		xr, err := xz.NewReader(r)
-		if err != nil {
-			return nil
-		}
-		return io.NopCloser(xr)
-	})
+	zipCompressors = map[uint16]zip.Compressor{
+		ZipMethodBzip2: func(out io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(out, &bzip2.WriterConfig{ /*TODO: Level: z.CompressionLevel*/ })
+		},
+		ZipMethodZstd: func(out io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(out)
+		},
+		ZipMethodXz: func(out io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(out)
+		},
+		ZipMethodLzma: newZipLZMAWriter,
+	}
+	zipDecompressors = map[uint16]zip.Decompressor{
+		ZipMethodBzip2: func(r io.Reader) io.ReadCloser {
+			bz2r, err := bzip2.NewReader(r, nil)
+			if err != nil {
+				return nil
+			}
+			return bz2r
+		},
+		ZipMethodZstd: func(r io.Reader) io.ReadCloser {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil
+			}
+			return zr.IOReadCloser()
+		},
+		ZipMethodXz: func(r io.Reader) io.ReadCloser {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil
+			}
+			return io.NopCloser(xr)
+		},
+		// these can only read entries that end with an end marker;
+		// zipEntryDecompressor returns ones that use the entry's size
+		ZipMethodDeflate64: newDeflate64Reader,
+		ZipMethodLzma: func(r io.Reader) io.ReadCloser {
+			return newZipLZMAReader(r, -1)
+		},
+		ZipMethodPPMd: func(r io.Reader) io.ReadCloser {
+			return newZipPPMdReader(r, -1)
+		},
+	}
+)
+
+// zipCompressor returns the compressor for method, or nil if there is none.
+func zipCompressor(method uint16) zip.Compressor {
+	switch method {
+	case zip.Store:
+		return func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil }
+	case zip.Deflate:
+		return func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) }
+	}
+	return zipCompressors[method]
+}
+
+// zipDecompressor returns the decompressor for method, or nil if there is none.
+func zipDecompressor(method uint16) zip.Decompressor {
+	switch method {
+	case zip.Store:
+		return io.NopCloser
+	case zip.Deflate:
+		return flate.NewReader
+	}
+	return zipDecompressors[method]
+}
+
+// zipEntryDecompressor returns the decompressor for the data of an entry
+// that is compressed with method, or nil if there is none. Unlike the
+// decompressors registered with the zip package, it can read LZMA and PPMd
+// data without an end marker if the uncompressed size is known; it is
+// negative if not.
+func zipEntryDecompressor(method uint16, size int64) zip.Decompressor {
+	switch method {
+	case ZipMethodLzma:
+		return func(r io.Reader) io.ReadCloser { return newZipLZMAReader(r, size) }
+	case ZipMethodPPMd:
+		return func(r io.Reader) io.ReadCloser { return newZipPPMdReader(r, size) }
+	}
+	return zipDecompressor(method)
+}
+
+// decompressZipEntry returns a reader of the data of the entry name, read
+// from r by decompress. Some decompressors read the start of the data right
+// away, and return nil if it isn't valid, which is reported as corruption.
+func decompressZipEntry(name string, decompress zip.Decompressor, r io.Reader) (io.ReadCloser, error) {
+	rc := decompress(r)
+	if rc == nil {
+		return nil, &ArchiveError{Entry: name, Kind: ErrCorrupt, Err: errZipCompressedData}
+	}
+	return rc, nil
 }
 
+var errZipCompressedData = errors.New("zip: invalid compressed data")
+
+// nopWriteCloser is an io.WriteCloser whose Close does nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
 type Zip struct {
 	// Only compress files which are not already in a
-	// compressed format (determined simply by examining
-	// file extension).
+	// compressed format, as determined from their file
+	// extension and contents by SelectiveZipCompression.
 	SelectiveCompression bool
 
 	// The method or algorithm for compressing stored files.
 	Compression uint16
 
+	// If set, chooses how to compress each file from its
+	// name, size and the start of its contents, instead of
+	// Compression and SelectiveCompression, when archiving
+	// and inserting.
+	CompressionPolicy ZipCompressionPolicy
+
+	// If greater than 1, up to this many files are compressed
+	// at once when archiving, each into memory or a temporary
+	// file, and then written in order. The archive is the same
+	// as when files are compressed one at a time, except for the
+	// random salts of encrypted files.
+	Concurrency int
+
+	// If greater than 1, the contents of stored (uncompressed)
+	// files start at a multiple of this many bytes from the start
+	// of the archive when archiving, so that they can be mapped
+	// into memory, as Android packages require of 4 bytes. Their
+	// headers are padded to align them. VerifyAlignment reports
+	// the files in an archive that are not aligned.
+	Alignment int
+
+	// If true, stored shared libraries (.so files) are aligned
+	// to 4 KiB pages instead of Alignment when archiving, so
+	// that they can be loaded straight from Android packages.
+	AlignSharedLibraries bool
+
 	// If true, errors encountered during reading or writing
-	// a file within an archive will be logged and the
-	// operation will continue on remaining files.
+	// a file within an archive will be skipped and the
+	// operation will continue on remaining files. The errors
+	// are returned together as a *SkippedEntriesError when
+	// the operation completes.
 	ContinueOnError bool
 
+	// If set, OnError is called with each error encountered
+	// during reading or writing a file within an archive,
+	// along with the name of the file. If it returns nil, the
+	// file is skipped and the operation continues, and the
+	// error is included in the *SkippedEntriesError returned
+	// when the operation completes; otherwise the operation
+	// stops and returns OnError's error. OnError takes
+	// precedence over ContinueOnError. Context cancellation and
+	// exceeded Limits always stop the operation.
+	OnError func(entry string, err error) error
+
+	// The password for reading encrypted files, which may use
+	// either traditional PKWARE encryption (ZipCrypto) or WinZip
+	// AES encryption. If set when archiving or inserting, the
+	// contents of files are encrypted with WinZip AES-256.
+	Password string
+
 	// For files in zip archives that do not have UTF-8
 	// encoded filenames and comments, specify the character
 	// encoding here.
 	TextEncoding encoding.Encoding
+
+	// If true and TextEncoding is not set, the encoding of
+	// filenames and comments that are not UTF-8 is detected
+	// from all of them in the archive with DetectTextEncoding,
+	// and reported in the TextEncoding field of the FileInfos
+	// decoded with it. If the archive is streamed, it is
+	// detected from the files read so far.
+	DetectTextEncoding bool
+
+	// Limits on the resources that may be consumed while
+	// extracting, to defend against decompression bombs.
+	Limits Limits
 }
 
 func (Zip) Extension() string { return ".zip" }
 func (Zip) MediaType() string { return "application/zip" }
 
+func (z Zip) resourceLimits() Limits { return z.Limits }
+
 func (z Zip) Match(_ context.Context, filename string, stream io.Reader) (MatchResult, error) {
 	var mr MatchResult
 
@@ -101,103 +239,228 @@ func (z Zip) Match(_ context.Context, filename string, stream io.Reader) (MatchR
 		}
 	}
 
+	// zip archives may be preceded by other data, like the executable
+	// of a self-extracting archive, so look for one at the end
+	if sra, ok := stream.(seekReaderAt); ok && !mr.ByStream {
+		size, err := streamSizeBySeeking(sra)
+		if err != nil {
+			return mr, fmt.Errorf("determining stream size: %w", err)
+		}
+		_, err = findZipDirectory(sra, size)
+		mr.ByStream = err == nil
+	}
+
 	return mr, nil
 }
 
 func (z Zip) Archive(ctx context.Context, output io.Writer, files []FileInfo) error {
-	zw := zip.NewWriter(output)
+	zw := newZipWriter(output)
 	defer zw.Close()
+	enc := z.encrypter()
+	if enc != nil {
+		zw.RegisterCompressor(zipMethodWinZipAES, enc.compressor)
+	}
+
+	ctx, p := startProgress(ctx)
+	p.setTotalsFromFiles(files)
+
+	if z.writesRaw() {
+		var i int
+		return z.archiveConcurrently(ctx, zw, enc, func(ctx context.Context) (FileInfo, chan<- error, bool) {
+			if i == len(files) || ctx.Err() != nil {
+				return FileInfo{}, nil, false
+			}
+			i++
+			return files[i-1], nil, true
+		})
+	}
 
+	errs := z.entryErrorHandler()
 	for i, file := range files {
-		if err := z.archiveOneFile(ctx, zw, i, file); err != nil {
-			return err
+		p.start(file)
+		err := z.archiveOneFile(ctx, zw, enc, i, file)
+		p.finish(err)
+		if err != nil {
+			if err := errs.handle(ctx, file.NameInArchive, err); err != nil {
+				return err
+			}
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
 func (z Zip) ArchiveAsync(ctx context.Context, output io.Writer, jobs <-chan ArchiveAsyncJob) error {
-	zw := zip.NewWriter(output)
+	zw := newZipWriter(output)
 	defer zw.Close()
+	enc := z.encrypter()
+	if enc != nil {
+		zw.RegisterCompressor(zipMethodWinZipAES, enc.compressor)
+	}
 
+	ctx, p := startProgress(ctx)
+
+	if z.writesRaw() {
+		// jobs are received until the channel is closed, as below
+		return z.archiveConcurrently(ctx, zw, enc, func(context.Context) (FileInfo, chan<- error, bool) {
+			job, ok := <-jobs
+			return job.File, job.Result, ok
+		})
+	}
+
+	errs := z.entryErrorHandler()
+	var abortErr error
 	var i int
 	for job := range jobs {
-		job.Result <- z.archiveOneFile(ctx, zw, i, job.File)
+		if abortErr != nil {
+			// keep receiving jobs so senders don't block, but don't write them
+			job.Result <- abortErr
+			continue
+		}
+		p.start(job.File)
+		err := z.archiveOneFile(ctx, zw, enc, i, job.File)
+		p.finish(err)
+		job.Result <- err
+		if err != nil {
+			abortErr = errs.handle(ctx, job.File.NameInArchive, err)
+		}
 		i++
 	}
+	if abortErr != nil {
+		return abortErr
+	}
 
-	return nil
+	return errs.err()
+}
+
+func (z Zip) entryErrorHandler() *entryErrorHandler {
+	return &entryErrorHandler{continueOnError: z.ContinueOnError, onError: z.OnError}
+}
+
+// encrypter returns the encrypter for new entries, or nil if z has no password.
+func (z Zip) encrypter() *zipAESEncrypter {
+	if z.Password == "" {
+		return nil
+	}
+	return &zipAESEncrypter{password: z.Password}
 }
 
-func (z Zip) archiveOneFile(ctx context.Context, zw *zip.Writer, idx int, file FileInfo) error {
+func (z Zip) archiveOneFile(ctx context.Context, zw *zipWriter, enc *zipAESEncrypter, idx int, file FileInfo) error {
 	if err := ctx.Err(); err != nil {
 		return err // honor context cancellation
 	}
 
+	entry, err := z.newEntry(ctx, enc, idx, file)
+	if err != nil {
+		return err
+	}
+	defer entry.close()
+	if entry.enc == nil && entry.level != 0 {
+		// the writer has one compressor per method, which is only used
+		// when creating the header; nil restores the default
+		zw.RegisterCompressor(entry.hdr.Method, zipCompressorLevel(entry.hdr.Method, entry.level))
+		defer zw.RegisterCompressor(entry.hdr.Method, nil)
+	}
+
+	w, err := zw.CreateHeader(entry.hdr)
+	if err != nil {
+		return fmt.Errorf("creating header for file %d: %s: %w", idx, file.Name(), err)
+	}
+	return entry.writeContents(ctx, w)
+}
+
+// zipEntry is a file being added to a zip archive, with its header.
+type zipEntry struct {
+	idx   int
+	file  FileInfo
+	hdr   *zip.FileHeader
+	level int              // of the compression, or 0 for the default
+	enc   *zipAESEncrypter // if the file is encrypted
+	body  io.ReadCloser    // the file's contents, if already opened
+}
+
+// newEntry makes the header for adding file to an archive, encrypted with
+// enc if not nil. The entry must be closed.
+func (z Zip) newEntry(ctx context.Context, enc *zipAESEncrypter, idx int, file FileInfo) (*zipEntry, error) {
 	hdr, err := zip.FileInfoHeader(file)
 	if err != nil {
-		return fmt.Errorf("getting info for file %d: %s: %w", idx, file.Name(), err)
+		return nil, fmt.Errorf("getting info for file %d: %s: %w", idx, file.Name(), err)
 	}
 	hdr.Name = file.NameInArchive // complete path, since FileInfoHeader() only has base name
 	if hdr.Name == "" {
 		hdr.Name = file.Name() // assume base name of file I guess
 	}
 
+	c, body, err := z.compression(ctx, file, hdr.Name, ZipCompression{Method: z.Compression})
+	if err != nil {
+		return nil, fmt.Errorf("opening file %d: %s: %w", idx, file.Name(), err)
+	}
+	entry := &zipEntry{idx: idx, file: file, hdr: hdr, level: c.Level, body: body}
+
 	// customize header based on file properties
+	hdr.Method = c.Method
 	if file.IsDir() {
 		if !strings.HasSuffix(hdr.Name, "/") {
 			hdr.Name += "/" // required
 		}
 		hdr.Method = zip.Store
-	} else if z.SelectiveCompression {
-		// only enable compression on compressable files
-		ext := strings.ToLower(path.Ext(hdr.Name))
-		if _, ok := compressedFormats[ext]; ok {
-			hdr.Method = zip.Store
-		} else {
-			hdr.Method = z.Compression
-		}
-	} else {
-		hdr.Method = z.Compression
 	}
-
-	w, err := zw.CreateHeader(hdr)
-	if err != nil {
-		return fmt.Errorf("creating header for file %d: %s: %w", idx, file.Name(), err)
+	if hdr.Method == ZipMethodLzma {
+		hdr.Flags |= zipFlagLZMAEOS // the compressor writes an end marker
+	}
+	zipFileExtraFields(file).prepare(&hdr.Modified, &hdr.ModifiedDate, &hdr.ModifiedTime, &hdr.Extra)
+	if enc != nil && !file.IsDir() {
+		enc.prepare(c.Level, &hdr.Method, &hdr.Flags, &hdr.Extra)
+		entry.enc = enc
 	}
+	return entry, nil
+}
 
+// writeContents writes the contents of the entry's file to w.
+func (e *zipEntry) writeContents(ctx context.Context, w io.Writer) error {
 	// file won't be considered a symlink if FollowSymlinks in FilesFromDisk is true
-	if isSymlink(file) {
-		_, err := w.Write([]byte(file.LinkTarget))
+	if isSymlink(e.file) {
+		_, err := w.Write([]byte(e.file.LinkTarget))
 		if err != nil {
-			return fmt.Errorf("writing link target for file %d: %s: %w", idx, file.Name(), err)
+			return fmt.Errorf("writing link target for file %d: %s: %w", e.idx, e.file.Name(), err)
 		}
 		return nil
 	}
 
 	// directories have no file body
-	if file.IsDir() {
+	if e.file.IsDir() {
 		return nil
 	}
 
-	if err := openAndCopyFile(file, w); err != nil {
-		return fmt.Errorf("writing file %d: %s: %w", idx, file.Name(), err)
+	var err error
+	if e.body != nil {
+		_, err = io.Copy(w, e.body)
+	} else {
+		err = openAndCopyFile(ctx, e.file, w)
+	}
+	if err != nil {
+		return fmt.Errorf("writing file %d: %s: %w", e.idx, e.file.Name(), err)
 	}
-
 	return nil
 }
 
+func (e *zipEntry) close() {
+	if e.body != nil {
+		e.body.Close()
+	}
+}
+
 // Extract extracts files from z, implementing the Extractor interface. Uniquely, however,
-// sourceArchive must be an io.ReaderAt and io.Seeker, which are oddly disjoint interfaces
+// Zip archives are best read through their central directory, which requires
+// sourceArchive to be an io.ReaderAt and io.Seeker, which are oddly disjoint interfaces
 // from io.Reader which is what the method signature requires. We chose this signature for
 // the interface because we figure you can Read() from anything you can ReadAt() or Seek()
-// with. Due to the nature of the zip archive format, if sourceArchive is not an io.Seeker
-// and io.ReaderAt, an error is returned.
+// with. If sourceArchive is not an io.Seeker and io.ReaderAt, it is read sequentially
+// with ExtractStream instead, which knows less about each file.
 func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
 	sra, ok := sourceArchive.(seekReaderAt)
 	if !ok {
-		return fmt.Errorf("input type must be an io.ReaderAt and io.Seeker because of zip format constraints")
+		return z.ExtractStream(ctx, sourceArchive, handleFile)
 	}
 
 	size, err := streamSizeBySeeking(sra)
@@ -207,8 +470,18 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 
 	zr, err := zip.NewReader(sra, size)
 	if err != nil {
-		return err
+		return classifyError("", err)
+	}
+	if prefix, err := z.prefix(sra, size); err == nil {
+		ctx = withZipPrefix(ctx, prefix)
 	}
+	handleFile = z.Limits.wrapHandler(handleFile)
+	ctx, p := startProgress(ctx)
+	p.setTotals(len(zr.File), zipTotalSize(zr.File))
+	handleFile = p.wrapHandler(handleFile)
+	errs := z.entryErrorHandler()
+
+	textDec := z.textDecoder(zr.File)
 
 	// important to initialize to non-nil, empty value due to how fileIsIncluded works
 	skipDirs := skipList{}
@@ -219,31 +492,20 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		}
 
 		// ensure filename and comment are UTF-8 encoded (issue #147 and PR #305)
-		z.decodeText(&f.FileHeader)
+		textEnc := decodeZipText(textDec, &f.FileHeader)
 
 		if fileIsIncluded(skipDirs, f.Name) {
 			continue
 		}
 
-		info := f.FileInfo()
-		linkTarget, err := z.getLinkTarget(f)
+		file, err := z.fileInfo(ctx, f)
 		if err != nil {
-			return fmt.Errorf("getting link target for file %d: %s: %w", i, f.Name, err)
-		}
-
-		file := FileInfo{
-			FileInfo:      info,
-			Header:        f.FileHeader,
-			NameInArchive: f.Name,
-			LinkTarget:    linkTarget,
-			Open: func() (fs.File, error) {
-				openedFile, err := f.Open()
-				if err != nil {
-					return nil, err
-				}
-				return fileInArchive{openedFile, info}, nil
-			},
+			if err := errs.handle(ctx, f.Name, err); err != nil {
+				return fmt.Errorf("getting link target for file %d: %s: %w", i, f.Name, err)
+			}
+			continue
 		}
+		file.TextEncoding = textEnc
 
 		err = handleFile(ctx, file)
 		if errors.Is(err, fs.SkipAll) {
@@ -251,34 +513,158 @@ func (z Zip) Extract(ctx context.Context, sourceArchive io.Reader, handleFile Fi
 		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
 			skipDirs.add(f.Name)
 		} else if err != nil {
-			if z.ContinueOnError {
-				log.Printf("[ERROR] %s: %v", f.Name, err)
-				continue
+			if err := errs.handle(ctx, f.Name, err); err != nil {
+				return fmt.Errorf("handling file %d: %s: %w", i, f.Name, err)
 			}
-			return fmt.Errorf("handling file %d: %s: %w", i, f.Name, err)
 		}
 	}
 
-	return nil
+	return errs.err()
 }
 
-// decodeText decodes the name and comment fields from hdr into UTF-8.
-// It is a no-op if the text is already UTF-8 encoded or if z.TextEncoding
-// is not specified.
-func (z Zip) decodeText(hdr *zip.FileHeader) {
-	if hdr.NonUTF8 && z.TextEncoding != nil {
-		dec := z.TextEncoding.NewDecoder()
-		filename, err := dec.String(hdr.Name)
-		if err == nil {
-			hdr.Name = filename
-		}
-		if hdr.Comment != "" {
-			comment, err := dec.String(hdr.Comment)
-			if err == nil {
-				hdr.Comment = comment
+// fileInfo returns a FileInfo for the zip entry f.
+// Reads from the opened file honor ctx cancellation.
+func (z Zip) fileInfo(ctx context.Context, f *zip.File) (FileInfo, error) {
+	info := f.FileInfo()
+	linkTarget, err := z.getLinkTarget(f)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	file := FileInfo{
+		FileInfo:      info,
+		Header:        f.FileHeader,
+		NameInArchive: f.Name,
+		LinkTarget:    linkTarget,
+		Encrypted:     f.Flags&zipFlagEncrypted != 0,
+		Open: func() (fs.File, error) {
+			openedFile, err := openZipFile(f, z.Password)
+			if err != nil {
+				return nil, err
 			}
+			return fileInArchive{openedFile, info, ctx}, nil
+		},
+	}
+	parseZipExtraFields(f.Extra).fill(&file)
+	return file, nil
+}
+
+// openZipFile opens the zip entry f for reading, decrypting it with
+// password if it is encrypted. Errors from opening and reading the
+// entry are classified.
+func openZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	var err error
+	switch {
+	case f.Flags&zipFlagEncrypted != 0:
+		rc, err = openEncryptedZipFile(f, password)
+	case f.Method == ZipMethodLzma, f.Method == ZipMethodPPMd:
+		var raw io.Reader
+		if raw, err = f.OpenRaw(); err == nil {
+			decompress := zipEntryDecompressor(f.Method, int64(f.UncompressedSize64))
+			rc = newZipChecksumReader(decompress(raw), f.CRC32)
+		}
+	default:
+		rc, err = f.Open()
+	}
+	if err != nil {
+		return nil, classifyError(f.Name, err)
+	}
+	return newClassifyingReader(rc, f.Name), nil
+}
+
+// zipTotalSize returns the combined uncompressed size of the regular files.
+func zipTotalSize(files []*zip.File) int64 {
+	var size int64
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	return size
+}
+
+// OpenArchiveReader returns a reader that iterates the entries of the zip archive.
+// Like Extract, it reads sourceArchive sequentially, as ExtractStream does, if it
+// is not an io.ReaderAt and io.Seeker.
+func (z Zip) OpenArchiveReader(ctx context.Context, sourceArchive io.Reader) (ArchiveReader, error) {
+	sra, ok := sourceArchive.(seekReaderAt)
+	if !ok {
+		return &zipStreamArchiveReader{
+			ctx:     ctx,
+			z:       z,
+			sr:      newZipStreamReader(sourceArchive),
+			textDec: newTextDecoder(z.TextEncoding, z.DetectTextEncoding),
+			limits:  z.Limits.newState(),
+		}, nil
+	}
+
+	size, err := streamSizeBySeeking(sra)
+	if err != nil {
+		return nil, fmt.Errorf("determining stream size: %w", err)
+	}
+
+	zr, err := zip.NewReader(sra, size)
+	if err != nil {
+		return nil, classifyError("", err)
+	}
+
+	return &zipArchiveReader{ctx: ctx, z: z, files: zr.File, textDec: z.textDecoder(zr.File), limits: z.Limits.newState()}, nil
+}
+
+// zipArchiveReader implements ArchiveReader for zip archives.
+type zipArchiveReader struct {
+	ctx     context.Context
+	z       Zip
+	files   []*zip.File
+	textDec *textDecoder
+	limits  *limitState
+}
+
+func (r *zipArchiveReader) Next() (FileInfo, error) {
+	if err := r.ctx.Err(); err != nil {
+		return FileInfo{}, err // honor context cancellation
+	}
+	if len(r.files) == 0 {
+		return FileInfo{}, io.EOF
+	}
+	f := r.files[0]
+	r.files = r.files[1:]
+
+	textEnc := decodeZipText(r.textDec, &f.FileHeader)
+
+	file, err := r.z.fileInfo(r.ctx, f)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("getting link target for file %s: %w", f.Name, err)
+	}
+	file.TextEncoding = textEnc
+	return r.limits.entry(file)
+}
+
+func (*zipArchiveReader) Close() error { return nil }
+
+// textDecoder returns a textDecoder for the names and comments of
+// files, which has seen all of them that are not UTF-8.
+func (z Zip) textDecoder(files []*zip.File) *textDecoder {
+	dec := newTextDecoder(z.TextEncoding, z.DetectTextEncoding)
+	for _, f := range files {
+		if f.NonUTF8 {
+			dec.see(f.Name, f.Comment)
 		}
 	}
+	return dec
+}
+
+// decodeZipText decodes the name and comment fields from hdr into UTF-8
+// with dec, and returns the encoding they were decoded from. It is a no-op
+// that returns nil if the text is already UTF-8 encoded or if dec has no
+// encoding.
+func decodeZipText(dec *textDecoder, hdr *zip.FileHeader) encoding.Encoding {
+	if !hdr.NonUTF8 || dec.enc == nil {
+		return nil
+	}
+	hdr.Name = dec.decode(hdr.Name)
+	hdr.Comment = dec.decode(hdr.Comment)
+	return dec.enc
 }
 
 func (z Zip) getLinkTarget(f *zip.File) (string, error) {
@@ -289,7 +675,7 @@ func (z Zip) getLinkTarget(f *zip.File) (string, error) {
 	}
 
 	// Open the file and read the link target
-	file, err := f.Open()
+	file, err := openZipFile(f, z.Password)
 	if err != nil {
 		return "", err
 	}
@@ -318,52 +704,81 @@ func (z Zip) Insert(ctx context.Context, into io.ReadWriteSeeker, files []FileIn
 	}
 	defer zu.Close()
 
+	ctx, p := startProgress(ctx)
+	p.setTotalsFromFiles(files)
+
+	enc := z.encrypter()
+	errs := z.entryErrorHandler()
 	for idx, file := range files {
 		if err := ctx.Err(); err != nil {
 			return err // honor context cancellation
 		}
-
-		hdr, err := szip.FileInfoHeader(file)
+		p.start(file)
+		err := z.insertOneFile(ctx, zu, enc, idx, file)
+		p.finish(err)
 		if err != nil {
-			return fmt.Errorf("getting info for file %d: %s: %w", idx, file.NameInArchive, err)
-		}
-		hdr.Name = file.NameInArchive // complete path, since FileInfoHeader() only has base name
-		if hdr.Name == "" {
-			hdr.Name = file.Name() // assume base name of file I guess
-		}
-
-		// customize header based on file properties
-		if file.IsDir() {
-			if !strings.HasSuffix(hdr.Name, "/") {
-				hdr.Name += "/" // required
-			}
-			hdr.Method = zip.Store
-		} else if z.SelectiveCompression {
-			// only enable compression on compressable files
-			ext := strings.ToLower(path.Ext(hdr.Name))
-			if _, ok := compressedFormats[ext]; ok {
-				hdr.Method = zip.Store
-			} else {
-				hdr.Method = z.Compression
+			if err := errs.handle(ctx, file.NameInArchive, err); err != nil {
+				return err
 			}
 		}
+	}
 
-		w, err := zu.Append(hdr.Name, szip.APPEND_MODE_OVERWRITE)
-		if err != nil {
-			return fmt.Errorf("inserting file header: %d: %s: %w", idx, file.Name(), err)
-		}
+	return errs.err()
+}
 
-		// directories have no file body
-		if file.IsDir() {
-			return nil
-		}
-		if err := openAndCopyFile(file, w); err != nil {
-			if z.ContinueOnError && ctx.Err() == nil {
-				log.Printf("[ERROR] appending file %d into archive: %s: %v", idx, file.Name(), err)
-				continue
-			}
-			return fmt.Errorf("copying inserted file %d: %s: %w", idx, file.Name(), err)
+func (z Zip) insertOneFile(ctx context.Context, zu *szip.Updater, enc *zipAESEncrypter, idx int, file FileInfo) error {
+	hdr, err := szip.FileInfoHeader(file)
+	if err != nil {
+		return fmt.Errorf("getting info for file %d: %s: %w", idx, file.NameInArchive, err)
+	}
+	hdr.Name = file.NameInArchive // complete path, since FileInfoHeader() only has base name
+	if hdr.Name == "" {
+		hdr.Name = file.Name() // assume base name of file I guess
+	}
+
+	// Deflate by default, as Append does
+	c, body, err := z.compression(ctx, file, hdr.Name, ZipCompression{Method: zip.Deflate})
+	if err != nil {
+		return fmt.Errorf("opening inserted file %d: %s: %w", idx, file.Name(), err)
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	// customize header based on file properties
+	hdr.Method = c.Method
+	if file.IsDir() {
+		if !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/" // required
 		}
+		hdr.Method = zip.Store
+	}
+	if hdr.Method == ZipMethodLzma {
+		hdr.Flags |= zipFlagLZMAEOS
+	}
+	zipFileExtraFields(file).prepare(&hdr.Modified, &hdr.ModifiedDate, &hdr.ModifiedTime, &hdr.Extra)
+	comp := zipCompressorLevel(hdr.Method, c.Level)
+	if enc != nil && !file.IsDir() {
+		enc.prepare(c.Level, &hdr.Method, &hdr.Flags, &hdr.Extra)
+		comp = enc.compressor
+	}
+
+	w, err := appendZipEntry(zu, hdr, szip.APPEND_MODE_OVERWRITE, comp)
+	if err != nil {
+		return fmt.Errorf("inserting file header: %d: %s: %w", idx, file.Name(), err)
+	}
+
+	// directories have no file body
+	if file.IsDir() {
+		return nil
+	}
+	if body != nil {
+		_, err = io.Copy(w, body)
+	} else {
+		err = openAndCopyFile(ctx, file, w)
+	}
+	if err != nil {
+		return fmt.Errorf("copying inserted file %d: %s: %w", idx, file.Name(), err)
 	}
 
 	return nil
@@ -377,11 +792,12 @@ type seekReaderAt interface {
 // Additional compression methods not offered by archive/zip.
 // See https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT section 4.4.5.
 const (
-	ZipMethodBzip2 = 12
-	// TODO: LZMA: Disabled - because 7z isn't able to unpack ZIP+LZMA ZIP+LZMA2 archives made this way - and vice versa.
-	// ZipMethodLzma     = 14
-	ZipMethodZstd = 93
-	ZipMethodXz   = 95
+	ZipMethodDeflate64 = 9 // decompression only
+	ZipMethodBzip2     = 12
+	ZipMethodLzma      = 14
+	ZipMethodZstd      = 93
+	ZipMethodXz        = 95
+	ZipMethodPPMd      = 98 // decompression only
 )
 
 // compressedFormats is a (non-exhaustive) set of lowercased
@@ -430,7 +846,8 @@ var zipHeaders = [][]byte{
 
 // Interface guards
 var (
-	_ Archiver      = Zip{}
-	_ ArchiverAsync = Zip{}
-	_ Extractor     = Zip{}
+	_ Archiver            = Zip{}
+	_ ArchiverAsync       = Zip{}
+	_ Extractor           = Zip{}
+	_ ArchiveReaderOpener = Zip{}
 )
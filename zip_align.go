@@ -0,0 +1,116 @@
+package archives
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// The contents of stored files can be mapped into memory straight from a zip
+// archive if they start on a suitable boundary, which Android requires of the
+// files in its packages, as zipalign arranges. The contents of a file follow
+// its local header, so they are aligned by padding the header's extra fields
+// with a field like the one Android's tools add, which records the alignment.
+
+// MisalignedZipEntry is a stored file in a zip archive whose contents
+// do not start on the boundary they should.
+type MisalignedZipEntry struct {
+	// The name of the file in the archive.
+	Name string
+
+	// The position of the file's contents in the archive.
+	Offset int64
+
+	// The boundary the contents should start on.
+	Alignment int
+}
+
+// VerifyAlignment returns the stored files in the zip archive sourceArchive,
+// which is size bytes long, whose contents do not start on the boundary given
+// by z.Alignment and z.AlignSharedLibraries, in the order they are listed in
+// the archive. Positions are from the start of sourceArchive, including any
+// data that precedes the archive.
+func (z Zip) VerifyAlignment(sourceArchive io.ReaderAt, size int64) ([]MisalignedZipEntry, error) {
+	zr, err := zip.NewReader(sourceArchive, size)
+	if err != nil {
+		return nil, classifyError("", err)
+	}
+	var misaligned []MisalignedZipEntry
+	for _, f := range zr.File {
+		alignment := z.alignment(&f.FileHeader)
+		if alignment <= 1 {
+			continue
+		}
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("locating contents of %s: %w", f.Name, err)
+		}
+		if offset%int64(alignment) != 0 {
+			misaligned = append(misaligned, MisalignedZipEntry{Name: f.Name, Offset: offset, Alignment: alignment})
+		}
+	}
+	return misaligned, nil
+}
+
+// alignment returns the boundary that the contents of the entry hdr should
+// start on, which is 0 if they need not be aligned.
+func (z Zip) alignment(hdr *zip.FileHeader) int {
+	if hdr.Method != zip.Store || strings.HasSuffix(hdr.Name, "/") {
+		return 0
+	}
+	if z.AlignSharedLibraries && path.Ext(hdr.Name) == ".so" {
+		return zipPageAlignment
+	}
+	return z.Alignment
+}
+
+// zipWriter is a zip.Writer that knows where in its output
+// the next entry starts, so that its contents can be aligned.
+type zipWriter struct {
+	*zip.Writer
+	output *countingWriter
+
+	// the length of the data descriptor of the last entry written
+	// raw, which the writer writes once the next entry is added
+	descriptorLen int64
+}
+
+func newZipWriter(output io.Writer) *zipWriter {
+	cw := &countingWriter{Writer: output}
+	return &zipWriter{Writer: zip.NewWriter(cw), output: cw}
+}
+
+// align pads the extra fields of the entry hdr, which is about to be
+// written raw to zw, so that its contents start on the boundary they should.
+func (z Zip) align(zw *zipWriter, hdr *zip.FileHeader) error {
+	alignment := z.alignment(hdr)
+	if alignment <= 1 {
+		return nil
+	}
+	if alignment > math.MaxUint16 {
+		return fmt.Errorf("alignment %d is greater than the maximum of %d", alignment, math.MaxUint16)
+	}
+	// the writer buffers its output, so its position is known once flushed
+	if err := zw.Flush(); err != nil {
+		return err
+	}
+	start := zw.output.n.Load() + zw.descriptorLen + zipLocalHeaderLen + int64(len(hdr.Name)) + int64(len(hdr.Extra)) + zipAlignmentFieldLen
+	field := binary.LittleEndian.AppendUint16(nil, uint16(alignment))
+	field = append(field, make([]byte, (int64(alignment)-start%int64(alignment))%int64(alignment))...)
+	hdr.Extra = appendZipExtraField(hdr.Extra, zipExtraAlignment, field)
+	return nil
+}
+
+const (
+	// the extra field that Android's tools pad entries with,
+	// which records the alignment followed by the padding
+	zipExtraAlignment    = 0xd935
+	zipAlignmentFieldLen = 6 // the field without padding
+
+	zipPageAlignment = 4096
+)
@@ -0,0 +1,95 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"path"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipAlignment(t *testing.T) {
+	ctx := context.Background()
+
+	random := make([]byte, 10<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	files := []FileInfo{
+		memFile("AndroidManifest.xml", bytes.Repeat([]byte("<manifest/>\n"), 100)),
+		memFile("res/raw/a.bin", random[:1]),
+		memFile("res/raw/bc.bin", random[:3]),
+		memFile("lib/arm64-v8a/libnative.so", random),
+		memFile("assets/d.bin", random[:5]),
+	}
+	// files that are random are stored, and shared libraries are stored as
+	// Android requires, so that they can be loaded straight from the package
+	policy := func(name string, size int64, sample []byte) ZipCompression {
+		if path.Ext(name) == ".so" {
+			return ZipCompression{Method: zip.Store}
+		}
+		return SelectiveZipCompression(ZipCompression{Method: zip.Deflate})(name, size, sample)
+	}
+
+	unaligned := new(bytes.Buffer)
+	if err := (Zip{CompressionPolicy: policy}).Archive(ctx, unaligned, files); err != nil {
+		t.Fatal(err)
+	}
+	aligned := Zip{CompressionPolicy: policy, Alignment: 4, AlignSharedLibraries: true}
+	misaligned, err := aligned.VerifyAlignment(bytes.NewReader(unaligned.Bytes()), int64(unaligned.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(misaligned) == 0 {
+		t.Error("expected files of the archive that wasn't aligned to be misaligned")
+	}
+
+	for _, concurrency := range []int{0, 4} {
+		aligned.Concurrency = concurrency
+		buf := new(bytes.Buffer)
+		if err := aligned.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		misaligned, err := aligned.VerifyAlignment(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(misaligned) != 0 {
+			t.Errorf("concurrency %d: expected no misaligned files, got %+v", concurrency, misaligned)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range zr.File {
+			offset, err := f.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			alignment := int64(4)
+			if path.Ext(f.Name) == ".so" {
+				alignment = 4096
+			}
+			if f.Method == zip.Store && offset%alignment != 0 {
+				t.Errorf("concurrency %d: %s: contents at %d are not aligned to %d", concurrency, f.Name, offset, alignment)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := new(bytes.Buffer)
+			if _, err := got.ReadFrom(rc); err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+			rc.Close()
+			if want, _ := files[i].Open(); want != nil {
+				wantBuf := new(bytes.Buffer)
+				wantBuf.ReadFrom(want)
+				if !bytes.Equal(got.Bytes(), wantBuf.Bytes()) {
+					t.Errorf("%s: contents do not match", f.Name)
+				}
+			}
+		}
+	}
+}
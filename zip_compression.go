@@ -0,0 +1,211 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"path"
+	"strings"
+	"sync"
+
+	szip "github.com/STARRY-S/zip"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZipCompression is how a file is compressed in a zip archive.
+type ZipCompression struct {
+	// The compression method, such as zip.Store, zip.Deflate, or one
+	// of the ZipMethod constants.
+	Method uint16
+
+	// The compression level, or 0 for the method's default. It applies
+	// to Deflate (1-9), Bzip2 (1-9), and Zstandard (1-22), except that
+	// Insert compresses Deflate entries that aren't encrypted at the
+	// default level.
+	Level int
+}
+
+// ZipCompressionPolicy chooses how to compress a file added to a zip
+// archive, given its name in the archive, its size, and a sample from
+// the start of its contents, which is shorter than the file only if the
+// file is longer than 16 KiB. The sample of a symbolic link is its target.
+// Directories are always stored.
+type ZipCompressionPolicy func(name string, size int64, sample []byte) ZipCompression
+
+// SelectiveZipCompression returns a ZipCompressionPolicy that stores files
+// that are already compressed and compresses others with c. Files are taken
+// to be compressed if their extension is that of a compressed format, if their
+// contents start with the signature of one, or if the sample of their contents
+// is nearly random, as compressed and encrypted data are.
+func SelectiveZipCompression(c ZipCompression) ZipCompressionPolicy {
+	return func(name string, _ int64, sample []byte) ZipCompression {
+		if _, ok := compressedFormats[strings.ToLower(path.Ext(name))]; ok {
+			return ZipCompression{Method: zip.Store}
+		}
+		for _, sig := range compressedSignatures {
+			if len(sample) >= sig.offset+len(sig.magic) && bytes.Equal(sample[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+				return ZipCompression{Method: zip.Store}
+			}
+		}
+		// smaller samples of random data are too uneven to tell
+		if len(sample) >= 1024 && byteEntropy(sample) > 7.5 {
+			return ZipCompression{Method: zip.Store}
+		}
+		return c
+	}
+}
+
+// compressedSignatures are the signatures that files in compressed
+// formats start with, at the given offset.
+var compressedSignatures = []struct {
+	offset int
+	magic  []byte
+}{
+	{0, gzHeader},
+	{0, bzip2Header},
+	{0, xzHeader},
+	{0, zstdHeader},
+	{0, lz4Header},
+	{0, lzipHeader},
+	{0, mzHeader},
+	{0, snappyHeader},
+	{0, sevenZipHeader},
+	{0, rarHeaderV1_5[:6]}, // the same in v5.0
+	{0, zipHeaders[0]},
+	{0, []byte("\x89PNG\r\n\x1a\n")},
+	{0, []byte("\xff\xd8\xff")}, // JPEG
+	{0, []byte("GIF8")},
+	{8, []byte("WEBP")},             // in a RIFF container
+	{4, []byte("ftyp")},             // MP4, QuickTime, HEIF, and their relatives
+	{0, []byte("\x1a\x45\xdf\xa3")}, // Matroska and WebM
+	{0, []byte("ID3")},              // MP3
+	{0, []byte("OggS")},
+	{0, []byte("fLaC")},
+	{0, []byte("wOFF")},
+	{0, []byte("wOF2")},
+}
+
+// byteEntropy returns the Shannon entropy of the bytes of b, in bits per
+// byte: 8 for random data, and much less for text and most other data that
+// is worth compressing.
+func byteEntropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var entropy float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(b))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// compressionPolicy returns the policy that chooses how to compress files,
+// or nil if they are all compressed the same.
+func (z Zip) compressionPolicy() ZipCompressionPolicy {
+	if z.CompressionPolicy != nil {
+		return z.CompressionPolicy
+	}
+	if z.SelectiveCompression {
+		return SelectiveZipCompression(ZipCompression{Method: z.Compression})
+	}
+	return nil
+}
+
+// compression returns how to compress file, which is named name in the
+// archive; it is def if there is no policy. The policy is given a sample of
+// the contents of regular files, so they are opened to read it, and body then
+// reads all of their contents, honoring ctx; it must be closed if not nil.
+func (z Zip) compression(ctx context.Context, file FileInfo, name string, def ZipCompression) (c ZipCompression, body io.ReadCloser, err error) {
+	policy := z.compressionPolicy()
+	if policy == nil || file.IsDir() {
+		return def, nil, nil
+	}
+	if isSymlink(file) {
+		return policy(name, int64(len(file.LinkTarget)), []byte(file.LinkTarget)), nil, nil
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return ZipCompression{}, nil, err
+	}
+	rc := contextReadCloser{ctx, progressFrom(ctx).observeFile(f)}
+	sample := make([]byte, zipSampleSize)
+	n, err := io.ReadFull(rc, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return ZipCompression{}, nil, err
+	}
+	sample = sample[:n]
+	body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(sample), rc), rc}
+	return policy(name, file.Size(), sample), body, nil
+}
+
+// zipCompressorLevel returns the compressor for method at level, or
+// nil if there is none. Level 0, and levels of methods that have none,
+// are the default.
+func zipCompressorLevel(method uint16, level int) zip.Compressor {
+	if level == 0 {
+		return zipCompressor(method)
+	}
+	switch method {
+	case zip.Deflate:
+		return func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, level) }
+	case ZipMethodBzip2:
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		}
+	case ZipMethodZstd:
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+	}
+	return zipCompressor(method)
+}
+
+// szip, which Insert uses, has no way to set a compressor for one archive,
+// so compressors for AES and the methods it lacks are registered with it
+// globally, and given the compressor of the entry being inserted while the
+// entry's header is being appended, which is when szip calls the compressor.
+var szipEntryCompressor struct {
+	sync.Mutex
+	current zip.Compressor
+}
+
+func init() {
+	methods := []uint16{zipMethodWinZipAES}
+	for method := range zipCompressors {
+		methods = append(methods, method)
+	}
+	for _, method := range methods {
+		szip.RegisterCompressor(method, func(w io.Writer) (io.WriteCloser, error) {
+			if szipEntryCompressor.current == nil {
+				return nil, szip.ErrAlgorithm
+			}
+			return szipEntryCompressor.current(w)
+		})
+	}
+}
+
+// appendZipEntry appends the entry hdr to zu, compressed with comp
+// unless szip has its own compressor for the entry's method.
+func appendZipEntry(zu *szip.Updater, hdr *szip.FileHeader, mode szip.AppendMode, comp zip.Compressor) (io.Writer, error) {
+	szipEntryCompressor.Lock()
+	defer szipEntryCompressor.Unlock()
+	szipEntryCompressor.current = comp
+	defer func() { szipEntryCompressor.current = nil }()
+	return zu.AppendHeader(hdr, mode)
+}
+
+// zipSampleSize is the most a ZipCompressionPolicy is given of a file.
+const zipSampleSize = 16 << 10
@@ -0,0 +1,164 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestSelectiveZipCompression(t *testing.T) {
+	random := make([]byte, zipSampleSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	text := []byte(strings.Repeat("all work and no play makes jack a dull boy\n", 400))
+	jpeg := append([]byte("\xff\xd8\xff\xe0\x00\x10JFIF"), text...)
+
+	policy := SelectiveZipCompression(ZipCompression{Method: zip.Deflate, Level: 9})
+	for _, tc := range []struct {
+		name   string
+		sample []byte
+		store  bool
+	}{
+		{name: "notes.txt", sample: text},
+		{name: "archive.ZIP", sample: text, store: true},
+		{name: "IMG_0001", sample: jpeg, store: true},
+		{name: "movie.bin", sample: append([]byte("\x00\x00\x00\x20ftypisom"), text...), store: true},
+		{name: "data", sample: random, store: true},
+		{name: "short", sample: random[:100]}, // too short to tell
+		{name: "empty", sample: nil},
+	} {
+		want := ZipCompression{Method: zip.Deflate, Level: 9}
+		if tc.store {
+			want = ZipCompression{Method: zip.Store}
+		}
+		if got := policy(tc.name, int64(len(tc.sample)), tc.sample); got != want {
+			t.Errorf("%s: expected %+v, got %+v", tc.name, want, got)
+		}
+	}
+}
+
+func TestZipCompressionPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	random := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	contents := map[string][]byte{
+		"readme.txt": bytes.Repeat([]byte("read me\n"), 5000),
+		"table.csv":  bytes.Repeat([]byte("1,2,3\n"), 5000),
+		"random":     random,
+	}
+	files := []FileInfo{memFile("readme.txt", contents["readme.txt"]), memFile("table.csv", contents["table.csv"]), memFile("random", random)}
+
+	// text is compressed with Zstandard at its best level, and the rest
+	// with bzip2, unless it's already compressed
+	var sizes = make(map[string]int64)
+	policy := func(name string, size int64, sample []byte) ZipCompression {
+		sizes[name] = size
+		if strings.HasSuffix(name, ".txt") {
+			return ZipCompression{Method: ZipMethodZstd, Level: 22}
+		}
+		return SelectiveZipCompression(ZipCompression{Method: ZipMethodBzip2, Level: 1})(name, size, sample)
+	}
+	wantMethods := map[string]uint16{"readme.txt": ZipMethodZstd, "table.csv": ZipMethodBzip2, "random": zip.Store}
+
+	// check reads the archive, and checks the methods of its entries unless encrypted
+	check := func(t *testing.T, format Zip, archive []byte) {
+		t.Helper()
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != len(contents) {
+			t.Errorf("expected %d files, got %d", len(contents), len(zr.File))
+		}
+		for _, f := range zr.File {
+			if format.Password == "" && f.Method != wantMethods[f.Name] {
+				t.Errorf("%s: expected method %d, got %d", f.Name, wantMethods[f.Name], f.Method)
+			}
+		}
+		err = format.Extract(ctx, bytes.NewReader(archive), func(_ context.Context, f FileInfo) error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(got, contents[f.NameInArchive]) {
+				t.Errorf("%s: extracted contents do not match", f.NameInArchive)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []Zip{
+		{CompressionPolicy: policy},
+		{CompressionPolicy: policy, Password: "hunter2"},
+	} {
+		name := "plain"
+		if format.Password != "" {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			t.Run("Archive", func(t *testing.T) {
+				buf := new(bytes.Buffer)
+				if err := format.Archive(ctx, buf, files); err != nil {
+					t.Fatal(err)
+				}
+				check(t, format, buf.Bytes())
+				if sizes["random"] != int64(len(random)) {
+					t.Errorf("expected the policy to be given the size %d, got %d", len(random), sizes["random"])
+				}
+			})
+
+			t.Run("ArchiveAsync", func(t *testing.T) {
+				buf := new(bytes.Buffer)
+				jobs := make(chan ArchiveAsyncJob)
+				go func() {
+					for _, file := range files {
+						result := make(chan error, 1)
+						jobs <- ArchiveAsyncJob{File: file, Result: result}
+						if err := <-result; err != nil {
+							t.Error(err)
+						}
+					}
+					close(jobs)
+				}()
+				if err := format.ArchiveAsync(ctx, buf, jobs); err != nil {
+					t.Fatal(err)
+				}
+				check(t, format, buf.Bytes())
+			})
+
+			t.Run("Insert", func(t *testing.T) {
+				f, err := os.Create(filepath.Join(t.TempDir(), "insert.zip"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if err := format.Archive(ctx, f, files[:1]); err != nil {
+					t.Fatal(err)
+				}
+				if err := format.Insert(ctx, f, files[1:]); err != nil {
+					t.Fatal(err)
+				}
+				archive, err := os.ReadFile(f.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+				check(t, format, archive)
+			})
+		})
+	}
+}
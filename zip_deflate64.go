@@ -0,0 +1,421 @@
+package archives
+
+import (
+	"bufio"
+	"compress/flate"
+	"io"
+)
+
+// Zip compression method 9 is Deflate64, also called Enhanced Deflate,
+// which Windows uses for large files. It is Deflate with a 64 KiB window:
+// length code 285 has 16 extra bits and a base of 3 instead of meaning
+// 258, and distance codes 30 and 31 reach back up to 65536 bytes.
+
+// newDeflate64Reader returns a reader that decompresses the
+// Deflate64-compressed data in r. If r is an io.ByteReader, it
+// reads no further than the end of the compressed data.
+func newDeflate64Reader(r io.Reader) io.ReadCloser {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &deflate64Reader{r: br}
+}
+
+type deflate64Reader struct {
+	r      io.ByteReader
+	offset int64 // of the input, for errors
+	bits   uint64
+	nbits  uint
+	err    error
+
+	window  [deflate64WindowSize]byte
+	written int64 // total output, to validate distances
+
+	final    bool // the current block is the last one
+	inBlock  bool
+	stored   int // bytes left in a stored block
+	lit, dst *deflate64Huffman
+
+	copyLen, copyDist int // of the match being copied
+}
+
+func (d *deflate64Reader) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) && d.err == nil {
+		switch {
+		case d.copyLen > 0:
+			for ; d.copyLen > 0 && n < len(p); d.copyLen-- {
+				p[n] = d.emit(d.window[(d.written-int64(d.copyDist))&deflate64WindowMask])
+				n++
+			}
+		case !d.inBlock:
+			if d.final {
+				d.err = io.EOF
+				break
+			}
+			d.err = d.readBlockHeader()
+		case d.lit == nil:
+			// whole bytes may be left in the bit buffer, so read from there
+			var b int
+			if b, d.err = d.readBits(8); d.err != nil {
+				break
+			}
+			p[n] = d.emit(byte(b))
+			n++
+			d.stored--
+			d.inBlock = d.stored > 0
+		default:
+			var b byte
+			var ok bool
+			if b, ok, d.err = d.decodeSymbol(); ok {
+				p[n] = b
+				n++
+			}
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, d.err
+}
+
+func (d *deflate64Reader) Close() error { return nil }
+
+// emit adds b to the window and returns it.
+func (d *deflate64Reader) emit(b byte) byte {
+	d.window[d.written&deflate64WindowMask] = b
+	d.written++
+	return b
+}
+
+// decodeSymbol decodes the next symbol of a Huffman block. It returns
+// a literal byte, if the symbol was one, or starts a match or ends the
+// block.
+func (d *deflate64Reader) decodeSymbol() (byte, bool, error) {
+	sym, err := d.decodeHuffman(d.lit)
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case sym < 256:
+		return d.emit(byte(sym)), true, nil
+	case sym == 256:
+		d.inBlock = false
+		return 0, false, nil
+	case sym > 285:
+		return 0, false, d.corrupt()
+	}
+
+	sym -= 257
+	extra, err := d.readBits(deflate64LengthExtra[sym])
+	if err != nil {
+		return 0, false, err
+	}
+	length := int(deflate64LengthBase[sym]) + extra
+
+	sym, err = d.decodeHuffman(d.dst)
+	if err != nil {
+		return 0, false, err
+	}
+	if sym >= len(deflate64DistBase) {
+		return 0, false, d.corrupt()
+	}
+	extra, err = d.readBits(deflate64DistExtra[sym])
+	if err != nil {
+		return 0, false, err
+	}
+	dist := int(deflate64DistBase[sym]) + extra
+	if int64(dist) > d.written {
+		return 0, false, d.corrupt()
+	}
+	d.copyLen, d.copyDist = length, dist
+	return 0, false, nil
+}
+
+func (d *deflate64Reader) readBlockHeader() error {
+	header, err := d.readBits(3)
+	if err != nil {
+		return err
+	}
+	d.final = header&1 == 1
+	switch header >> 1 {
+	case 0:
+		// stored blocks start at a byte boundary
+		d.bits >>= d.nbits % 8
+		d.nbits -= d.nbits % 8
+		lengths, err := d.readBits(32)
+		if err != nil {
+			return err
+		}
+		length, nlength := lengths&0xffff, lengths>>16
+		if length != ^nlength&0xffff {
+			return d.corrupt()
+		}
+		d.lit, d.dst = nil, nil
+		d.stored = length
+		d.inBlock = length > 0
+	case 1:
+		d.lit, d.dst = deflate64FixedLit, deflate64FixedDist
+		d.inBlock = true
+	case 2:
+		if err := d.readDynamicTables(); err != nil {
+			return err
+		}
+		d.inBlock = true
+	default:
+		return d.corrupt()
+	}
+	return nil
+}
+
+// deflate64CodeOrder is the order of the code length code lengths.
+var deflate64CodeOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+func (d *deflate64Reader) readDynamicTables() error {
+	counts, err := d.readBits(14)
+	if err != nil {
+		return err
+	}
+	nlit, ndist, ncode := counts&0x1f+257, counts>>5&0x1f+1, counts>>10+4
+	if nlit > 286 {
+		return d.corrupt()
+	}
+
+	var lengths [286 + 32]uint8
+	for i := 0; i < ncode; i++ {
+		l, err := d.readBits(3)
+		if err != nil {
+			return err
+		}
+		lengths[deflate64CodeOrder[i]] = uint8(l)
+	}
+	codes, ok := newDeflate64Huffman(lengths[:19])
+	if !ok {
+		return d.corrupt()
+	}
+
+	for i := 0; i < nlit+ndist; {
+		sym, err := d.decodeHuffman(codes)
+		if err != nil {
+			return err
+		}
+		if sym < 16 {
+			lengths[i] = uint8(sym)
+			i++
+			continue
+		}
+		var repeat int
+		var length uint8
+		switch sym {
+		case 16:
+			if i == 0 {
+				return d.corrupt()
+			}
+			length = lengths[i-1]
+			repeat, err = d.readBits(2)
+			repeat += 3
+		case 17:
+			repeat, err = d.readBits(3)
+			repeat += 3
+		default:
+			repeat, err = d.readBits(7)
+			repeat += 11
+		}
+		if err != nil {
+			return err
+		}
+		if i+repeat > nlit+ndist {
+			return d.corrupt()
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = length
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		return d.corrupt() // no end of block code
+	}
+
+	if d.lit, ok = newDeflate64Huffman(lengths[:nlit]); !ok {
+		return d.corrupt()
+	}
+	if d.dst, ok = newDeflate64Huffman(lengths[nlit : nlit+ndist]); !ok {
+		return d.corrupt()
+	}
+	return nil
+}
+
+// fill reads input bytes until at least n bits are buffered or
+// the input ends.
+func (d *deflate64Reader) fill(n uint) error {
+	for d.nbits < n {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		d.offset++
+		d.bits |= uint64(b) << d.nbits
+		d.nbits += 8
+	}
+	return nil
+}
+
+func (d *deflate64Reader) readBits(n uint) (int, error) {
+	if err := d.fill(n); err != nil {
+		return 0, noEOF(err)
+	}
+	v := int(d.bits & (1<<n - 1))
+	d.bits >>= n
+	d.nbits -= n
+	return v, nil
+}
+
+func (d *deflate64Reader) decodeHuffman(h *deflate64Huffman) (int, error) {
+	// most codes are short enough to be looked up; bytes are read only as
+	// needed, so as not to read past the end of the compressed data
+	for {
+		if e := h.lookup[d.bits&(1<<deflate64LookupBits-1)]; e != 0 && uint(e&0xf) <= d.nbits {
+			d.bits >>= e & 0xf
+			d.nbits -= uint(e & 0xf)
+			return int(e >> 4), nil
+		}
+		if d.nbits >= deflate64LookupBits {
+			break
+		}
+		if err := d.fill(d.nbits + 1); err != nil {
+			return 0, noEOF(err)
+		}
+	}
+
+	// otherwise, decode one bit at a time
+	var code, first, index int
+	for length := 1; length <= deflate64MaxBits; length++ {
+		bit, err := d.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		code |= bit
+		count := int(h.count[length])
+		if code-first < count {
+			return int(h.symbols[index+code-first]), nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, d.corrupt()
+}
+
+func (d *deflate64Reader) corrupt() error {
+	return flate.CorruptInputError(d.offset)
+}
+
+// noEOF returns io.ErrUnexpectedEOF in place of io.EOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// deflate64Huffman is a canonical Huffman code.
+type deflate64Huffman struct {
+	count   [deflate64MaxBits + 1]uint16 // number of codes of each length
+	symbols []uint16                     // ordered by code
+	lookup  [1 << deflate64LookupBits]uint16
+}
+
+// newDeflate64Huffman builds the code with the given code lengths for
+// each symbol. It returns false if the lengths are oversubscribed.
+func newDeflate64Huffman(lengths []uint8) (*deflate64Huffman, bool) {
+	h := new(deflate64Huffman)
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+	left := 1
+	for l := 1; l <= deflate64MaxBits; l++ {
+		left = left<<1 - int(h.count[l])
+		if left < 0 {
+			return nil, false
+		}
+	}
+
+	var offsets [deflate64MaxBits + 2]int
+	for l := 1; l <= deflate64MaxBits; l++ {
+		offsets[l+1] = offsets[l] + int(h.count[l])
+	}
+	h.symbols = make([]uint16, offsets[deflate64MaxBits+1])
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbols[offsets[l]] = uint16(sym)
+			offsets[l]++
+		}
+	}
+
+	// fill the lookup table with the short codes, which are stored
+	// bit-reversed because they are read starting from the low bit
+	code, index := 0, 0
+	for l := 1; l <= deflate64LookupBits; l++ {
+		for i := 0; i < int(h.count[l]); i++ {
+			var rev int
+			for b := 0; b < l; b++ {
+				rev |= (code >> b & 1) << (l - 1 - b)
+			}
+			for j := rev; j < len(h.lookup); j += 1 << l {
+				h.lookup[j] = h.symbols[index]<<4 | uint16(l)
+			}
+			code++
+			index++
+		}
+		code <<= 1
+	}
+	return h, true
+}
+
+const (
+	deflate64WindowSize = 1 << 16
+	deflate64WindowMask = deflate64WindowSize - 1
+	deflate64MaxBits    = 15
+	deflate64LookupBits = 9
+)
+
+var (
+	deflate64LengthBase = [29]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 3}
+	deflate64LengthExtra = [29]uint{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 16}
+	deflate64DistBase = [32]uint32{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577, 32769, 49153}
+	deflate64DistExtra = [32]uint{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13, 14, 14}
+
+	deflate64FixedLit, deflate64FixedDist = deflate64FixedCodes()
+)
+
+func deflate64FixedCodes() (lit, dist *deflate64Huffman) {
+	var lengths [288]uint8
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	lit, _ = newDeflate64Huffman(lengths[:])
+	for i := 0; i < 32; i++ {
+		lengths[i] = 5
+	}
+	dist, _ = newDeflate64Huffman(lengths[:32])
+	return lit, dist
+}
@@ -0,0 +1,532 @@
+package archives
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// Zip entries can be encrypted in one of two ways that are widely supported:
+//
+//   - The original PKWARE encryption, known as ZipCrypto, which is weak but
+//     still common. The entry's compressed data is encrypted with a stream
+//     cipher and preceded by a 12-byte encryption header.
+//   - WinZip's AES encryption, which replaces the compression method with
+//     99 and records the real method in an extra field (ID 0x9901). The
+//     compressed data is encrypted with AES in counter mode, preceded by
+//     a salt and a password verifier, and followed by an HMAC-SHA1
+//     authentication code.
+//
+// See https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT section 6.1
+// and https://www.winzip.com/en/support/aes-encryption/.
+
+// openEncryptedZipFile opens the encrypted zip entry f for reading with
+// password. Reads check the entry's CRC and, for WinZip AES, its
+// authentication code.
+func openEncryptedZipFile(f *zip.File, password string) (io.ReadCloser, error) {
+	if password == "" {
+		return nil, &ArchiveError{Entry: f.Name, Kind: ErrEncrypted, Err: errZipPasswordRequired}
+	}
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	rc, checkCRC, err := decryptZipEntry(&f.FileHeader, raw, int64(f.CompressedSize64), int64(f.UncompressedSize64), password)
+	if err != nil || !checkCRC {
+		return rc, err
+	}
+	return newZipChecksumReader(rc, f.CRC32), nil
+}
+
+// decryptZipEntry returns a reader of the decrypted and decompressed data
+// of the encrypted entry hdr, whose raw data is read from raw. The sizes of
+// the raw and the decompressed data are negative if unknown. Reads check
+// the authentication code, if any, but not the CRC; decryptZipEntry reports
+// whether the CRC should be checked, as AE-2 entries do not store it.
+func decryptZipEntry(hdr *zip.FileHeader, raw io.Reader, rawSize, size int64, password string) (io.ReadCloser, bool, error) {
+	if password == "" {
+		return nil, false, &ArchiveError{Entry: hdr.Name, Kind: ErrEncrypted, Err: errZipPasswordRequired}
+	}
+	if hdr.Method == zipMethodWinZipAES {
+		return decryptZipAES(hdr, raw, rawSize, size, password)
+	}
+	rc, err := decryptZipCrypto(hdr, raw, size, password)
+	return rc, true, err
+}
+
+func decryptZipCrypto(hdr *zip.FileHeader, raw io.Reader, size int64, password string) (io.ReadCloser, error) {
+	decompress := zipEntryDecompressor(hdr.Method, size)
+	if decompress == nil {
+		return nil, zip.ErrAlgorithm
+	}
+
+	keys := newZipCryptoKeys(password)
+	var header [12]byte
+	if _, err := io.ReadFull(raw, header[:]); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %w", err)
+	}
+	keys.decrypt(header[:])
+
+	// the last byte of the header is a check byte: the high byte of the CRC,
+	// or of the modification time if the CRC follows the data
+	check := byte(hdr.CRC32 >> 24)
+	if hdr.Flags&zipFlagDataDescriptor != 0 {
+		check = byte(hdr.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, errZipBadPassword
+	}
+
+	return decompressZipEntry(hdr.Name, decompress, &zipCryptoReader{r: raw, keys: keys})
+}
+
+// zipCryptoKeys is the state of the ZipCrypto stream cipher.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{0x12345678, 0x23456789, 0x34567890}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = zipCryptoCRC(k[0], b)
+	k[1] = (k[1]+k[0]&0xff)*134775813 + 1
+	k[2] = zipCryptoCRC(k[2], byte(k[1]>>24))
+}
+
+func (k *zipCryptoKeys) streamByte() byte {
+	temp := k[2] | 2
+	return byte((temp * (temp ^ 1)) >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(b []byte) {
+	for i := range b {
+		b[i] ^= k.streamByte()
+		k.update(b[i])
+	}
+}
+
+func zipCryptoCRC(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ crc>>8
+}
+
+// zipCryptoReader decrypts ZipCrypto-encrypted data.
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.keys.decrypt(p[:n])
+	return n, err
+}
+
+func decryptZipAES(hdr *zip.FileHeader, raw io.Reader, rawSize, size int64, password string) (io.ReadCloser, bool, error) {
+	ae, err := parseZipAESExtra(hdr.Extra)
+	if err != nil {
+		return nil, false, err
+	}
+	decompress := zipEntryDecompressor(ae.method, size)
+	if decompress == nil {
+		return nil, false, zip.ErrAlgorithm
+	}
+
+	saltLen, keyLen := ae.saltLen(), ae.keyLen()
+	overhead := int64(saltLen + zipAESVerifierLen + zipAESAuthCodeLen)
+	if rawSize >= 0 && rawSize < overhead {
+		return nil, false, fmt.Errorf("encrypted data is too short: %w", zip.ErrFormat)
+	}
+	header := make([]byte, saltLen+zipAESVerifierLen)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, false, fmt.Errorf("reading salt: %w", err)
+	}
+	encKey, authKey, verifier := zipAESKeys(password, header[:saltLen], keyLen)
+	if subtle.ConstantTimeCompare(verifier, header[saltLen:]) != 1 {
+		return nil, false, errZipBadPassword
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, false, err
+	}
+	plain := &zipAESReader{
+		ctr:   newZipAESCTR(block),
+		mac:   hmac.New(sha1.New, authKey),
+		check: true,
+	}
+	if rawSize >= 0 {
+		plain.r = io.LimitReader(raw, rawSize-overhead)
+		plain.raw = raw
+	} else {
+		// the authentication code is whatever is left at the end
+		tail := &zipTailReader{r: raw, tail: make([]byte, 0, zipAESAuthCodeLen)}
+		plain.r, plain.raw = tail, tail
+	}
+	rc, err := decompressZipEntry(hdr.Name, decompress, plain)
+	if err != nil {
+		return nil, false, err
+	}
+	// AE-2 does not store the CRC; the authentication code replaces it
+	return rc, ae.version != zipAESVersion2, nil
+}
+
+// zipTailReader reads all but the last bytes of r, as many as fit in
+// tail, which it can then read once the rest has been.
+type zipTailReader struct {
+	r    io.Reader
+	tail []byte
+	buf  []byte
+	done bool // whether the rest has been read
+}
+
+func (tr *zipTailReader) Read(p []byte) (int, error) {
+	if tr.done {
+		n := copy(p, tr.tail)
+		tr.tail = tr.tail[n:]
+		if len(tr.tail) == 0 {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	size := cap(tr.tail)
+	if len(tr.buf) < len(p)+size {
+		tr.buf = make([]byte, len(p)+size)
+	}
+	buf := tr.buf[:len(p)+size]
+	copy(buf, tr.tail)
+	n, err := tr.r.Read(buf[len(tr.tail):])
+	n += len(tr.tail)
+	if n <= size {
+		tr.tail = append(tr.tail[:0], buf[:n]...)
+		if err == io.EOF {
+			tr.done = true
+			if n < size {
+				return 0, io.ErrUnexpectedEOF
+			}
+		}
+		return 0, err
+	}
+	copied := copy(p, buf[:n-size])
+	tr.tail = append(tr.tail[:0], buf[copied:n]...)
+	if err == io.EOF {
+		tr.done = true
+	}
+	return copied, err
+}
+
+// zipAESExtra is the WinZip AES extra field of an entry.
+type zipAESExtra struct {
+	version  uint16 // AE-1 or AE-2
+	strength byte   // 1, 2, or 3 for AES-128, AES-192, or AES-256
+	method   uint16 // the actual compression method
+}
+
+func parseZipAESExtra(extra []byte) (zipAESExtra, error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == zipExtraWinZipAES && size >= 7 {
+			ae := zipAESExtra{
+				version:  binary.LittleEndian.Uint16(extra),
+				strength: extra[4],
+				method:   binary.LittleEndian.Uint16(extra[5:]),
+			}
+			if string(extra[2:4]) != "AE" || ae.strength < 1 || ae.strength > 3 {
+				return ae, fmt.Errorf("unsupported AES encryption (version %d, strength %d): %w", ae.version, ae.strength, zip.ErrAlgorithm)
+			}
+			return ae, nil
+		}
+		extra = extra[size:]
+	}
+	return zipAESExtra{}, fmt.Errorf("missing AES extra field: %w", zip.ErrFormat)
+}
+
+// bytes returns the encoded extra field, including its ID and size.
+func (ae zipAESExtra) bytes() []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b, zipExtraWinZipAES)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], ae.version)
+	copy(b[6:], "AE")
+	b[8] = ae.strength
+	binary.LittleEndian.PutUint16(b[9:], ae.method)
+	return b
+}
+
+func (ae zipAESExtra) saltLen() int { return 4 + 4*int(ae.strength) }
+func (ae zipAESExtra) keyLen() int  { return 8 + 8*int(ae.strength) }
+
+// zipAESKeys derives the encryption key, authentication key, and password
+// verifier from password and salt.
+func zipAESKeys(password string, salt []byte, keyLen int) (encKey, authKey, verifier []byte) {
+	keys := pbkdf2SHA1([]byte(password), salt, 1000, 2*keyLen+zipAESVerifierLen)
+	return keys[:keyLen], keys[keyLen : 2*keyLen], keys[2*keyLen:]
+}
+
+// pbkdf2SHA1 derives a key from password and salt as described in RFC 8018.
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var dk []byte
+	for block := uint32(1); len(dk) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := bytes.Clone(u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		dk = append(dk, t...)
+	}
+	return dk[:keyLen]
+}
+
+// zipAESCTR is AES in counter mode as WinZip uses it: the counter is a
+// little-endian integer that starts at 1, unlike cipher.NewCTR's.
+type zipAESCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	pos     int
+}
+
+func newZipAESCTR(block cipher.Block) *zipAESCTR {
+	return &zipAESCTR{block: block, pos: aes.BlockSize}
+}
+
+func (c *zipAESCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.pos == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.pos = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.pos]
+		c.pos++
+	}
+}
+
+// zipAESReader decrypts WinZip AES-encrypted data from r and, once all of
+// it has been read, checks the authentication code that follows it in raw.
+type zipAESReader struct {
+	r, raw io.Reader
+	ctr    cipher.Stream
+	mac    hash.Hash
+	check  bool
+}
+
+func (zr *zipAESReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.mac.Write(p[:n])
+	zr.ctr.XORKeyStream(p[:n], p[:n])
+	if err == io.EOF && zr.check {
+		zr.check = false
+		authCode := make([]byte, zipAESAuthCodeLen)
+		if _, err := io.ReadFull(zr.raw, authCode); err != nil {
+			return n, fmt.Errorf("reading authentication code: %w", err)
+		}
+		if !hmac.Equal(authCode, zr.mac.Sum(nil)[:zipAESAuthCodeLen]) {
+			return n, errZipAuthentication
+		}
+	}
+	return n, err
+}
+
+// zipChecksumReader checks the CRC of the data read from rc at EOF.
+type zipChecksumReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash32
+	want uint32
+}
+
+func newZipChecksumReader(rc io.ReadCloser, want uint32) io.ReadCloser {
+	return &zipChecksumReader{rc: rc, hash: crc32.NewIEEE(), want: want}
+}
+
+func (zr *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := zr.rc.Read(p)
+	zr.hash.Write(p[:n])
+	if err == io.EOF && zr.hash.Sum32() != zr.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}
+
+func (zr *zipChecksumReader) Close() error { return zr.rc.Close() }
+
+// zipAESEncrypter encrypts entries with WinZip AES-256 as they are written.
+// Archive writers pick a compressor by method only, so entries are written
+// with method 99, whose compressor is set to the encrypter; the encrypter
+// then compresses with the actual method, which is recorded by prepare.
+//
+// Entries are written as AE-1, which keeps the CRC, because the writers
+// always record it.
+type zipAESEncrypter struct {
+	password string
+	method   uint16 // of the entry being written
+	level    int
+}
+
+// prepare changes the header fields of an entry so that it is encrypted,
+// and compressed at level.
+func (e *zipAESEncrypter) prepare(level int, method, flags *uint16, extra *[]byte) {
+	e.method, e.level = *method, level
+	*method = zipMethodWinZipAES
+	*flags |= zipFlagEncrypted
+	ae := zipAESExtra{version: zipAESVersion1, strength: 3, method: e.method}
+	*extra = append(*extra, ae.bytes()...)
+}
+
+// compressor returns a writer that compresses, then encrypts, to w.
+// Closing it writes the authentication code; it does not close w.
+// Nothing is written to w until the first write or close, since
+// writers may create the compressor before writing the local header.
+func (e *zipAESEncrypter) compressor(w io.Writer) (io.WriteCloser, error) {
+	compress := zipCompressorLevel(e.method, e.level)
+	if compress == nil {
+		return nil, zip.ErrAlgorithm
+	}
+	ae := zipAESExtra{strength: 3}
+	salt := make([]byte, ae.saltLen())
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	encKey, authKey, verifier := zipAESKeys(e.password, salt, ae.keyLen())
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := &zipAESEncryptingWriter{
+		w:      w,
+		prefix: append(salt, verifier...),
+		ctr:    newZipAESCTR(block),
+		mac:    hmac.New(sha1.New, authKey),
+	}
+	return &zipAESWriter{compress: compress, enc: enc}, nil
+}
+
+// zipAESWriter compresses data into an encrypting writer. The compressor
+// is created on the first write or close, since some, like xz, write a
+// header as soon as they're created.
+type zipAESWriter struct {
+	compress zip.Compressor
+	comp     io.WriteCloser
+	enc      *zipAESEncryptingWriter
+}
+
+func (ew *zipAESWriter) start() error {
+	if ew.comp != nil {
+		return nil
+	}
+	comp, err := ew.compress(ew.enc)
+	if err != nil {
+		return err
+	}
+	ew.comp = comp
+	return nil
+}
+
+func (ew *zipAESWriter) Write(p []byte) (int, error) {
+	if err := ew.start(); err != nil {
+		return 0, err
+	}
+	return ew.comp.Write(p)
+}
+
+func (ew *zipAESWriter) Close() error {
+	if err := ew.start(); err != nil {
+		return err
+	}
+	if err := ew.comp.Close(); err != nil {
+		return err
+	}
+	if err := ew.enc.writePrefix(); err != nil {
+		return err
+	}
+	_, err := ew.enc.w.Write(ew.enc.mac.Sum(nil)[:zipAESAuthCodeLen])
+	return err
+}
+
+// zipAESEncryptingWriter encrypts and authenticates compressed data.
+type zipAESEncryptingWriter struct {
+	w      io.Writer
+	prefix []byte // salt and password verifier, until written
+	ctr    cipher.Stream
+	mac    hash.Hash
+	buf    []byte
+}
+
+func (ew *zipAESEncryptingWriter) writePrefix() error {
+	if ew.prefix == nil {
+		return nil
+	}
+	_, err := ew.w.Write(ew.prefix)
+	ew.prefix = nil
+	return err
+}
+
+func (ew *zipAESEncryptingWriter) Write(p []byte) (int, error) {
+	if err := ew.writePrefix(); err != nil {
+		return 0, err
+	}
+	ew.buf = append(ew.buf[:0], p...)
+	ew.ctr.XORKeyStream(ew.buf, ew.buf)
+	ew.mac.Write(ew.buf)
+	if _, err := ew.w.Write(ew.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+const (
+	zipMethodWinZipAES = 99
+	zipExtraWinZipAES  = 0x9901
+
+	zipAESVersion1    = 1
+	zipAESVersion2    = 2
+	zipAESVerifierLen = 2
+	zipAESAuthCodeLen = 10
+
+	// general purpose bit flags
+	zipFlagEncrypted      = 0x1
+	zipFlagLZMAEOS        = 0x2 // LZMA data ends with an end marker
+	zipFlagDataDescriptor = 0x8
+	zipFlagUTF8           = 0x800
+)
+
+var (
+	errZipPasswordRequired = errors.New("zip: entry is encrypted, password required")
+	errZipBadPassword      = errors.New("zip: incorrect password")
+	errZipAuthentication   = errors.New("zip: authentication code mismatch")
+)
@@ -0,0 +1,156 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipEncryption(t *testing.T) {
+	ctx := context.Background()
+	big := bytes.Repeat([]byte("encrypt me\n"), 5000)
+	files := []FileInfo{
+		memFile("a.txt", []byte("hello")),
+		memFile("big.txt", big),
+		memFile("empty.txt", nil),
+	}
+
+	checkContents := func(t *testing.T, format Zip, archive []byte, want map[string][]byte) {
+		t.Helper()
+		got := make(map[string][]byte)
+		err := format.Extract(ctx, bytes.NewReader(archive), func(_ context.Context, f FileInfo) error {
+			if !f.Encrypted {
+				t.Errorf("%s: expected entry to be marked as encrypted", f.NameInArchive)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			got[f.NameInArchive], err = io.ReadAll(rc)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("expected %d entries, got %d", len(want), len(got))
+		}
+		for name, contents := range want {
+			if !bytes.Equal(got[name], contents) {
+				t.Errorf("%s: extracted contents do not match", name)
+			}
+		}
+	}
+	checkPasswords := func(t *testing.T, archive []byte) {
+		t.Helper()
+		if err := readAllEntries(Zip{}, archive); !errors.Is(err, ErrEncrypted) {
+			t.Errorf("expected ErrEncrypted without a password, got: %v", err)
+		}
+		if err := readAllEntries(Zip{Password: "wrong"}, archive); !errors.Is(err, ErrBadPassword) {
+			t.Errorf("expected ErrBadPassword with the wrong password, got: %v", err)
+		}
+	}
+	want := map[string][]byte{"a.txt": []byte("hello"), "big.txt": big, "empty.txt": nil}
+
+	for _, method := range []uint16{zip.Store, zip.Deflate, ZipMethodZstd, ZipMethodXz} {
+		t.Run("Archive", func(t *testing.T) {
+			format := Zip{Compression: method, Password: "hunter2"}
+			buf := new(bytes.Buffer)
+			if err := format.Archive(ctx, buf, files); err != nil {
+				t.Fatal(err)
+			}
+			checkContents(t, format, buf.Bytes(), want)
+			checkPasswords(t, buf.Bytes())
+		})
+	}
+
+	t.Run("ArchiveAsync", func(t *testing.T) {
+		format := Zip{Compression: zip.Deflate, Password: "hunter2"}
+		jobs := make(chan ArchiveAsyncJob)
+		go func() {
+			defer close(jobs)
+			for _, file := range files {
+				result := make(chan error, 1)
+				jobs <- ArchiveAsyncJob{File: file, Result: result}
+				if err := <-result; err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		buf := new(bytes.Buffer)
+		if err := format.ArchiveAsync(ctx, buf, jobs); err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, format, buf.Bytes(), want)
+	})
+
+	t.Run("Insert", func(t *testing.T) {
+		format := Zip{Compression: zip.Deflate, Password: "hunter2"}
+		f, err := os.Create(filepath.Join(t.TempDir(), "test.zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := format.Archive(ctx, f, files[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := format.Insert(ctx, f, files[1:]); err != nil {
+			t.Fatal(err)
+		}
+		archive, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, format, archive, want)
+	})
+
+	t.Run("CorruptData", func(t *testing.T) {
+		// the xz and bzip2 decompressors read the start of the
+		// data right away, which is garbage if it's corrupt
+		for _, method := range []uint16{ZipMethodXz, ZipMethodBzip2} {
+			format := Zip{Compression: method, Password: "hunter2"}
+			buf := new(bytes.Buffer)
+			if err := format.Archive(ctx, buf, files[:1]); err != nil {
+				t.Fatal(err)
+			}
+			archive := buf.Bytes()
+			zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset, err := zr.File[0].DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ae, err := parseZipAESExtra(zr.File[0].Extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive[offset+int64(ae.saltLen()+zipAESVerifierLen)] ^= 0xff // first byte of ciphertext
+
+			if err := readAllEntries(format, archive); !errors.Is(err, ErrCorrupt) {
+				t.Errorf("method %d: expected ErrCorrupt, got: %v", method, err)
+			}
+		}
+	})
+
+	t.Run("ZipCrypto", func(t *testing.T) {
+		// created by Info-ZIP with: zip -P hunter2 -0 zipcrypto.zip plain.txt && zip -P hunter2 -9 zipcrypto.zip big.txt
+		archive, err := os.ReadFile("testdata/zipcrypto.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, Zip{Password: "hunter2"}, archive, map[string][]byte{
+			"plain.txt": []byte("hello, world\n"),
+			"big.txt":   bytes.Repeat([]byte("repeat me\n"), 500),
+		})
+		checkPasswords(t, archive)
+	})
+}
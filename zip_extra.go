@@ -0,0 +1,267 @@
+package archives
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// The headers of zip entries record only a modification time, to two
+// seconds in local time, and no owner. Other metadata go in extra fields:
+// Info-ZIP records times to the second in the extended timestamp field and
+// the owner's IDs in the Unix field, and Windows tools record times to the
+// 100 nanoseconds in the NTFS field.
+
+// zipExtraFields are the metadata recorded in the extra fields of a zip entry.
+type zipExtraFields struct {
+	owner                           *FileOwner
+	modTime, accessTime, createTime time.Time
+}
+
+// zipFileExtraFields returns the metadata of file to record in extra fields:
+// its owner and times from its FileInfo fields if set, or else the ones Tar
+// would record from its stat info.
+func zipFileExtraFields(file FileInfo) zipExtraFields {
+	x := zipExtraFields{
+		owner:      file.Owner,
+		modTime:    file.ModTime(),
+		accessTime: file.AccessTime,
+		createTime: file.CreateTime,
+	}
+	if x.owner == nil || x.accessTime.IsZero() {
+		// stat info always has an access time, and tar headers an owner;
+		// without either, the header's zero IDs mean only that they're unknown
+		_, isTar := file.Sys().(*tar.Header)
+		if hdr, err := tar.FileInfoHeader(file.FileInfo, ""); err == nil && (isTar || !hdr.AccessTime.IsZero()) {
+			if x.owner == nil {
+				x.owner = &FileOwner{Uid: hdr.Uid, Gid: hdr.Gid, Uname: hdr.Uname, Gname: hdr.Gname}
+			}
+			if x.accessTime.IsZero() {
+				x.accessTime = hdr.AccessTime
+			}
+		}
+	}
+	return x
+}
+
+// prepare adds the extra fields to a new entry, given pointers to its
+// header's fields. The modification time is cleared, having been converted
+// to the legacy fields, so that the writer doesn't add another, less precise
+// extended timestamp.
+func (x zipExtraFields) prepare(modified *time.Time, modifiedDate, modifiedTime *uint16, extra *[]byte) {
+	if !modified.IsZero() {
+		*modifiedDate, *modifiedTime = zipMsDosTime(*modified)
+		*modified = time.Time{}
+	}
+
+	// the extended timestamp is first and the NTFS field last, since readers
+	// that know both tend to take whichever comes last
+	times := []byte{0} // flags
+	for _, t := range []struct {
+		flag byte
+		time time.Time
+	}{
+		{zipExtTimeMod, x.modTime},
+		{zipExtTimeAccess, x.accessTime},
+		{zipExtTimeCreate, x.createTime},
+	} {
+		if fitsZipUnixTime(t.time) {
+			times[0] |= t.flag
+			times = binary.LittleEndian.AppendUint32(times, uint32(t.time.Unix()))
+		}
+	}
+	if times[0] != 0 {
+		*extra = appendZipExtraField(*extra, zipExtraExtTime, times)
+	}
+	if x.owner != nil && x.owner.Uid >= 0 && x.owner.Gid >= 0 {
+		field := []byte{1, 4} // version, size of UID
+		field = binary.LittleEndian.AppendUint32(field, uint32(x.owner.Uid))
+		field = append(field, 4) // size of GID
+		field = binary.LittleEndian.AppendUint32(field, uint32(x.owner.Gid))
+		*extra = appendZipExtraField(*extra, zipExtraUnix, field)
+	}
+	// readers take the modification time of the NTFS field even if it's 0
+	if mtime := zipNTFSTime(x.modTime); mtime != 0 {
+		field := make([]byte, 4, 32) // reserved
+		field = binary.LittleEndian.AppendUint16(field, zipNTFSTimesTag)
+		field = binary.LittleEndian.AppendUint16(field, 24)
+		field = binary.LittleEndian.AppendUint64(field, mtime)
+		field = binary.LittleEndian.AppendUint64(field, zipNTFSTime(x.accessTime))
+		field = binary.LittleEndian.AppendUint64(field, zipNTFSTime(x.createTime))
+		*extra = appendZipExtraField(*extra, zipExtraNTFS, field)
+	}
+}
+
+// fill sets the owner and times of file from x.
+func (x zipExtraFields) fill(file *FileInfo) {
+	file.Owner = x.owner
+	file.AccessTime = x.accessTime
+	file.CreateTime = x.createTime
+}
+
+// parseZipExtraFields reads the metadata in the extra fields of a zip entry.
+// Times in the NTFS field take precedence, being the most precise. Fields
+// that are malformed are ignored, as the zip package does.
+func parseZipExtraFields(extra []byte) zipExtraFields {
+	var x, ntfs zipExtraFields
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		switch id {
+		case zipExtraExtTime:
+			// in central directory headers, Info-ZIP records only the
+			// modification time, but sets the flags of the local header
+			if len(field) < 1 {
+				continue
+			}
+			flags := field[0]
+			field = field[1:]
+			for _, t := range []struct {
+				flag byte
+				time *time.Time
+			}{
+				{zipExtTimeMod, &x.modTime},
+				{zipExtTimeAccess, &x.accessTime},
+				{zipExtTimeCreate, &x.createTime},
+			} {
+				if flags&t.flag == 0 {
+					continue
+				}
+				if len(field) < 4 {
+					break
+				}
+				*t.time = time.Unix(int64(binary.LittleEndian.Uint32(field)), 0).UTC()
+				field = field[4:]
+			}
+
+		case zipExtraUnix:
+			if len(field) < 2 || field[0] != 1 {
+				continue
+			}
+			uid, field, ok := readZipUnixID(field[1:])
+			if !ok {
+				continue
+			}
+			gid, _, ok := readZipUnixID(field)
+			if !ok {
+				continue
+			}
+			x.owner = &FileOwner{Uid: uid, Gid: gid}
+
+		case zipExtraNTFS:
+			if len(field) < 4 {
+				continue
+			}
+			field = field[4:] // reserved
+			for len(field) >= 4 {
+				tag := binary.LittleEndian.Uint16(field)
+				size := int(binary.LittleEndian.Uint16(field[2:]))
+				if len(field) < 4+size {
+					break
+				}
+				attr := field[4 : 4+size]
+				field = field[4+size:]
+				if tag != zipNTFSTimesTag || size != 24 {
+					continue
+				}
+				ntfs.modTime = zipNTFSTimeToTime(binary.LittleEndian.Uint64(attr))
+				ntfs.accessTime = zipNTFSTimeToTime(binary.LittleEndian.Uint64(attr[8:]))
+				ntfs.createTime = zipNTFSTimeToTime(binary.LittleEndian.Uint64(attr[16:]))
+			}
+		}
+	}
+
+	for _, t := range []struct{ time, ntfs *time.Time }{
+		{&x.modTime, &ntfs.modTime},
+		{&x.accessTime, &ntfs.accessTime},
+		{&x.createTime, &ntfs.createTime},
+	} {
+		if !t.ntfs.IsZero() {
+			*t.time = *t.ntfs
+		}
+	}
+	return x
+}
+
+// readZipUnixID reads an ID of the size given by its first byte from
+// the Unix extra field, and returns the rest of the field.
+func readZipUnixID(field []byte) (int, []byte, bool) {
+	if len(field) < 1 {
+		return 0, nil, false
+	}
+	size := int(field[0])
+	if size > 8 || len(field) < 1+size {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := size - 1; i >= 0; i-- {
+		id = id<<8 | uint64(field[1+i])
+	}
+	if id > math.MaxInt32 {
+		return 0, nil, false
+	}
+	return int(id), field[1+size:], true
+}
+
+func appendZipExtraField(extra []byte, id uint16, field []byte) []byte {
+	extra = binary.LittleEndian.AppendUint16(extra, id)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(len(field)))
+	return append(extra, field...)
+}
+
+// fitsZipUnixTime reports whether t is set and can be recorded
+// in the extended timestamp field.
+func fitsZipUnixTime(t time.Time) bool {
+	return !t.IsZero() && t.Unix() >= 0 && t.Unix() <= math.MaxUint32
+}
+
+// zipNTFSTime converts t to a Windows file time, the number of 100-nanosecond
+// intervals since 1601, or 0 if t is not set or is before then.
+func zipNTFSTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	secs := t.Unix() + zipNTFSEpochOffset
+	if secs <= 0 {
+		return 0
+	}
+	return uint64(secs)*1e7 + uint64(t.Nanosecond()/100)
+}
+
+// zipNTFSTimeToTime converts a Windows file time to a time,
+// which is zero if the file time is 0, meaning it's not set.
+func zipNTFSTimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(ft/1e7)-zipNTFSEpochOffset, int64(ft%1e7)*100).UTC()
+}
+
+// zipMsDosTime converts t to the legacy date and time fields of a zip
+// header, in the time zone of t, as the zip package does.
+func zipMsDosTime(t time.Time) (date, tm uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+const (
+	zipExtraNTFS    = 0x000a
+	zipExtraExtTime = 0x5455
+	zipExtraUnix    = 0x7875
+
+	// flags of the extended timestamp field
+	zipExtTimeMod    = 0x1
+	zipExtTimeAccess = 0x2
+	zipExtTimeCreate = 0x4
+
+	zipNTFSTimesTag    = 0x0001
+	zipNTFSEpochOffset = 11644473600 // seconds from 1601 to 1970
+)
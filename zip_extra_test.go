@@ -0,0 +1,154 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestZipExtraFields(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Round trip", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Date(2021, 3, 4, 5, 6, 7, 123456700, time.UTC)
+		atime := time.Date(2022, 8, 9, 10, 11, 12, 987654300, time.UTC)
+		for _, name := range []string{"sub/file.txt", "sub"} {
+			if err := os.Chtimes(filepath.Join(dir, name), atime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		files, err := FilesFromDisk(ctx, nil, map[string]string{filepath.Join(dir, "sub"): "sub"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := (Zip{}).Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		tarBuf := new(bytes.Buffer)
+		if err := (Tar{}).Archive(ctx, tarBuf, files); err != nil {
+			t.Fatal(err)
+		}
+		tarOwners := make(map[string]FileOwner)
+		err = Tar{}.Extract(ctx, tarBuf, func(_ context.Context, f FileInfo) error {
+			tarOwners[f.NameInArchive] = *f.Owner
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			name   string
+			stream bool
+		}{
+			{name: "central directory"},
+			{name: "stream", stream: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				var input io.Reader = bytes.NewReader(buf.Bytes())
+				if tc.stream {
+					input = struct{ io.Reader }{input}
+				}
+				var count int
+				err := Zip{}.Extract(ctx, input, func(_ context.Context, f FileInfo) error {
+					count++
+					if !f.ModTime().Equal(mtime) {
+						t.Errorf("%s: expected modification time %s, got %s", f.NameInArchive, mtime, f.ModTime())
+					}
+					if !f.AccessTime.Equal(atime) {
+						t.Errorf("%s: expected access time %s, got %s", f.NameInArchive, atime, f.AccessTime)
+					}
+					if f.Owner == nil {
+						t.Fatalf("%s: expected an owner", f.NameInArchive)
+					}
+					// names are not recorded in zip archives
+					name := f.NameInArchive
+					if f.IsDir() {
+						name = "sub" // tar names directories without a trailing slash
+					}
+					if want := tarOwners[name]; f.Owner.Uid != want.Uid || f.Owner.Gid != want.Gid {
+						t.Errorf("%s: expected the owner tar records, %d:%d, got %d:%d", f.NameInArchive, want.Uid, want.Gid, f.Owner.Uid, f.Owner.Gid)
+					}
+					if runtime.GOOS != "windows" && f.Owner.Uid != os.Getuid() {
+						t.Errorf("%s: expected UID %d, got %d", f.NameInArchive, os.Getuid(), f.Owner.Uid)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if count != 2 {
+					t.Errorf("expected 2 files, got %d", count)
+				}
+			})
+		}
+	})
+
+	t.Run("Owner from FileInfo", func(t *testing.T) {
+		file := memFile("owned.txt", []byte("contents"))
+		file.Owner = &FileOwner{Uid: 1234, Gid: 5678}
+		file.CreateTime = time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+
+		buf := new(bytes.Buffer)
+		if err := (Zip{}).Archive(ctx, buf, []FileInfo{file}); err != nil {
+			t.Fatal(err)
+		}
+		err := Zip{}.Extract(ctx, bytes.NewReader(buf.Bytes()), func(_ context.Context, f FileInfo) error {
+			if f.Owner == nil || *f.Owner != *file.Owner {
+				t.Errorf("expected owner %+v, got %+v", file.Owner, f.Owner)
+			}
+			// memFile has no modification time, so there is no NTFS
+			// field, and the extended timestamp records only seconds
+			if want := file.CreateTime.Truncate(time.Second); !f.CreateTime.Equal(want) {
+				t.Errorf("expected creation time %s, got %s", want, f.CreateTime)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Info-ZIP", func(t *testing.T) {
+		// extra fields of a file added by Info-ZIP's zip 3.0, owned by root:
+		// the central directory header has only the modification time of
+		// the extended timestamp, while the local header also has the access
+		// time and says so in the flags of both
+		mtime := time.Unix(0x6ad2f267, 0).UTC()
+		atime := time.Unix(0x6ad2f267, 0).UTC()
+		for _, tc := range []struct {
+			name, extra string
+			atime       time.Time
+		}{
+			{name: "central", extra: "555405000367f2d26a75780b000104000000000400000000"},
+			{name: "local", extra: "555409000367f2d26a67f2d26a75780b000104000000000400000000", atime: atime},
+		} {
+			extra, err := hex.DecodeString(tc.extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			x := parseZipExtraFields(extra)
+			if !x.modTime.Equal(mtime) || !x.accessTime.Equal(tc.atime) || !x.createTime.IsZero() {
+				t.Errorf("%s: unexpected times %s, %s and %s", tc.name, x.modTime, x.accessTime, x.createTime)
+			}
+			if x.owner == nil || *x.owner != (FileOwner{}) {
+				t.Errorf("%s: expected owner 0:0, got %+v", tc.name, x.owner)
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package archives
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Zip compression method 14 is LZMA. The compressed data starts with
+// a 4-byte header: the major and minor version of the LZMA SDK that
+// wrote it, and the size of the properties that follow, which are the
+// 5 bytes that start a classic .lzma file (without its size). Entries
+// with general purpose flag bit 1 set end with an end marker; others
+// end after their uncompressed size.
+//
+// See https://pkware.cachefly.net/webdocs/casestudies/APPNOTE.TXT section 5.8.
+
+// newZipLZMAReader returns a reader that decompresses the LZMA-compressed
+// data in r. If size is not negative, it is the size of the decompressed
+// data; otherwise the data must end with an end marker.
+func newZipLZMAReader(r io.Reader, size int64) io.ReadCloser {
+	return &zipLZMAReader{r: r, size: size}
+}
+
+type zipLZMAReader struct {
+	r    io.Reader
+	size int64
+	lr   *lzma.Reader
+	err  error
+}
+
+func (zr *zipLZMAReader) Read(p []byte) (int, error) {
+	if zr.lr == nil && zr.err == nil {
+		zr.lr, zr.err = zr.init()
+	}
+	if zr.err != nil {
+		return 0, zr.err
+	}
+	return zr.lr.Read(p)
+}
+
+// init reads the zip LZMA header and returns a reader of the LZMA
+// stream that follows it, given a classic header in its place.
+func (zr *zipLZMAReader) init() (*lzma.Reader, error) {
+	var header [4 + zipLZMAPropsLen]byte
+	if _, err := io.ReadFull(zr.r, header[:4]); err != nil {
+		return nil, noEOF(err)
+	}
+	if propsLen := binary.LittleEndian.Uint16(header[2:]); propsLen != zipLZMAPropsLen {
+		return nil, fmt.Errorf("lzma: properties of unexpected size %d: %w", propsLen, zip.ErrFormat)
+	}
+	if _, err := io.ReadFull(zr.r, header[4:]); err != nil {
+		return nil, noEOF(err)
+	}
+
+	// the decoder allocates the whole dictionary up front, but never
+	// needs more of it than the uncompressed size, if that's known
+	dictCap := int64(binary.LittleEndian.Uint32(header[5:]))
+	if zr.size >= 0 {
+		dictCap = max(min(dictCap, zr.size), lzma.MinDictCap)
+	}
+	if dictCap > zipLZMAMaxDictCap {
+		return nil, fmt.Errorf("lzma: dictionary of %d bytes is larger than the maximum of %d: %w", dictCap, zipLZMAMaxDictCap, zip.ErrFormat)
+	}
+
+	classic := make([]byte, lzma.HeaderLen)
+	copy(classic, header[4:])
+	binary.LittleEndian.PutUint32(classic[1:], uint32(dictCap))
+	binary.LittleEndian.PutUint64(classic[zipLZMAPropsLen:], uint64(zr.size)) // all ones if unknown
+	return lzma.NewReader(io.MultiReader(bytes.NewReader(classic), zr.r))
+}
+
+func (zr *zipLZMAReader) Close() error { return nil }
+
+// newZipLZMAWriter returns a writer that compresses to w with LZMA in
+// the form 7-Zip writes: with the zip LZMA header and an end marker, so
+// entries written with it must have flag bit 1 set. Nothing is written
+// to w until the first write or close.
+func newZipLZMAWriter(w io.Writer) (io.WriteCloser, error) {
+	props := lzma.Properties{LC: 3, LP: 0, PB: 2}
+	header := []byte{zipLZMAVersionMajor, zipLZMAVersionMinor, zipLZMAPropsLen, 0, props.Code(), 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[5:], zipLZMADictCap)
+
+	// the LZMA writer writes a classic header, which the zip header replaces
+	hw := &zipLZMAHeaderWriter{w: w, header: header, skip: lzma.HeaderLen}
+	return lzma.WriterConfig{
+		Properties: &props,
+		DictCap:    zipLZMADictCap,
+		EOSMarker:  true,
+	}.NewWriter(hw)
+}
+
+// zipLZMAHeaderWriter replaces the classic header of an LZMA stream
+// with the zip LZMA header.
+type zipLZMAHeaderWriter struct {
+	w      io.Writer
+	header []byte // until written
+	skip   int    // bytes of the classic header still to be discarded
+}
+
+func (hw *zipLZMAHeaderWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	skip := min(hw.skip, len(p))
+	hw.skip -= skip
+	if p = p[skip:]; len(p) == 0 {
+		return n, nil
+	}
+	if hw.header != nil {
+		if _, err := hw.w.Write(hw.header); err != nil {
+			return 0, err
+		}
+		hw.header = nil
+	}
+	if _, err := hw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+const (
+	zipLZMAPropsLen = 5
+
+	// the version of the LZMA SDK whose format is written
+	zipLZMAVersionMajor = 9
+	zipLZMAVersionMinor = 20
+
+	zipLZMADictCap = 8 << 20
+
+	// the largest dictionary that is read, which is more than
+	// the presets of 7-Zip and xz use (64 MiB at most)
+	zipLZMAMaxDictCap = 256 << 20
+)
@@ -0,0 +1,284 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestZipCompressionMethods(t *testing.T) {
+	ctx := context.Background()
+
+	var lines strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&lines, "line %d: the quick brown fox jumps over the lazy dog %d times\n", i, i*i%97)
+	}
+
+	extract := func(t *testing.T, format Zip, archive []byte) map[string][]byte {
+		t.Helper()
+		got := make(map[string][]byte)
+		err := format.Extract(ctx, bytes.NewReader(archive), func(_ context.Context, f FileInfo) error {
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			got[f.NameInArchive], err = io.ReadAll(rc)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	rawZip := func(t *testing.T, method uint16, flags uint16, compressed, contents []byte) []byte {
+		t.Helper()
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               "file.txt",
+			Method:             method,
+			Flags:              flags,
+			CRC32:              crc32.ChecksumIEEE(contents),
+			CompressedSize64:   uint64(len(compressed)),
+			UncompressedSize64: uint64(len(contents)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(compressed); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	t.Run("PPMd", func(t *testing.T) {
+		// order6.txt is compressed with order 6 and 16 MB of memory, which restarts
+		// the model when memory runs out; order16.txt with order 16 and 1 MB, which
+		// cuts the model off instead; both end with an end marker
+		archive, err := os.ReadFile("testdata/ppmd.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := extract(t, Zip{}, archive)
+		for _, name := range []string{"order6.txt", "order16.txt"} {
+			if string(got[name]) != lines.String() {
+				t.Errorf("%s: extracted contents do not match", name)
+			}
+		}
+
+		// corrupt the compressed data of the first entry
+		corrupt := bytes.Clone(archive)
+		corrupt[30+len("order6.txt")+40] ^= 0xff
+		if err := readAllEntries(Zip{}, corrupt); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got: %v", err)
+		}
+
+		// ask for the most memory the header can, 256 MB
+		huge := bytes.Clone(archive)
+		huge[30+len("order6.txt")] |= 0xf0
+		huge[30+len("order6.txt")+1] |= 0x0f
+		if err := readAllEntries(Zip{}, huge); !errors.Is(err, errPPMdCorrupt) {
+			t.Errorf("expected the memory size to be refused, got: %v", err)
+		}
+	})
+
+	t.Run("LZMA", func(t *testing.T) {
+		// created by Python's zipfile module, which writes LZMA entries like 7-Zip, with:
+		// zipfile.ZipFile('lzma.zip', 'w', zipfile.ZIP_LZMA).write('lines.txt') and
+		// writestr('empty.txt', b'')
+		archive, err := os.ReadFile("testdata/lzma.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := extract(t, Zip{}, archive)
+		if string(got["lines.txt"]) != lines.String() || len(got["empty.txt"]) != 0 {
+			t.Error("extracted contents do not match")
+		}
+	})
+
+	t.Run("LZMA without end marker", func(t *testing.T) {
+		contents := []byte(lines.String())
+		buf := new(bytes.Buffer)
+		lw, err := lzma.WriterConfig{SizeInHeader: true, Size: int64(len(contents))}.NewWriter(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := lw.Write(contents); err != nil {
+			t.Fatal(err)
+		}
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// replace the classic header with the zip LZMA header
+		compressed := append([]byte{9, 20, 5, 0}, buf.Bytes()[:5]...)
+		compressed = append(compressed, buf.Bytes()[lzma.HeaderLen:]...)
+
+		got := extract(t, Zip{}, rawZip(t, ZipMethodLzma, 0, compressed, contents))
+		if !bytes.Equal(got["file.txt"], contents) {
+			t.Error("extracted contents do not match")
+		}
+
+		// a dictionary larger than the data is only as large as the data
+		binary.LittleEndian.PutUint32(compressed[5:], 0xf0000000)
+		got = extract(t, Zip{}, rawZip(t, ZipMethodLzma, 0, compressed, contents))
+		if !bytes.Equal(got["file.txt"], contents) {
+			t.Error("extracted contents do not match with a huge dictionary")
+		}
+
+		// but if the size isn't known, it's refused
+		_, err = io.ReadAll(newZipLZMAReader(bytes.NewReader(compressed), -1))
+		if !errors.Is(classifyError("", err), ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got: %v", err)
+		}
+	})
+
+	for _, password := range []string{"", "hunter2"} {
+		t.Run("LZMA round trip", func(t *testing.T) {
+			format := Zip{Compression: ZipMethodLzma, Password: password}
+			files := []FileInfo{
+				memFile("lines.txt", []byte(lines.String())),
+				memFile("empty.txt", nil),
+			}
+			buf := new(bytes.Buffer)
+			if err := format.Archive(ctx, buf, files); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range zr.File {
+				if f.Flags&zipFlagLZMAEOS == 0 {
+					t.Errorf("%s: expected the end marker flag to be set", f.Name)
+				}
+			}
+			got := extract(t, format, buf.Bytes())
+			if string(got["lines.txt"]) != lines.String() || len(got["empty.txt"]) != 0 {
+				t.Error("extracted contents do not match")
+			}
+		})
+	}
+
+	t.Run("Deflate64", func(t *testing.T) {
+		compressed, contents := deflate64Stream()
+		got := extract(t, Zip{}, rawZip(t, ZipMethodDeflate64, 0, compressed, contents))
+		if !bytes.Equal(got["file.txt"], contents) {
+			t.Error("extracted contents do not match")
+		}
+
+		truncated := rawZip(t, ZipMethodDeflate64, 0, compressed[:len(compressed)-4], contents)
+		if err := readAllEntries(Zip{}, truncated); !errors.Is(err, ErrTruncated) {
+			t.Errorf("expected ErrTruncated, got: %v", err)
+		}
+	})
+
+	t.Run("Deflate64 dynamic codes", func(t *testing.T) {
+		// payload.txt is compressed in four blocks with dynamic codes by a
+		// greedy LZ77 encoder with a 64 KiB window: the text is repeated from
+		// more than 32 KiB back, and the run of x's needs length code 285.
+		// The archive reads the same with the Deflate64 decoder of .NET's
+		// System.IO.Compression.ZipArchive.
+		archive, err := os.ReadFile("testdata/deflate64.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text strings.Builder
+		for i := 0; i < 600; i++ {
+			fmt.Fprintf(&text, "line %d: the quick brown fox jumps over the lazy dog %d times\n", i, i*i%97)
+		}
+		want := text.String() + text.String() + strings.Repeat("x", 70000)
+		got := extract(t, Zip{}, archive)
+		if string(got["payload.txt"]) != want {
+			t.Error("extracted contents do not match")
+		}
+	})
+}
+
+// deflate64Stream returns a Deflate64 stream that uses the parts of the
+// format that differ from Deflate, and the data it decompresses to. It
+// has a stored block, then a block with fixed codes with matches that
+// reach further back than 32 KiB and are longer than 258 bytes.
+func deflate64Stream() (compressed, contents []byte) {
+	stored := make([]byte, 60000)
+	rand.New(rand.NewSource(1)).Read(stored)
+
+	var bw deflate64BitWriter
+	bw.writeBits(0, 3) // not final, stored
+	bw.align()
+	bw.out = binary.LittleEndian.AppendUint16(bw.out, uint16(len(stored)))
+	bw.out = binary.LittleEndian.AppendUint16(bw.out, ^uint16(len(stored)))
+	bw.out = append(bw.out, stored...)
+	contents = append(contents, stored...)
+
+	bw.writeBits(1, 1) // final
+	bw.writeBits(1, 2) // fixed codes
+	for _, match := range []struct{ length, dist int }{
+		{length: 1000, dist: 60000},  // distance code 31
+		{length: 65538, dist: 40000}, // distance code 30, longest length
+	} {
+		bw.writeCode(0xc0+285-280, 8) // length code 285
+		bw.writeBits(uint64(match.length-3), 16)
+		distCode, distBase := 30, 32769
+		if match.dist >= 49153 {
+			distCode, distBase = 31, 49153
+		}
+		bw.writeCode(uint64(distCode), 5)
+		bw.writeBits(uint64(match.dist-distBase), 14)
+		for i := 0; i < match.length; i++ {
+			contents = append(contents, contents[len(contents)-match.dist])
+		}
+	}
+	bw.writeCode(0x30+'x', 8) // literal
+	contents = append(contents, 'x')
+	bw.writeCode(0, 7) // end of block
+	bw.align()
+	return bw.out, contents
+}
+
+// deflate64BitWriter writes bits starting from the low bit of each byte.
+type deflate64BitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (bw *deflate64BitWriter) writeBits(v uint64, n uint) {
+	bw.bits |= v << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.out = append(bw.out, byte(bw.bits))
+		bw.bits >>= 8
+		bw.nbits -= 8
+	}
+}
+
+// writeCode writes a Huffman code, which starts from its high bit.
+func (bw *deflate64BitWriter) writeCode(code uint64, n uint) {
+	var rev uint64
+	for i := uint(0); i < n; i++ {
+		rev |= (code >> i & 1) << (n - 1 - i)
+	}
+	bw.writeBits(rev, n)
+}
+
+func (bw *deflate64BitWriter) align() {
+	if bw.nbits > 0 {
+		bw.writeBits(0, 8-bw.nbits)
+	}
+}
@@ -0,0 +1,248 @@
+package archives
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// The entries of a zip archive are compressed independently, so they can be
+// compressed in parallel, each into a buffer, and then written in order as
+// raw entries. Their headers are completed as the writer would complete them
+// if it compressed them, so the archive is the same either way.
+
+// writesRaw reports whether files are added to archives with
+// archiveConcurrently, which aligning them requires, since the writer
+// only finishes writing a file that it compresses once the next is added.
+func (z Zip) writesRaw() bool {
+	return z.Concurrency > 1 || z.Alignment > 1 || z.AlignSharedLibraries
+}
+
+// archiveConcurrently adds the files that next returns to zw, like calling
+// archiveOneFile with each of them, but compresses up to z.Concurrency of
+// them at once. next returns false when there are no more files, and may
+// return a channel to send the result of writing the file to. Once a file
+// fails, the files after it are not written, and their results are the
+// error that stopped the operation.
+func (z Zip) archiveConcurrently(ctx context.Context, zw *zipWriter, enc *zipAESEncrypter, next func(context.Context) (FileInfo, chan<- error, bool)) error {
+	p := progressFrom(ctx)
+
+	// files are compressed without reporting progress, which
+	// is reported as they are written, one at a time
+	compressCtx, cancel := context.WithCancel(context.WithValue(ctx, progressKey{}, (*progress)(nil)))
+	defer cancel()
+
+	// the entries in the queue and the one being written are those being
+	// compressed or waiting to be written, so there are z.Concurrency
+	queue := make(chan *zipPendingEntry, max(z.Concurrency, 1)-1)
+	go func() {
+		defer close(queue)
+		for i := 0; ; i++ {
+			file, result, ok := next(compressCtx)
+			if !ok {
+				return
+			}
+			pe := &zipPendingEntry{file: file, result: result, done: make(chan struct{})}
+			queue <- pe
+			if err := compressCtx.Err(); err != nil {
+				pe.err = err
+				close(pe.done)
+				continue
+			}
+			go func() {
+				defer close(pe.done)
+				entryEnc := enc
+				if enc != nil {
+					// the encrypter holds the method of the entry being written
+					entryEnc = &zipAESEncrypter{password: enc.password}
+				}
+				pe.entry, pe.data, pe.err = z.compressEntry(compressCtx, entryEnc, i, file)
+			}()
+		}
+	}()
+
+	errs := z.entryErrorHandler()
+	var abortErr error
+	for pe := range queue {
+		<-pe.done
+		if abortErr != nil {
+			if pe.result != nil {
+				pe.result <- abortErr
+			}
+			pe.close()
+			continue
+		}
+
+		p.start(pe.file)
+		err := pe.err
+		if err == nil {
+			err = pe.write(z, zw)
+		}
+		if err == nil && pe.file.Mode().IsRegular() {
+			p.copied(int64(pe.entry.hdr.UncompressedSize64))
+		}
+		p.finish(err)
+		pe.close()
+		if pe.result != nil {
+			pe.result <- err
+		}
+		if err != nil {
+			abortErr = errs.handle(ctx, pe.file.NameInArchive, err)
+			if abortErr != nil {
+				cancel()
+			}
+		}
+	}
+	if abortErr != nil {
+		return abortErr
+	}
+
+	return errs.err()
+}
+
+// zipPendingEntry is a file being compressed to be written to an archive.
+type zipPendingEntry struct {
+	file   FileInfo
+	result chan<- error  // if not nil, for the result of writing the file
+	done   chan struct{} // closed once the file is compressed or fails
+	entry  *zipEntry     // with its header completed
+	data   *spillBuffer  // the compressed data, nil for directories
+	err    error
+}
+
+// write writes the compressed entry to zw, aligned as z requires.
+func (pe *zipPendingEntry) write(z Zip, zw *zipWriter) error {
+	e := pe.entry
+	if err := z.align(zw, e.hdr); err != nil {
+		return fmt.Errorf("aligning file %d: %s: %w", e.idx, e.file.Name(), err)
+	}
+	w, err := zw.CreateRaw(e.hdr)
+	if err != nil {
+		return fmt.Errorf("creating header for file %d: %s: %w", e.idx, e.file.Name(), err)
+	}
+	zw.descriptorLen = 0
+	if pe.data == nil {
+		return nil
+	}
+	if _, err := pe.data.WriteTo(w); err != nil {
+		return fmt.Errorf("writing file %d: %s: %w", e.idx, e.file.Name(), err)
+	}
+	zw.descriptorLen = zipDataDescriptorLen
+	if e.hdr.ReaderVersion == zipVersion45 {
+		zw.descriptorLen = zipDataDescriptor64Len
+	}
+	return nil
+}
+
+func (pe *zipPendingEntry) close() {
+	if pe.data != nil {
+		pe.data.Close()
+	}
+}
+
+// compressEntry makes the entry for file and compresses its contents.
+// The data is nil for directories, and must be closed otherwise.
+func (z Zip) compressEntry(ctx context.Context, enc *zipAESEncrypter, idx int, file FileInfo) (*zipEntry, *spillBuffer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err // honor context cancellation
+	}
+	e, err := z.newEntry(ctx, enc, idx, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer e.close()
+	if file.IsDir() {
+		e.prepareRaw(0, 0, 0)
+		return e, nil, nil
+	}
+
+	comp := zipCompressorLevel(e.hdr.Method, e.level)
+	if e.enc != nil {
+		comp = e.enc.compressor
+	}
+	if comp == nil {
+		return nil, nil, fmt.Errorf("compressing file %d: %s: %w", idx, file.Name(), zip.ErrAlgorithm)
+	}
+	data := new(spillBuffer)
+	compressed := &countingWriter{Writer: data}
+	cw, err := comp(compressed)
+	if err != nil {
+		data.Close()
+		return nil, nil, fmt.Errorf("compressing file %d: %s: %w", idx, file.Name(), err)
+	}
+	crc := crc32.NewIEEE()
+	uncompressed := &countingWriter{Writer: io.MultiWriter(crc, cw)}
+	if err := e.writeContents(ctx, uncompressed); err != nil {
+		cw.Close()
+		data.Close()
+		return nil, nil, err
+	}
+	if err := cw.Close(); err != nil {
+		data.Close()
+		return nil, nil, fmt.Errorf("compressing file %d: %s: %w", idx, file.Name(), err)
+	}
+	e.prepareRaw(crc.Sum32(), compressed.n.Load(), uncompressed.n.Load())
+	return e, data, nil
+}
+
+// prepareRaw completes the header of an entry whose contents, of the given
+// size and checksum, were compressed to compressedSize bytes, as the writer
+// completes the headers of the entries it compresses, so that writing it
+// with CreateRaw writes the same entry as CreateHeader. The header's
+// Modified field is not set, since its extra fields record the time.
+func (e *zipEntry) prepareRaw(crc uint32, compressedSize, size int64) {
+	hdr := e.hdr
+	nameValid, nameRequire := zipDetectUTF8(hdr.Name)
+	commentValid, commentRequire := zipDetectUTF8(hdr.Comment)
+	switch {
+	case hdr.NonUTF8:
+		hdr.Flags &^= zipFlagUTF8
+	case (nameRequire || commentRequire) && nameValid && commentValid:
+		hdr.Flags |= zipFlagUTF8
+	}
+	hdr.CreatorVersion = hdr.CreatorVersion&0xff00 | zipVersion20
+	hdr.ReaderVersion = zipVersion20
+
+	if e.file.IsDir() {
+		hdr.Flags &^= zipFlagDataDescriptor
+		return
+	}
+	hdr.Flags |= zipFlagDataDescriptor
+	hdr.CRC32 = crc
+	hdr.CompressedSize64 = uint64(compressedSize)
+	hdr.UncompressedSize64 = uint64(size)
+	if hdr.CompressedSize64 >= 0xffffffff || hdr.UncompressedSize64 >= 0xffffffff {
+		hdr.ReaderVersion = zipVersion45
+	}
+}
+
+// zipDetectUTF8 reports whether s is valid UTF-8, and whether it must be
+// recorded as UTF-8 because it has characters that are not the same in
+// CP-437 and the encodings that readers commonly assume, as the zip writer
+// decides whether to set the UTF-8 flag.
+func zipDetectUTF8(s string) (valid, require bool) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r < 0x20 || r > 0x7d || r == 0x5c {
+			if !utf8.ValidRune(r) || (r == utf8.RuneError && size == 1) {
+				return false, false
+			}
+			require = true
+		}
+	}
+	return true, require
+}
+
+const (
+	zipVersion20 = 20 // 2.0
+	zipVersion45 = 45 // 4.5, for ZIP64
+
+	// the lengths of the data descriptors that follow raw entries
+	zipDataDescriptorLen   = 16
+	zipDataDescriptor64Len = 24
+)
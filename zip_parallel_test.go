@@ -0,0 +1,163 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipConcurrency(t *testing.T) {
+	ctx := context.Background()
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+	// file returns a file with contents, or a directory if contents is nil
+	file := func(name string, contents []byte, mode fs.FileMode) FileInfo {
+		info := testFileInfo{name: name, size: int64(len(contents)), mode: mode, mtime: mtime}
+		return FileInfo{
+			FileInfo:      info,
+			NameInArchive: name,
+			Open: func() (fs.File, error) {
+				return fileInArchive{io.NopCloser(bytes.NewReader(contents)), info, nil}, nil
+			},
+		}
+	}
+	random := make([]byte, spillThreshold+1<<20) // spills to a temporary file
+	rand.New(rand.NewSource(1)).Read(random)
+	files := []FileInfo{
+		file("dir", nil, fs.ModeDir|0755),
+		file("dir/random.bin", random, 0644),
+		file("dir/empty.txt", []byte{}, 0644),
+		file("dir/ünïcödé.txt", []byte(strings.Repeat("unicode\n", 1000)), 0644),
+		file("link", []byte{}, fs.ModeSymlink|0777),
+	}
+	files[4].LinkTarget = "dir/empty.txt"
+	for i := 0; i < 50; i++ {
+		files = append(files, file(strings.Repeat("x", i+1)+".txt", bytes.Repeat([]byte{byte(i)}, i*1000), 0600))
+	}
+
+	for _, tc := range []struct {
+		name   string
+		format Zip
+	}{
+		{name: "Deflate", format: Zip{Compression: zip.Deflate}},
+		{name: "policy", format: Zip{CompressionPolicy: func(name string, size int64, sample []byte) ZipCompression {
+			if size%2 == 0 {
+				return ZipCompression{Method: zip.Deflate, Level: 1}
+			}
+			return SelectiveZipCompression(ZipCompression{Method: ZipMethodBzip2})(name, size, sample)
+		}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			serial := new(bytes.Buffer)
+			if err := tc.format.Archive(ctx, serial, files); err != nil {
+				t.Fatal(err)
+			}
+
+			format := tc.format
+			format.Concurrency = 4
+			parallel := new(bytes.Buffer)
+			if err := format.Archive(ctx, parallel, files); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(serial.Bytes(), parallel.Bytes()) {
+				t.Error("archive compressed in parallel differs from the one compressed serially")
+			}
+
+			async := new(bytes.Buffer)
+			jobs := make(chan ArchiveAsyncJob, len(files))
+			results := make([]chan error, len(files))
+			for i, file := range files {
+				results[i] = make(chan error, 1)
+				jobs <- ArchiveAsyncJob{File: file, Result: results[i]}
+			}
+			close(jobs)
+			if err := format.ArchiveAsync(ctx, async, jobs); err != nil {
+				t.Fatal(err)
+			}
+			for i, result := range results {
+				if err := <-result; err != nil {
+					t.Errorf("file %d: %v", i, err)
+				}
+			}
+			if !bytes.Equal(serial.Bytes(), async.Bytes()) {
+				t.Error("archive compressed asynchronously in parallel differs from the one compressed serially")
+			}
+		})
+	}
+
+	t.Run("Encrypted", func(t *testing.T) {
+		format := Zip{Compression: zip.Deflate, Password: "hunter2", Concurrency: 4}
+		buf := new(bytes.Buffer)
+		if err := format.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		err := format.Extract(ctx, bytes.NewReader(buf.Bytes()), func(_ context.Context, f FileInfo) error {
+			count++
+			if f.NameInArchive != "dir/random.bin" {
+				return nil
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			if got, err := io.ReadAll(rc); err != nil || !bytes.Equal(got, random) {
+				t.Errorf("extracted contents do not match (%v)", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(files) {
+			t.Errorf("expected %d files, got %d", len(files), count)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		broken := file("broken.txt", nil, 0644)
+		errBroken := errors.New("broken")
+		broken.Open = func() (fs.File, error) { return nil, errBroken }
+		withBroken := append([]FileInfo{broken}, files...)
+
+		// files that fail aren't written, rather than written partially
+		buf := new(bytes.Buffer)
+		if err := (Zip{ContinueOnError: true, Concurrency: 4}).Archive(ctx, buf, withBroken); !errors.Is(err, errBroken) {
+			t.Fatalf("expected the error of the broken file, got: %v", err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(zr.File) != len(files) || zr.File[0].Name != "dir/" {
+			t.Errorf("expected the %d files that didn't fail, got %d starting with %s", len(files), len(zr.File), zr.File[0].Name)
+		}
+
+		if err := (Zip{Concurrency: 4}).Archive(ctx, io.Discard, withBroken); !errors.Is(err, errBroken) {
+			t.Errorf("expected the error of the broken file, got: %v", err)
+		}
+	})
+}
+
+type testFileInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (fi testFileInfo) Name() string       { return fi.name }
+func (fi testFileInfo) Size() int64        { return fi.size }
+func (fi testFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi testFileInfo) ModTime() time.Time { return fi.mtime }
+func (fi testFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (testFileInfo) Sys() any              { return nil }
@@ -0,0 +1,1282 @@
+package archives
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// Zip compression method 98 is PPMd variant I, revision 1 (also known as
+// PPMd8), with a 2-byte header that gives the model order, the memory size,
+// and the method used to restore the model when its memory is exhausted.
+// This is a port of the decoder in the public domain LZMA SDK by Igor Pavlov,
+// which is based on the work of Dmitry Shkarin.
+
+// newZipPPMdReader returns a reader that decompresses the PPMd-compressed
+// data in r. If size is not negative, it is the size of the decompressed
+// data; otherwise the data must end with an end marker.
+func newZipPPMdReader(r io.Reader, size int64) io.ReadCloser {
+	return &zipPPMdReader{r: bufio.NewReader(r), size: size}
+}
+
+type zipPPMdReader struct {
+	r     *bufio.Reader
+	size  int64 // remaining, or negative if unknown
+	model *ppmd8
+	err   error
+}
+
+func (zr *zipPPMdReader) Read(p []byte) (int, error) {
+	if zr.err != nil {
+		return 0, zr.err
+	}
+	if zr.size == 0 {
+		return 0, io.EOF
+	}
+	if zr.model == nil {
+		if zr.model, zr.err = newZipPPMdModel(zr.r); zr.err != nil {
+			return 0, zr.err
+		}
+	}
+	var n int
+	for n < len(p) && zr.size != 0 {
+		sym := zr.model.decodeSymbol()
+		if zr.model.inputEOF {
+			zr.err = io.ErrUnexpectedEOF
+			return n, zr.err
+		}
+		if sym < 0 {
+			switch {
+			case sym == -1 && zr.size < 0:
+				zr.err = io.EOF
+			case sym == -1:
+				zr.err = io.ErrUnexpectedEOF
+			default:
+				zr.err = errPPMdCorrupt
+			}
+			return n, zr.err
+		}
+		p[n] = byte(sym)
+		n++
+		if zr.size > 0 {
+			zr.size--
+		}
+	}
+	return n, nil
+}
+
+func (zr *zipPPMdReader) Close() error { return nil }
+
+// newZipPPMdModel reads the zip PPMd header from r and returns a
+// model ready to decode the data that follows it.
+func newZipPPMdModel(r io.ByteReader) (*ppmd8, error) {
+	var header [2]byte
+	for i := range header {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		header[i] = b
+	}
+	params := binary.LittleEndian.Uint16(header[:])
+	order := uint32(params&0xf) + 1
+	memSize := (uint32(params>>4&0xff) + 1) << 20
+	restoreMethod := uint32(params >> 12)
+	if order < ppmdMinOrder || restoreMethod > ppmdRestoreCutOff {
+		return nil, fmt.Errorf("ppmd: order %d with restore method %d: %w", order, restoreMethod, zip.ErrAlgorithm)
+	}
+	if memSize > ppmdMaxMemSize {
+		// the model's memory is allocated up front, whatever the data's size
+		return nil, fmt.Errorf("ppmd: memory size of %d bytes is larger than the maximum of %d: %w", memSize, ppmdMaxMemSize, errPPMdCorrupt)
+	}
+
+	p := newPPMd8(memSize)
+	p.in = r
+	if !p.initRangeDecoder() {
+		return nil, errPPMdCorrupt
+	}
+	p.init(order, restoreMethod)
+	return p, nil
+}
+
+var errPPMdCorrupt = errors.New("ppmd: corrupt data")
+
+const (
+	ppmdMinOrder   = 2
+	ppmdMaxOrder   = 16
+	ppmdIntBits    = 7
+	ppmdPeriodBits = 7
+	ppmdBinScale   = 1 << (ppmdIntBits + ppmdPeriodBits)
+	ppmdNumIndexes = 4 + 4 + 4 + 26
+	ppmdUnitSize   = 12
+	ppmdMaxFreq    = 124
+	ppmdEmptyNode  = 0xffffffff
+
+	// the most memory a model may use, which is as much as
+	// 7-Zip uses at its highest compression level
+	ppmdMaxMemSize = 128 << 20
+
+	ppmdRestoreRestart = 0
+	ppmdRestoreCutOff  = 1
+
+	ppmdTop = 1 << 24
+	ppmdBot = 1 << 15
+)
+
+var (
+	ppmdExpEscape  = [16]byte{25, 14, 9, 7, 5, 5, 4, 4, 4, 3, 3, 3, 2, 2, 2, 2}
+	ppmdInitBinEsc = [8]uint16{0x3CDD, 0x1F3F, 0x59BF, 0x48F3, 0x64A1, 0x5ABC, 0x6632, 0x6051}
+)
+
+// ppmdSee is a secondary escape estimation context.
+type ppmdSee struct {
+	summ  uint16
+	shift byte
+	count byte
+}
+
+func (s *ppmdSee) update() {
+	if s.shift < ppmdPeriodBits {
+		s.count--
+		if s.count == 0 {
+			s.summ <<= 1
+			s.count = byte(3 << s.shift)
+			s.shift++
+		}
+	}
+}
+
+// ppmd8 is a PPMd variant I model with its range decoder.
+//
+// The model lives in mem, where it is addressed by offsets; offset 0 is
+// never used, so it means "none". Like in the original, mem holds text
+// (the recent input, growing up from the start), then units of 12 bytes
+// that hold either a context or up to two states each.
+//
+// A context is laid out as: NumStats (1 byte, the number of states minus
+// one), Flags (1), SummFreq (2), Stats (4, the offset of its states), and
+// Suffix (4). A context with a single state stores that state in place of
+// SummFreq and Stats. A state is laid out as: Symbol (1), Freq (1), and
+// Successor (4). Free blocks of units start with a node: Stamp (4), Next
+// (4), and NU (4, the number of units).
+type ppmd8 struct {
+	mem         []byte
+	size        uint32
+	alignOffset uint32
+
+	minContext, maxContext uint32
+	foundState             uint32
+	orderFall, initEsc     uint32
+	prevSuccess, maxOrder  uint32
+	runLength, initRL      int32
+
+	glueCount                        uint32
+	loUnit, hiUnit, text, unitsStart uint32
+	restoreMethod                    uint32
+	indx2Units                       [ppmdNumIndexes]byte
+	units2Indx                       [128]byte
+	freeList, stamps                 [ppmdNumIndexes]uint32
+	ns2BSIndx                        [256]byte
+	ns2Indx                          [260]byte
+	dummySee                         ppmdSee
+	see                              [24][32]ppmdSee
+	binSumm                          [25][64]uint16
+	rng, code, low                   uint32
+	in                               io.ByteReader
+	inputEOF                         bool
+}
+
+func newPPMd8(size uint32) *ppmd8 {
+	p := &ppmd8{size: size, alignOffset: 4 - size&3}
+	// the extra unit at the end is never used by the model
+	p.mem = make([]byte, p.alignOffset+size+ppmdUnitSize)
+
+	k := 0
+	for i := 0; i < ppmdNumIndexes; i++ {
+		step := 4
+		if i < 12 {
+			step = i>>2 + 1
+		}
+		for ; step > 0; step-- {
+			p.units2Indx[k] = byte(i)
+			k++
+		}
+		p.indx2Units[i] = byte(k)
+	}
+
+	p.ns2BSIndx[0] = 0 << 1
+	p.ns2BSIndx[1] = 1 << 1
+	for i := 2; i < 11; i++ {
+		p.ns2BSIndx[i] = 2 << 1
+	}
+	for i := 11; i < 256; i++ {
+		p.ns2BSIndx[i] = 3 << 1
+	}
+
+	for i := 0; i < 5; i++ {
+		p.ns2Indx[i] = byte(i)
+	}
+	m, step := 5, 1
+	for i := 5; i < 260; i++ {
+		p.ns2Indx[i] = byte(m)
+		step--
+		if step == 0 {
+			m++
+			step = m - 4
+		}
+	}
+	return p
+}
+
+func (p *ppmd8) init(maxOrder, restoreMethod uint32) {
+	p.maxOrder = maxOrder
+	p.restoreMethod = restoreMethod
+	p.restartModel()
+	p.dummySee = ppmdSee{shift: ppmdPeriodBits, count: 64}
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Accessors for the structures in mem.
+
+func (p *ppmd8) u16(off uint32) uint16       { return binary.LittleEndian.Uint16(p.mem[off:]) }
+func (p *ppmd8) setU16(off uint32, v uint16) { binary.LittleEndian.PutUint16(p.mem[off:], v) }
+func (p *ppmd8) u32(off uint32) uint32       { return binary.LittleEndian.Uint32(p.mem[off:]) }
+func (p *ppmd8) setU32(off uint32, v uint32) { binary.LittleEndian.PutUint32(p.mem[off:], v) }
+
+func (p *ppmd8) symbol(s uint32) byte            { return p.mem[s] }
+func (p *ppmd8) freq(s uint32) byte              { return p.mem[s+1] }
+func (p *ppmd8) setFreq(s uint32, f byte)        { p.mem[s+1] = f }
+func (p *ppmd8) successor(s uint32) uint32       { return p.u32(s + 2) }
+func (p *ppmd8) setSuccessor(s uint32, v uint32) { p.setU32(s+2, v) }
+func (p *ppmd8) copyState(dst, src uint32)       { copy(p.mem[dst:dst+6], p.mem[src:src+6]) }
+func (p *ppmd8) loadState(s uint32) (st [6]byte) { copy(st[:], p.mem[s:s+6]); return }
+func (p *ppmd8) storeState(s uint32, st [6]byte) { copy(p.mem[s:s+6], st[:]) }
+func (p *ppmd8) numStats(c uint32) uint32        { return uint32(p.mem[c]) }
+func (p *ppmd8) setNumStats(c uint32, n uint32)  { p.mem[c] = byte(n) }
+func (p *ppmd8) flags(c uint32) uint32           { return uint32(p.mem[c+1]) }
+func (p *ppmd8) setFlags(c uint32, f uint32)     { p.mem[c+1] = byte(f) }
+func (p *ppmd8) summFreq(c uint32) uint32        { return uint32(p.u16(c + 2)) }
+func (p *ppmd8) setSummFreq(c uint32, f uint32)  { p.setU16(c+2, uint16(f)) }
+func (p *ppmd8) stats(c uint32) uint32           { return p.u32(c + 4) }
+func (p *ppmd8) setStats(c uint32, s uint32)     { p.setU32(c+4, s) }
+func (p *ppmd8) suffix(c uint32) uint32          { return p.u32(c + 8) }
+func (p *ppmd8) setSuffix(c uint32, s uint32)    { p.setU32(c+8, s) }
+func oneState(c uint32) uint32                   { return c + 2 }
+func (p *ppmd8) i2u(indx uint32) uint32          { return uint32(p.indx2Units[indx]) }
+func (p *ppmd8) u2i(nu uint32) uint32            { return uint32(p.units2Indx[nu-1]) }
+func u2b(nu uint32) uint32                       { return nu * ppmdUnitSize }
+
+func (p *ppmd8) swapStates(a, b uint32) {
+	t := p.loadState(a)
+	p.copyState(a, b)
+	p.storeState(b, t)
+}
+
+// Memory allocation
+
+func (p *ppmd8) insertNode(node, indx uint32) {
+	p.setU32(node, ppmdEmptyNode)
+	p.setU32(node+4, p.freeList[indx])
+	p.setU32(node+8, p.i2u(indx))
+	p.freeList[indx] = node
+	p.stamps[indx]++
+}
+
+func (p *ppmd8) removeNode(indx uint32) uint32 {
+	node := p.freeList[indx]
+	p.freeList[indx] = p.u32(node + 4)
+	p.stamps[indx]--
+	return node
+}
+
+func (p *ppmd8) splitBlock(ptr, oldIndx, newIndx uint32) {
+	nu := p.i2u(oldIndx) - p.i2u(newIndx)
+	ptr += u2b(p.i2u(newIndx))
+	i := p.u2i(nu)
+	if p.i2u(i) != nu {
+		i--
+		k := p.i2u(i)
+		p.insertNode(ptr+u2b(k), nu-k-1)
+	}
+	p.insertNode(ptr, i)
+}
+
+func (p *ppmd8) glueFreeBlocks() {
+	var head uint32
+	var prev uint32 // the node whose Next links the list, or 0 for head
+	link := func(v uint32) {
+		if prev == 0 {
+			head = v
+		} else {
+			p.setU32(prev+4, v)
+		}
+	}
+
+	p.glueCount = 1 << 13
+	p.stamps = [ppmdNumIndexes]uint32{}
+
+	// the order-0 context is always at the top unit, so there is no need
+	// for a guard node at the end, but free blocks may reach up to LoUnit
+	if p.loUnit != p.hiUnit {
+		p.setU32(p.loUnit, 0)
+	}
+
+	// glue adjacent free blocks
+	for i := range p.freeList {
+		next := p.freeList[i]
+		p.freeList[i] = 0
+		for next != 0 {
+			node := next
+			if nu := p.u32(node + 8); nu != 0 {
+				link(next)
+				prev = node
+				for {
+					node2 := node + u2b(nu)
+					if p.u32(node2) != ppmdEmptyNode {
+						break
+					}
+					nu += p.u32(node2 + 8)
+					p.setU32(node+8, nu)
+					p.setU32(node2+8, 0)
+				}
+			}
+			next = p.u32(node + 4)
+		}
+	}
+	link(0)
+
+	// fill the lists of free blocks
+	for head != 0 {
+		node := head
+		head = p.u32(node + 4)
+		nu := p.u32(node + 8)
+		if nu == 0 {
+			continue
+		}
+		for ; nu > 128; nu, node = nu-128, node+u2b(128) {
+			p.insertNode(node, ppmdNumIndexes-1)
+		}
+		i := p.u2i(nu)
+		if p.i2u(i) != nu {
+			i--
+			k := p.i2u(i)
+			p.insertNode(node+u2b(k), nu-k-1)
+		}
+		p.insertNode(node, i)
+	}
+}
+
+func (p *ppmd8) allocUnitsRare(indx uint32) uint32 {
+	if p.glueCount == 0 {
+		p.glueFreeBlocks()
+		if p.freeList[indx] != 0 {
+			return p.removeNode(indx)
+		}
+	}
+	i := indx
+	for {
+		i++
+		if i == ppmdNumIndexes {
+			numBytes := u2b(p.i2u(indx))
+			p.glueCount--
+			if p.unitsStart-p.text > numBytes {
+				p.unitsStart -= numBytes
+				return p.unitsStart
+			}
+			return 0
+		}
+		if p.freeList[i] != 0 {
+			break
+		}
+	}
+	block := p.removeNode(i)
+	p.splitBlock(block, i, indx)
+	return block
+}
+
+func (p *ppmd8) allocUnits(indx uint32) uint32 {
+	if p.freeList[indx] != 0 {
+		return p.removeNode(indx)
+	}
+	numBytes := u2b(p.i2u(indx))
+	if numBytes <= p.hiUnit-p.loUnit {
+		block := p.loUnit
+		p.loUnit += numBytes
+		return block
+	}
+	return p.allocUnitsRare(indx)
+}
+
+func (p *ppmd8) copyUnits(dst, src, nu uint32) {
+	copy(p.mem[dst:dst+u2b(nu)], p.mem[src:src+u2b(nu)])
+}
+
+func (p *ppmd8) shrinkUnits(old, oldNU, newNU uint32) uint32 {
+	i0, i1 := p.u2i(oldNU), p.u2i(newNU)
+	if i0 == i1 {
+		return old
+	}
+	if p.freeList[i1] != 0 {
+		ptr := p.removeNode(i1)
+		p.copyUnits(ptr, old, newNU)
+		p.insertNode(old, i0)
+		return ptr
+	}
+	p.splitBlock(old, i0, i1)
+	return old
+}
+
+func (p *ppmd8) freeUnits(ptr, nu uint32) {
+	p.insertNode(ptr, p.u2i(nu))
+}
+
+func (p *ppmd8) specialFreeUnit(ptr uint32) {
+	if ptr != p.unitsStart {
+		p.insertNode(ptr, 0)
+	} else {
+		p.unitsStart += ppmdUnitSize
+	}
+}
+
+func (p *ppmd8) moveUnitsUp(old, nu uint32) uint32 {
+	indx := p.u2i(nu)
+	if old > p.unitsStart+16*1024 || old > p.freeList[indx] {
+		return old
+	}
+	ptr := p.removeNode(indx)
+	p.copyUnits(ptr, old, nu)
+	if old != p.unitsStart {
+		p.insertNode(old, indx)
+	} else {
+		p.unitsStart += u2b(p.i2u(indx))
+	}
+	return ptr
+}
+
+func (p *ppmd8) expandTextArea() {
+	var count [ppmdNumIndexes]uint32
+	if p.loUnit != p.hiUnit {
+		p.setU32(p.loUnit, 0)
+	}
+
+	node := p.unitsStart
+	for p.u32(node) == ppmdEmptyNode {
+		p.setU32(node, 0)
+		nu := p.u32(node + 8)
+		count[p.u2i(nu)]++
+		node += u2b(nu)
+	}
+	p.unitsStart = node
+
+	for i := range p.freeList {
+		var prev uint32 // the node whose Next links the list, or 0 for the list head
+		get := func() uint32 {
+			if prev == 0 {
+				return p.freeList[i]
+			}
+			return p.u32(prev + 4)
+		}
+		set := func(v uint32) {
+			if prev == 0 {
+				p.freeList[i] = v
+			} else {
+				p.setU32(prev+4, v)
+			}
+		}
+		for count[i] != 0 {
+			node := get()
+			for p.u32(node) == 0 {
+				set(p.u32(node + 4))
+				node = get()
+				p.stamps[i]--
+				count[i]--
+				if count[i] == 0 {
+					break
+				}
+			}
+			prev = node
+		}
+	}
+}
+
+func (p *ppmd8) usedMemory() uint32 {
+	var v uint32
+	for i := range p.stamps {
+		v += p.stamps[i] * p.i2u(uint32(i))
+	}
+	return p.size - (p.hiUnit - p.loUnit) - (p.unitsStart - p.text) - u2b(v)
+}
+
+// Model
+
+func (p *ppmd8) restartModel() {
+	p.freeList = [ppmdNumIndexes]uint32{}
+	p.stamps = [ppmdNumIndexes]uint32{}
+	p.text = p.alignOffset
+	p.hiUnit = p.text + p.size
+	p.loUnit = p.hiUnit - p.size/8/ppmdUnitSize*7*ppmdUnitSize
+	p.unitsStart = p.loUnit
+	p.glueCount = 0
+
+	p.orderFall = p.maxOrder
+	p.initRL = -int32(min(p.maxOrder, 12)) - 1
+	p.runLength = p.initRL
+	p.prevSuccess = 0
+
+	p.hiUnit -= ppmdUnitSize
+	p.minContext = p.hiUnit
+	p.maxContext = p.hiUnit
+	p.setSuffix(p.minContext, 0)
+	p.setNumStats(p.minContext, 255)
+	p.setFlags(p.minContext, 0)
+	p.setSummFreq(p.minContext, 256+1)
+	p.foundState = p.loUnit
+	p.setStats(p.minContext, p.foundState)
+	p.loUnit += u2b(256 / 2)
+	for i := uint32(0); i < 256; i++ {
+		s := p.foundState + i*6
+		p.mem[s] = byte(i)
+		p.setFreq(s, 1)
+		p.setSuccessor(s, 0)
+	}
+
+	for i, m := 0, 0; m < len(p.binSumm); m++ {
+		for int(p.ns2Indx[i]) == m {
+			i++
+		}
+		for k, esc := range ppmdInitBinEsc {
+			val := uint16(ppmdBinScale - uint32(esc)/uint32(i+1))
+			for r := 0; r < 64; r += 8 {
+				p.binSumm[m][k+r] = val
+			}
+		}
+	}
+	for i, m := 0, 0; m < len(p.see); m++ {
+		for int(p.ns2Indx[i+3]) == m+3 {
+			i++
+		}
+		for k := range p.see[m] {
+			p.see[m][k] = ppmdSee{summ: uint16((2*i + 5) << (ppmdPeriodBits - 4)), shift: ppmdPeriodBits - 4, count: 7}
+		}
+	}
+}
+
+func (p *ppmd8) refresh(ctx, oldNU, scale uint32) {
+	i := p.numStats(ctx)
+	s := p.shrinkUnits(p.stats(ctx), oldNU, (i+2)>>1)
+	p.setStats(ctx, s)
+	flags := p.flags(ctx)&(0x10+0x04*scale) + 0x08*b2u(p.symbol(s) >= 0x40)
+	escFreq := p.summFreq(ctx) - uint32(p.freq(s))
+	p.setFreq(s, byte((uint32(p.freq(s))+scale)>>scale))
+	sumFreq := uint32(p.freq(s))
+	for ; i > 0; i-- {
+		s += 6
+		escFreq -= uint32(p.freq(s))
+		p.setFreq(s, byte((uint32(p.freq(s))+scale)>>scale))
+		sumFreq += uint32(p.freq(s))
+		flags |= 0x08 * b2u(p.symbol(s) >= 0x40)
+	}
+	p.setSummFreq(ctx, sumFreq+(escFreq+scale)>>scale)
+	p.setFlags(ctx, flags)
+}
+
+func (p *ppmd8) cutOff(ctx, order uint32) uint32 {
+	if p.numStats(ctx) == 0 {
+		s := oneState(ctx)
+		if p.successor(s) >= p.unitsStart {
+			if order < p.maxOrder {
+				p.setSuccessor(s, p.cutOff(p.successor(s), order+1))
+			} else {
+				p.setSuccessor(s, 0)
+			}
+			if p.successor(s) != 0 || order <= 9 {
+				return ctx
+			}
+		}
+		p.specialFreeUnit(ctx)
+		return 0
+	}
+
+	tmp := (p.numStats(ctx) + 2) >> 1
+	p.setStats(ctx, p.moveUnitsUp(p.stats(ctx), tmp))
+	i := int(p.numStats(ctx))
+	for j := i; j >= 0; j-- {
+		s := p.stats(ctx) + uint32(j)*6
+		if p.successor(s) < p.unitsStart {
+			s2 := p.stats(ctx) + uint32(i)*6
+			i--
+			p.setSuccessor(s, 0)
+			p.swapStates(s, s2)
+		} else if order < p.maxOrder {
+			p.setSuccessor(s, p.cutOff(p.successor(s), order+1))
+		} else {
+			p.setSuccessor(s, 0)
+		}
+	}
+
+	if i != int(p.numStats(ctx)) && order != 0 {
+		p.setNumStats(ctx, uint32(i))
+		s := p.stats(ctx)
+		if i < 0 {
+			p.freeUnits(s, tmp)
+			p.specialFreeUnit(ctx)
+			return 0
+		}
+		if i == 0 {
+			p.setFlags(ctx, p.flags(ctx)&0x10+0x08*b2u(p.symbol(s) >= 0x40))
+			p.copyState(oneState(ctx), s)
+			p.freeUnits(s, tmp)
+			p.setFreq(oneState(ctx), byte((uint32(p.freq(oneState(ctx)))+11)>>3))
+		} else {
+			p.refresh(ctx, tmp, b2u(p.summFreq(ctx) > 16*uint32(i)))
+		}
+	}
+	return ctx
+}
+
+func (p *ppmd8) restoreModel(c1 uint32) {
+	p.text = p.alignOffset
+	c := p.maxContext
+	for ; c != c1; c = p.suffix(c) {
+		ns := p.numStats(c) - 1
+		p.setNumStats(c, ns)
+		if ns == 0 {
+			s := p.stats(c)
+			p.setFlags(c, p.flags(c)&0x10+0x08*b2u(p.symbol(s) >= 0x40))
+			p.copyState(oneState(c), s)
+			p.specialFreeUnit(s)
+			p.setFreq(oneState(c), byte((uint32(p.freq(oneState(c)))+11)>>3))
+		} else {
+			p.refresh(c, (ns+3)>>1, 0)
+		}
+	}
+	for ; c != p.minContext; c = p.suffix(c) {
+		if p.numStats(c) == 0 {
+			f := p.freq(oneState(c))
+			p.setFreq(oneState(c), f-f>>1)
+		} else {
+			p.setSummFreq(c, p.summFreq(c)+4)
+			if p.summFreq(c) > 128+4*p.numStats(c) {
+				p.refresh(c, (p.numStats(c)+2)>>1, 1)
+			}
+		}
+	}
+
+	if p.restoreMethod == ppmdRestoreRestart || p.usedMemory() < p.size>>1 {
+		p.restartModel()
+		return
+	}
+	for p.suffix(p.maxContext) != 0 {
+		p.maxContext = p.suffix(p.maxContext)
+	}
+	for {
+		p.cutOff(p.maxContext, 0)
+		p.expandTextArea()
+		if p.usedMemory() <= 3*(p.size>>2) {
+			break
+		}
+	}
+	p.glueCount = 0
+	p.orderFall = p.maxOrder
+}
+
+func (p *ppmd8) createSuccessors(skip bool, s1, c uint32) uint32 {
+	upBranch := p.successor(p.foundState)
+	fSymbol := p.symbol(p.foundState)
+	var ps [ppmdMaxOrder + 1]uint32
+	var numPs int
+	if !skip {
+		ps[numPs] = p.foundState
+		numPs++
+	}
+
+	for p.suffix(c) != 0 {
+		var s uint32
+		c = p.suffix(c)
+		if s1 != 0 {
+			s = s1
+			s1 = 0
+		} else if p.numStats(c) != 0 {
+			for s = p.stats(c); p.symbol(s) != fSymbol; s += 6 {
+			}
+			if p.freq(s) < ppmdMaxFreq-9 {
+				p.setFreq(s, p.freq(s)+1)
+				p.setSummFreq(c, p.summFreq(c)+1)
+			}
+		} else {
+			s = oneState(c)
+			p.setFreq(s, p.freq(s)+byte(b2u(p.numStats(p.suffix(c)) == 0)&b2u(p.freq(s) < 24)))
+		}
+		if succ := p.successor(s); succ != upBranch {
+			c = succ
+			if numPs == 0 {
+				return c
+			}
+			break
+		}
+		ps[numPs] = s
+		numPs++
+	}
+
+	upSymbol := p.mem[upBranch]
+	flags := 0x10*b2u(fSymbol >= 0x40) + 0x08*b2u(upSymbol >= 0x40)
+	var upFreq byte
+	if p.numStats(c) == 0 {
+		upFreq = p.freq(oneState(c))
+	} else {
+		s := p.stats(c)
+		for p.symbol(s) != upSymbol {
+			s += 6
+		}
+		cf := uint32(p.freq(s)) - 1
+		s0 := p.summFreq(c) - p.numStats(c) - cf
+		if 2*cf <= s0 {
+			upFreq = byte(1 + b2u(5*cf > s0))
+		} else {
+			upFreq = byte(1 + (cf+2*s0-3)/s0)
+		}
+	}
+
+	for numPs > 0 {
+		var c1 uint32
+		if p.hiUnit != p.loUnit {
+			p.hiUnit -= ppmdUnitSize
+			c1 = p.hiUnit
+		} else if p.freeList[0] != 0 {
+			c1 = p.removeNode(0)
+		} else if c1 = p.allocUnitsRare(0); c1 == 0 {
+			return 0
+		}
+		p.setNumStats(c1, 0)
+		p.setFlags(c1, flags)
+		p.mem[c1+2] = upSymbol
+		p.mem[c1+3] = upFreq
+		p.setSuccessor(oneState(c1), upBranch+1)
+		p.setSuffix(c1, c)
+		numPs--
+		p.setSuccessor(ps[numPs], c1)
+		c = c1
+	}
+	return c
+}
+
+func (p *ppmd8) reduceOrder(s1, c uint32) uint32 {
+	var s uint32
+	c1 := c
+	upBranch := p.text
+	fSymbol := p.symbol(p.foundState)
+	p.setSuccessor(p.foundState, upBranch)
+	p.orderFall++
+
+	for {
+		if s1 != 0 {
+			c = p.suffix(c)
+			s = s1
+			s1 = 0
+		} else {
+			if p.suffix(c) == 0 {
+				return c
+			}
+			c = p.suffix(c)
+			if p.numStats(c) != 0 {
+				for s = p.stats(c); p.symbol(s) != fSymbol; s += 6 {
+				}
+				if p.freq(s) < ppmdMaxFreq-9 {
+					p.setFreq(s, p.freq(s)+2)
+					p.setSummFreq(c, p.summFreq(c)+2)
+				}
+			} else {
+				s = oneState(c)
+				p.setFreq(s, p.freq(s)+byte(b2u(p.freq(s) < 32)))
+			}
+		}
+		if p.successor(s) != 0 {
+			break
+		}
+		p.setSuccessor(s, upBranch)
+		p.orderFall++
+	}
+
+	if p.successor(s) <= upBranch {
+		s2 := p.foundState
+		p.foundState = s
+		p.setSuccessor(s, p.createSuccessors(false, 0, c))
+		p.foundState = s2
+	}
+	if p.orderFall == 1 && c1 == p.maxContext {
+		p.setSuccessor(p.foundState, p.successor(s))
+		p.text--
+	}
+	return p.successor(s)
+}
+
+func (p *ppmd8) updateModel() {
+	fSuccessor := p.successor(p.foundState)
+	fFreq := uint32(p.freq(p.foundState))
+	fSymbol := p.symbol(p.foundState)
+	var s uint32
+
+	if fFreq < ppmdMaxFreq/4 && p.suffix(p.minContext) != 0 {
+		c := p.suffix(p.minContext)
+		if p.numStats(c) == 0 {
+			s = oneState(c)
+			if p.freq(s) < 32 {
+				p.setFreq(s, p.freq(s)+1)
+			}
+		} else {
+			s = p.stats(c)
+			if p.symbol(s) != fSymbol {
+				for {
+					s += 6
+					if p.symbol(s) == fSymbol {
+						break
+					}
+				}
+				if p.freq(s) >= p.freq(s-6) {
+					p.swapStates(s, s-6)
+					s -= 6
+				}
+			}
+			if p.freq(s) < ppmdMaxFreq-9 {
+				p.setFreq(s, p.freq(s)+2)
+				p.setSummFreq(c, p.summFreq(c)+2)
+			}
+		}
+	}
+
+	c := p.maxContext
+	if p.orderFall == 0 && fSuccessor != 0 {
+		cs := p.createSuccessors(true, s, p.minContext)
+		if cs == 0 {
+			p.setSuccessor(p.foundState, 0)
+			p.restoreModel(c)
+			return
+		}
+		p.setSuccessor(p.foundState, cs)
+		p.maxContext = cs
+		return
+	}
+
+	p.mem[p.text] = fSymbol
+	p.text++
+	successor := p.text
+	if p.text >= p.unitsStart {
+		p.restoreModel(c)
+		return
+	}
+
+	if fSuccessor == 0 {
+		cs := p.reduceOrder(s, p.minContext)
+		if cs == 0 {
+			p.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	} else if fSuccessor < p.unitsStart {
+		cs := p.createSuccessors(false, s, p.minContext)
+		if cs == 0 {
+			p.restoreModel(c)
+			return
+		}
+		fSuccessor = cs
+	}
+
+	p.orderFall--
+	if p.orderFall == 0 {
+		successor = fSuccessor
+		if p.maxContext != p.minContext {
+			p.text--
+		}
+	}
+
+	ns := p.numStats(p.minContext)
+	s0 := p.summFreq(p.minContext) - ns - fFreq
+	flag := 0x08 * b2u(fSymbol >= 0x40)
+	for ; c != p.minContext; c = p.suffix(c) {
+		ns1 := p.numStats(c)
+		if ns1 != 0 {
+			if ns1&1 != 0 {
+				// expand for one unit
+				oldNU := (ns1 + 1) >> 1
+				i := p.u2i(oldNU)
+				if i != p.u2i(oldNU+1) {
+					ptr := p.allocUnits(i + 1)
+					if ptr == 0 {
+						p.restoreModel(c)
+						return
+					}
+					old := p.stats(c)
+					p.copyUnits(ptr, old, oldNU)
+					p.insertNode(old, i)
+					p.setStats(c, ptr)
+				}
+			}
+			p.setSummFreq(c, p.summFreq(c)+b2u(3*ns1+1 < ns))
+		} else {
+			s2 := p.allocUnits(0)
+			if s2 == 0 {
+				p.restoreModel(c)
+				return
+			}
+			p.copyState(s2, oneState(c))
+			p.setStats(c, s2)
+			if f := p.freq(s2); f < ppmdMaxFreq/4-1 {
+				p.setFreq(s2, f<<1)
+			} else {
+				p.setFreq(s2, ppmdMaxFreq-4)
+			}
+			p.setSummFreq(c, uint32(p.freq(s2))+p.initEsc+b2u(ns > 2))
+		}
+
+		cf := 2 * fFreq * (p.summFreq(c) + 6)
+		sf := s0 + p.summFreq(c)
+		if cf < 6*sf {
+			cf = 1 + b2u(cf > sf) + b2u(cf >= 4*sf)
+			p.setSummFreq(c, p.summFreq(c)+4)
+		} else {
+			cf = 4 + b2u(cf > 9*sf) + b2u(cf > 12*sf) + b2u(cf > 15*sf)
+			p.setSummFreq(c, p.summFreq(c)+cf)
+		}
+		s2 := p.stats(c) + (ns1+1)*6
+		p.setSuccessor(s2, successor)
+		p.mem[s2] = fSymbol
+		p.setFreq(s2, byte(cf))
+		p.setFlags(c, p.flags(c)|flag)
+		p.setNumStats(c, ns1+1)
+	}
+	p.maxContext = fSuccessor
+	p.minContext = fSuccessor
+}
+
+func (p *ppmd8) rescale() {
+	mc := p.minContext
+	stats := p.stats(mc)
+	s := p.foundState
+
+	// move the found state to the front
+	if s != stats {
+		tmp := p.loadState(s)
+		for ; s != stats; s -= 6 {
+			p.copyState(s, s-6)
+		}
+		p.storeState(s, tmp)
+	}
+
+	escFreq := p.summFreq(mc) - uint32(p.freq(s))
+	p.setFreq(s, p.freq(s)+4)
+	adder := b2u(p.orderFall != 0)
+	p.setFreq(s, byte((uint32(p.freq(s))+adder)>>1))
+	sumFreq := uint32(p.freq(s))
+
+	// halve the frequencies, keeping the states sorted by frequency
+	i := p.numStats(mc)
+	for ; i > 0; i-- {
+		s += 6
+		escFreq -= uint32(p.freq(s))
+		p.setFreq(s, byte((uint32(p.freq(s))+adder)>>1))
+		sumFreq += uint32(p.freq(s))
+		if p.freq(s) > p.freq(s-6) {
+			tmp := p.loadState(s)
+			s1 := s
+			for {
+				p.copyState(s1, s1-6)
+				s1 -= 6
+				if s1 == stats || tmp[1] <= p.freq(s1-6) {
+					break
+				}
+			}
+			p.storeState(s1, tmp)
+		}
+	}
+
+	// remove states whose frequency dropped to zero
+	if p.freq(s) == 0 {
+		numStats := p.numStats(mc)
+		for {
+			i++
+			s -= 6
+			if p.freq(s) != 0 {
+				break
+			}
+		}
+		escFreq += i
+		p.setNumStats(mc, numStats-i)
+		if p.numStats(mc) == 0 {
+			tmp := p.loadState(stats)
+			f := byte((2*uint32(tmp[1]) + escFreq - 1) / escFreq)
+			if f > ppmdMaxFreq/3 {
+				f = ppmdMaxFreq / 3
+			}
+			tmp[1] = f
+			p.insertNode(stats, p.u2i((numStats+2)>>1))
+			p.setFlags(mc, p.flags(mc)&0x10+0x08*b2u(tmp[0] >= 0x40))
+			p.foundState = oneState(mc)
+			p.storeState(p.foundState, tmp)
+			return
+		}
+		n0, n1 := (numStats+2)>>1, (p.numStats(mc)+2)>>1
+		if n0 != n1 {
+			p.setStats(mc, p.shrinkUnits(stats, n0, n1))
+		}
+		s = p.stats(mc)
+		flags := p.flags(mc)&^0x08 | 0x08*b2u(p.symbol(s) >= 0x40)
+		for i = p.numStats(mc); i > 0; i-- {
+			s += 6
+			flags |= 0x08 * b2u(p.symbol(s) >= 0x40)
+		}
+		p.setFlags(mc, flags)
+	}
+
+	p.setSummFreq(mc, sumFreq+escFreq-escFreq>>1)
+	p.setFlags(mc, p.flags(mc)|0x04)
+	p.foundState = p.stats(mc)
+}
+
+func (p *ppmd8) nextContext() {
+	c := p.successor(p.foundState)
+	if p.orderFall == 0 && c >= p.unitsStart {
+		p.minContext = c
+		p.maxContext = c
+		return
+	}
+	p.updateModel()
+	p.minContext = p.maxContext
+}
+
+func (p *ppmd8) update1() {
+	s := p.foundState
+	p.setFreq(s, p.freq(s)+4)
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	if p.freq(s) > p.freq(s-6) {
+		p.swapStates(s, s-6)
+		s -= 6
+		p.foundState = s
+		if p.freq(s) > ppmdMaxFreq {
+			p.rescale()
+		}
+	}
+	p.nextContext()
+}
+
+func (p *ppmd8) update1First() {
+	p.prevSuccess = b2u(2*uint32(p.freq(p.foundState)) >= p.summFreq(p.minContext))
+	p.runLength += int32(p.prevSuccess)
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	p.setFreq(p.foundState, p.freq(p.foundState)+4)
+	if p.freq(p.foundState) > ppmdMaxFreq {
+		p.rescale()
+	}
+	p.nextContext()
+}
+
+func (p *ppmd8) updateBin() {
+	p.setFreq(p.foundState, p.freq(p.foundState)+byte(b2u(p.freq(p.foundState) < 196)))
+	p.prevSuccess = 1
+	p.runLength++
+	p.nextContext()
+}
+
+func (p *ppmd8) update2() {
+	p.setSummFreq(p.minContext, p.summFreq(p.minContext)+4)
+	p.setFreq(p.foundState, p.freq(p.foundState)+4)
+	if p.freq(p.foundState) > ppmdMaxFreq {
+		p.rescale()
+	}
+	p.runLength = p.initRL
+	p.updateModel()
+	p.minContext = p.maxContext
+}
+
+func (p *ppmd8) makeEscFreq(numMasked uint32) (*ppmdSee, uint32) {
+	mc := p.minContext
+	ns := p.numStats(mc)
+	if ns == 0xff {
+		return &p.dummySee, 1
+	}
+	see := &p.see[p.ns2Indx[ns+2]-3][b2u(p.summFreq(mc) > 11*(ns+1))+
+		2*b2u(2*ns < p.numStats(p.suffix(mc))+numMasked)+
+		p.flags(mc)]
+	r := uint32(see.summ >> see.shift)
+	see.summ -= uint16(r)
+	return see, r + b2u(r == 0)
+}
+
+func (p *ppmd8) binSummFor() *uint16 {
+	mc := p.minContext
+	return &p.binSumm[p.ns2Indx[p.freq(oneState(mc))-1]][uint32(p.ns2BSIndx[p.numStats(p.suffix(mc))])+
+		p.prevSuccess+p.flags(mc)+uint32(p.runLength>>26)&0x20]
+}
+
+// Range decoder
+
+func (p *ppmd8) readByte() uint32 {
+	b, err := p.in.ReadByte()
+	if err != nil {
+		p.inputEOF = true
+		return 0
+	}
+	return uint32(b)
+}
+
+func (p *ppmd8) initRangeDecoder() bool {
+	p.low = 0
+	p.rng = 0xffffffff
+	p.code = 0
+	for i := 0; i < 4; i++ {
+		p.code = p.code<<8 | p.readByte()
+	}
+	return p.code < 0xffffffff && !p.inputEOF
+}
+
+func (p *ppmd8) threshold(total uint32) uint32 {
+	p.rng /= total
+	return p.code / p.rng
+}
+
+func (p *ppmd8) decode(start, size uint32) {
+	start *= p.rng
+	p.low += start
+	p.code -= start
+	p.rng *= size
+	for {
+		if p.low^(p.low+p.rng) >= ppmdTop {
+			if p.rng >= ppmdBot {
+				break
+			}
+			p.rng = -p.low & (ppmdBot - 1)
+		}
+		p.code = p.code<<8 | p.readByte()
+		p.rng <<= 8
+		p.low <<= 8
+	}
+}
+
+// decodeSymbol decodes the next byte. It returns -1 at the end marker,
+// or -2 if the data is invalid.
+func (p *ppmd8) decodeSymbol() int {
+	var charMask [256]bool // whether a symbol is masked
+	if p.numStats(p.minContext) != 0 {
+		s := p.stats(p.minContext)
+		count := p.threshold(p.summFreq(p.minContext))
+		hiCnt := uint32(p.freq(s))
+		if count < hiCnt {
+			p.decode(0, hiCnt)
+			p.foundState = s
+			symbol := p.symbol(s)
+			p.update1First()
+			return int(symbol)
+		}
+		p.prevSuccess = 0
+		for i := p.numStats(p.minContext); i > 0; i-- {
+			s += 6
+			hiCnt += uint32(p.freq(s))
+			if hiCnt > count {
+				p.decode(hiCnt-uint32(p.freq(s)), uint32(p.freq(s)))
+				p.foundState = s
+				symbol := p.symbol(s)
+				p.update1()
+				return int(symbol)
+			}
+		}
+		if count >= p.summFreq(p.minContext) {
+			return -2
+		}
+		p.decode(hiCnt, p.summFreq(p.minContext)-hiCnt)
+		for i, stats := uint32(0), p.stats(p.minContext); i <= p.numStats(p.minContext); i++ {
+			charMask[p.symbol(stats+i*6)] = true
+		}
+	} else {
+		prob := p.binSummFor()
+		p.rng >>= 14
+		if p.code/p.rng < uint32(*prob) {
+			p.decode(0, uint32(*prob))
+			*prob = *prob + 1<<ppmdIntBits - ppmdMean(*prob)
+			p.foundState = oneState(p.minContext)
+			symbol := p.symbol(p.foundState)
+			p.updateBin()
+			return int(symbol)
+		}
+		p.decode(uint32(*prob), ppmdBinScale-uint32(*prob))
+		*prob -= ppmdMean(*prob)
+		p.initEsc = uint32(ppmdExpEscape[*prob>>10])
+		charMask[p.symbol(oneState(p.minContext))] = true
+		p.prevSuccess = 0
+	}
+
+	var ps [256]uint32
+	for {
+		numMasked := p.numStats(p.minContext)
+		for {
+			p.orderFall++
+			if p.suffix(p.minContext) == 0 {
+				return -1
+			}
+			p.minContext = p.suffix(p.minContext)
+			if p.numStats(p.minContext) != numMasked {
+				break
+			}
+		}
+
+		var hiCnt uint32
+		s := p.stats(p.minContext)
+		num := p.numStats(p.minContext) - numMasked
+		var n uint32
+		for n != num {
+			if !charMask[p.symbol(s)] {
+				hiCnt += uint32(p.freq(s))
+				ps[n] = s
+				n++
+			}
+			s += 6
+		}
+
+		see, freqSum := p.makeEscFreq(numMasked)
+		freqSum += hiCnt
+		count := p.threshold(freqSum)
+
+		if count < hiCnt {
+			k := 0
+			hiCnt = 0
+			for {
+				hiCnt += uint32(p.freq(ps[k]))
+				if hiCnt > count {
+					break
+				}
+				k++
+			}
+			s = ps[k]
+			p.decode(hiCnt-uint32(p.freq(s)), uint32(p.freq(s)))
+			see.update()
+			p.foundState = s
+			symbol := p.symbol(s)
+			p.update2()
+			return int(symbol)
+		}
+		if count >= freqSum {
+			return -2
+		}
+		p.decode(hiCnt, freqSum-hiCnt)
+		see.summ += uint16(freqSum)
+		for _, s := range ps[:n] {
+			charMask[p.symbol(s)] = true
+		}
+	}
+}
+
+// ppmdMean returns the adjustment of a binary context's probability.
+func ppmdMean(prob uint16) uint16 {
+	return (prob + 1<<(ppmdPeriodBits-2)) >> ppmdPeriodBits
+}
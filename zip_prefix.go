@@ -0,0 +1,215 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// Zip archives are read from their end, where the end of central directory
+// record points to the central directory, which points to each entry, so
+// they can be preceded by other data: the executable stub of a self-extracting
+// archive, the header of a Chrome extension (.crx), or the shell script that
+// runs a jar. The offsets recorded in such archives are usually relative to
+// the start of the archive, but tools like "zip -A" adjust them to be relative
+// to the start of the file.
+
+// ZipPrefix locates the data that precedes a zip archive in its input.
+type ZipPrefix struct {
+	// Length is the length of the data before the archive,
+	// which starts with its first local file header.
+	Length int64
+
+	// Offset is added to the offsets recorded in the archive to get
+	// positions in the input. It is usually Length, or 0 if the offsets
+	// were adjusted to account for the prefix.
+	Offset int64
+}
+
+// Prefix locates the data that precedes the zip archive in sourceArchive,
+// which is size bytes long. An archive without a prefix has a ZipPrefix of
+// zero Length. The prefix can be read with io.NewSectionReader(sourceArchive,
+// 0, prefix.Length), and the archive without it starts at prefix.Length.
+func (Zip) Prefix(sourceArchive io.ReaderAt, size int64) (ZipPrefix, error) {
+	dir, err := findZipDirectory(sourceArchive, size)
+	if err != nil {
+		return ZipPrefix{}, err
+	}
+
+	// the archive starts with the local header of its first entry, which
+	// is usually but not necessarily the first in the central directory
+	buf := make([]byte, dir.size)
+	if _, err := sourceArchive.ReadAt(buf, dir.start); err != nil {
+		return ZipPrefix{}, fmt.Errorf("reading central directory: %w", noEOF(err))
+	}
+	first := dir.start - dir.baseOffset // if there are no entries
+	for len(buf) >= zipCentralHeaderLen && binary.LittleEndian.Uint32(buf) == zipCentralHeaderSig {
+		nameLen := int(binary.LittleEndian.Uint16(buf[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(buf[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(buf[32:]))
+		recordLen := zipCentralHeaderLen + nameLen + extraLen + commentLen
+		if len(buf) < recordLen {
+			break
+		}
+		offset := int64(binary.LittleEndian.Uint32(buf[42:]))
+		if offset == 0xffffffff {
+			offset = zip64HeaderOffset(buf[20:28], buf[zipCentralHeaderLen+nameLen:zipCentralHeaderLen+nameLen+extraLen])
+		}
+		if offset >= 0 {
+			first = min(first, offset)
+		}
+		buf = buf[recordLen:]
+	}
+	return ZipPrefix{Length: dir.baseOffset + first, Offset: dir.baseOffset}, nil
+}
+
+// prefix is like Prefix, but quickly returns an empty prefix for
+// archives that start with a local file header, which most do.
+func (z Zip) prefix(sourceArchive io.ReaderAt, size int64) (ZipPrefix, error) {
+	var sig [4]byte
+	if _, err := sourceArchive.ReadAt(sig[:], 0); err == nil && binary.LittleEndian.Uint32(sig[:]) == zipLocalHeaderSig {
+		return ZipPrefix{}, nil
+	}
+	return z.Prefix(sourceArchive, size)
+}
+
+// zip64HeaderOffset returns the local header offset from the ZIP64 extra
+// field of a central directory header whose 32-bit offset doesn't fit, or
+// -1 if it can't be found. sizes are the compressed and uncompressed
+// sizes in the header, which precede the offset in the extra field if
+// they don't fit either.
+func zip64HeaderOffset(sizes, extra []byte) int64 {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraZip64 {
+			continue
+		}
+		for i := 0; i < 2; i++ {
+			if binary.LittleEndian.Uint32(sizes[4*i:]) == 0xffffffff && len(field) >= 8 {
+				field = field[8:]
+			}
+		}
+		if len(field) >= 8 {
+			return int64(binary.LittleEndian.Uint64(field))
+		}
+	}
+	return -1
+}
+
+// zipDirectory is the location of the central directory of a zip archive.
+type zipDirectory struct {
+	start, size int64 // in the input
+	baseOffset  int64 // added to the offsets recorded in the archive
+}
+
+// findZipDirectory finds the central directory of the zip archive that ends
+// r, which is size bytes long, from the end of central directory record. The
+// record must end the input, with the archive comment, if any; and where the
+// central directory starts there must be a central directory header, unless
+// it is empty. It returns zip.ErrFormat if there is no such archive.
+func findZipDirectory(r io.ReaderAt, size int64) (zipDirectory, error) {
+	tailLen := min(size, zipEndLen+0xffff) // the record and the longest comment
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil {
+		return zipDirectory{}, noEOF(err)
+	}
+
+	// the signature could also appear in the comment, so search from the end
+	// for a record whose comment ends the input
+	end := -1
+	for i := len(tail) - zipEndLen; i >= 0; i-- {
+		i = bytes.LastIndex(tail[:i+4], zipEndSigBytes)
+		if i < 0 {
+			break
+		}
+		if commentLen := int(binary.LittleEndian.Uint16(tail[i+20:])); i+zipEndLen+commentLen == len(tail) {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return zipDirectory{}, fmt.Errorf("no end of central directory record: %w", zip.ErrFormat)
+	}
+	endOffset := size - tailLen + int64(end)
+	record := tail[end:]
+	dirSize := int64(binary.LittleEndian.Uint32(record[12:]))
+	dirOffset := int64(binary.LittleEndian.Uint32(record[16:]))
+
+	// ZIP64 archives have another end record, which is followed by a locator
+	// and then the usual record, whose values that don't fit are all ones
+	if binary.LittleEndian.Uint16(record[10:]) == 0xffff || dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		var buf [zipEnd64Len + zipEnd64LocatorLen]byte
+		if endOffset >= int64(len(buf)) {
+			if _, err := r.ReadAt(buf[:], endOffset-int64(len(buf))); err != nil {
+				return zipDirectory{}, noEOF(err)
+			}
+		}
+		if binary.LittleEndian.Uint32(buf[:]) == zipEnd64Sig &&
+			binary.LittleEndian.Uint32(buf[zipEnd64Len:]) == zipEnd64LocatorSig {
+			endOffset -= int64(len(buf))
+			dirSize = int64(binary.LittleEndian.Uint64(buf[40:]))
+			dirOffset = int64(binary.LittleEndian.Uint64(buf[48:]))
+		}
+	}
+
+	// the central directory normally ends where the end record starts; the
+	// offset it is recorded at tells the base offset, unless that's not where
+	// a central directory is, in which case recorded offsets may be absolute
+	dir := zipDirectory{start: endOffset - dirSize, size: dirSize}
+	dir.baseOffset = dir.start - dirOffset
+	for _, base := range []int64{dir.baseOffset, 0} {
+		dir.baseOffset, dir.start = base, base+dirOffset
+		if base < 0 || dir.start < 0 || dir.start+dirSize > endOffset {
+			continue
+		}
+		if dirSize == 0 {
+			return dir, nil
+		}
+		var sig [4]byte
+		if _, err := r.ReadAt(sig[:], dir.start); err != nil {
+			return zipDirectory{}, noEOF(err)
+		}
+		if binary.LittleEndian.Uint32(sig[:]) == zipCentralHeaderSig {
+			return dir, nil
+		}
+	}
+	return zipDirectory{}, fmt.Errorf("central directory not found: %w", zip.ErrFormat)
+}
+
+// withZipPrefix returns a copy of ctx that carries prefix, for Zip.Extract
+// to pass to the FileHandler.
+func withZipPrefix(ctx context.Context, prefix ZipPrefix) context.Context {
+	return context.WithValue(ctx, zipPrefixKey{}, prefix)
+}
+
+// ZipPrefixFromContext returns the location of the data that precedes the zip
+// archive being extracted, given the context passed to a FileHandler by
+// Zip.Extract. It returns false if the archive is not being read through its
+// central directory, as when it's streamed.
+func ZipPrefixFromContext(ctx context.Context) (ZipPrefix, bool) {
+	prefix, ok := ctx.Value(zipPrefixKey{}).(ZipPrefix)
+	return prefix, ok
+}
+
+type zipPrefixKey struct{}
+
+const (
+	zipEnd64LocatorSig = 0x07064b50
+
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zipEnd64Len         = 56
+	zipEnd64LocatorLen  = 20
+)
+
+var zipEndSigBytes = []byte("PK\x05\x06")
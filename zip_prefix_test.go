@@ -0,0 +1,123 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipPrefix(t *testing.T) {
+	ctx := context.Background()
+	stub := []byte("#!/bin/sh\nexec java -jar \"$0\" \"$@\"\n")
+
+	// zipWithPrefix returns a zip archive preceded by stub; if adjusted, its
+	// offsets are relative to the start of the stub, as "zip -A" makes them
+	zipWithPrefix := func(t *testing.T, adjusted bool) []byte {
+		t.Helper()
+		buf := bytes.NewBuffer(bytes.Clone(stub))
+		zw := zip.NewWriter(buf)
+		if adjusted {
+			zw.SetOffset(int64(len(stub)))
+		}
+		for _, name := range []string{"META-INF/MANIFEST.MF", "Main.class"} {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, "contents of "+name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for _, tc := range []struct {
+		name     string
+		adjusted bool
+		offset   int64
+	}{
+		{name: "relative offsets", offset: int64(len(stub))},
+		{name: "adjusted offsets", adjusted: true, offset: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archive := zipWithPrefix(t, tc.adjusted)
+			want := ZipPrefix{Length: int64(len(stub)), Offset: tc.offset}
+
+			format, _, err := Identify(ctx, "", bytes.NewReader(archive))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := format.(Zip); !ok {
+				t.Fatalf("expected Zip, got %T", format)
+			}
+
+			prefix, err := Zip{}.Prefix(bytes.NewReader(archive), int64(len(archive)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prefix != want {
+				t.Errorf("expected prefix %+v, got %+v", want, prefix)
+			}
+
+			var names []string
+			err = Zip{}.Extract(ctx, bytes.NewReader(archive), func(ctx context.Context, f FileInfo) error {
+				names = append(names, f.NameInArchive)
+				if prefix, ok := ZipPrefixFromContext(ctx); !ok || prefix != want {
+					t.Errorf("expected prefix %+v in context, got %+v (%t)", want, prefix, ok)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(names) != 2 {
+				t.Errorf("expected 2 files, got %v", names)
+			}
+
+			fsys := &ArchiveFS{Stream: io.NewSectionReader(bytes.NewReader(archive), 0, int64(len(archive))), Format: Zip{}}
+			if prefix, err := fsys.ZipPrefix(); err != nil || prefix != want {
+				t.Errorf("expected prefix %+v from ArchiveFS, got %+v (%v)", want, prefix, err)
+			}
+			contents, err := fs.ReadFile(fsys, "Main.class")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(contents) != "contents of Main.class" {
+				t.Errorf("unexpected contents: %q", contents)
+			}
+		})
+	}
+
+	t.Run("no prefix", func(t *testing.T) {
+		archive := zipWithPrefix(t, false)[len(stub):]
+		prefix, err := Zip{}.Prefix(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != (ZipPrefix{}) {
+			t.Errorf("expected no prefix, got %+v", prefix)
+		}
+	})
+
+	t.Run("not a zip", func(t *testing.T) {
+		// the end of central directory record must end the input
+		archive := append(zipWithPrefix(t, false), make([]byte, 1024)...)
+		mr, err := Zip{}.Match(ctx, "", bytes.NewReader(archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mr.ByStream {
+			t.Error("expected no match")
+		}
+		if _, err := (Zip{}).Prefix(bytes.NewReader(archive), int64(len(archive))); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
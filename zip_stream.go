@@ -0,0 +1,553 @@
+package archives
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// Zip archives are normally read through the central directory at their
+// end, which requires seeking. They can also be read from start to end by
+// walking the local file header that precedes the data of each entry. This
+// works on streams, and on archives whose central directory is damaged or
+// missing, but local headers lack some information: notably file modes, so
+// entries are regular files or, if their name ends with a slash,
+// directories. And entries written to a stream have a data descriptor
+// after their data (general purpose flag bit 3), so their sizes and CRC are
+// only known once their data has been read. Where that data ends is found
+// by decompressing it, for methods that end on their own, or else by
+// scanning for a data descriptor with a signature and the size of the data
+// that precedes it.
+
+// ExtractStream extracts files from the zip archive in sourceArchive like
+// Extract, but reads it sequentially through its local file headers rather
+// than its central directory, so sourceArchive need not be seekable; Extract
+// uses it when it isn't. It can also recover the entries of archives whose
+// central directory is damaged or missing.
+//
+// Local file headers do not record file modes or link targets, so entries
+// are extracted as regular files or directories. The sizes of entries that
+// were written to a stream are unknown until they have been read.
+func (z Zip) ExtractStream(ctx context.Context, sourceArchive io.Reader, handleFile FileHandler) error {
+	sr := newZipStreamReader(sourceArchive)
+	handleFile = z.Limits.wrapHandler(handleFile)
+	ctx, p := startProgress(ctx)
+	handleFile = p.wrapHandler(handleFile)
+	errs := z.entryErrorHandler()
+	textDec := newTextDecoder(z.TextEncoding, z.DetectTextEncoding)
+
+	// important to initialize to non-nil, empty value due to how fileIsIncluded works
+	skipDirs := skipList{}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err // honor context cancellation
+		}
+
+		entry, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := errs.handle(ctx, "", classifyError("", err)); err != nil {
+				return err
+			}
+			// the reader can't find the next header after a bad one,
+			// so skipping it means skipping the rest of the archive
+			break
+		}
+
+		if entry.hdr.NonUTF8 {
+			textDec.see(entry.hdr.Name)
+		}
+		textEnc := decodeZipText(textDec, &entry.hdr)
+		if fileIsIncluded(skipDirs, entry.hdr.Name) {
+			continue
+		}
+
+		file := z.streamFileInfo(ctx, entry)
+		file.TextEncoding = textEnc
+		err = handleFile(ctx, file)
+		if errors.Is(err, fs.SkipAll) {
+			break
+		} else if errors.Is(err, fs.SkipDir) && file.IsDir() {
+			skipDirs.add(entry.hdr.Name)
+		} else if err != nil {
+			if err := errs.handle(ctx, entry.hdr.Name, err); err != nil {
+				return fmt.Errorf("handling file: %s: %w", entry.hdr.Name, err)
+			}
+		}
+	}
+
+	return errs.err()
+}
+
+// streamFileInfo returns a FileInfo for the entry read from a stream.
+// Reads from the opened file honor ctx cancellation.
+func (z Zip) streamFileInfo(ctx context.Context, entry *zipStreamEntry) FileInfo {
+	extra := parseZipExtraFields(entry.hdr.Extra)
+	if !extra.modTime.IsZero() {
+		entry.hdr.Modified = extra.modTime // as the zip package sets it from the central directory
+	}
+	info := entry.hdr.FileInfo() // refers to the header, which is completed at the end of the data
+	file := FileInfo{
+		FileInfo:      info,
+		Header:        entry.hdr,
+		NameInArchive: entry.hdr.Name,
+		Encrypted:     entry.hdr.Flags&zipFlagEncrypted != 0,
+		Open: func() (fs.File, error) {
+			rc, err := entry.open(z.Password)
+			if err != nil {
+				return nil, classifyError(entry.hdr.Name, err)
+			}
+			return fileInArchive{newClassifyingReader(rc, entry.hdr.Name), info, ctx}, nil
+		},
+	}
+	extra.fill(&file)
+	return file
+}
+
+// zipStreamArchiveReader implements ArchiveReader for zip archives
+// read sequentially.
+type zipStreamArchiveReader struct {
+	ctx     context.Context
+	z       Zip
+	sr      *zipStreamReader
+	textDec *textDecoder
+	limits  *limitState
+}
+
+func (r *zipStreamArchiveReader) Next() (FileInfo, error) {
+	if err := r.ctx.Err(); err != nil {
+		return FileInfo{}, err // honor context cancellation
+	}
+	entry, err := r.sr.next()
+	if err != nil {
+		return FileInfo{}, classifyError("", err)
+	}
+	if entry.hdr.NonUTF8 {
+		r.textDec.see(entry.hdr.Name)
+	}
+	textEnc := decodeZipText(r.textDec, &entry.hdr)
+	file := r.z.streamFileInfo(r.ctx, entry)
+	file.TextEncoding = textEnc
+	return r.limits.entry(file)
+}
+
+func (*zipStreamArchiveReader) Close() error { return nil }
+
+// zipStreamReader reads the entries of a zip archive sequentially.
+type zipStreamReader struct {
+	br      *bufio.Reader
+	entry   *zipStreamEntry // the current entry
+	started bool
+}
+
+func newZipStreamReader(r io.Reader) *zipStreamReader {
+	return &zipStreamReader{br: bufio.NewReaderSize(r, zipStreamBufferSize)}
+}
+
+// next skips what is left of the current entry and reads the local file
+// header of the next one. It returns io.EOF at the central directory or,
+// if there is none, at the end of the input.
+func (sr *zipStreamReader) next() (*zipStreamEntry, error) {
+	if sr.entry != nil {
+		err := sr.entry.finish()
+		sr.entry = nil
+		if err != nil {
+			return nil, fmt.Errorf("skipping to next entry: %w", err)
+		}
+	}
+
+	for {
+		sig, err := sr.br.Peek(4)
+		if len(sig) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		switch binary.LittleEndian.Uint32(sig) {
+		case zipLocalHeaderSig:
+		case zipDataDescriptorSig, zipSpannedSig:
+			// archives that were split may start with a marker
+			if !sr.started {
+				sr.started = true
+				if _, err := sr.br.Discard(4); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, fmt.Errorf("unexpected data descriptor: %w", zip.ErrFormat)
+		case zipCentralHeaderSig, zipEndSig, zipEnd64Sig:
+			return nil, io.EOF
+		default:
+			return nil, fmt.Errorf("invalid local file header signature: %w", zip.ErrFormat)
+		}
+		break
+	}
+	sr.started = true
+
+	hdr, err := readZipLocalHeader(sr.br)
+	if err != nil {
+		return nil, err
+	}
+	sr.entry = newZipStreamEntry(sr.br, hdr)
+	return sr.entry, nil
+}
+
+// readZipLocalHeader reads a local file header, including its signature.
+func readZipLocalHeader(br *bufio.Reader) (zip.FileHeader, error) {
+	var buf [zipLocalHeaderLen]byte
+	if _, err := io.ReadFull(br, buf[:]); err != nil {
+		return zip.FileHeader{}, noEOF(err)
+	}
+	nameLen := int(binary.LittleEndian.Uint16(buf[26:]))
+	extraLen := int(binary.LittleEndian.Uint16(buf[28:]))
+	nameAndExtra := make([]byte, nameLen+extraLen)
+	if _, err := io.ReadFull(br, nameAndExtra); err != nil {
+		return zip.FileHeader{}, noEOF(err)
+	}
+
+	hdr := zip.FileHeader{
+		Name:               string(nameAndExtra[:nameLen]),
+		ReaderVersion:      binary.LittleEndian.Uint16(buf[4:]),
+		Flags:              binary.LittleEndian.Uint16(buf[6:]),
+		Method:             binary.LittleEndian.Uint16(buf[8:]),
+		ModifiedTime:       binary.LittleEndian.Uint16(buf[10:]),
+		ModifiedDate:       binary.LittleEndian.Uint16(buf[12:]),
+		CRC32:              binary.LittleEndian.Uint32(buf[14:]),
+		CompressedSize64:   uint64(binary.LittleEndian.Uint32(buf[18:])),
+		UncompressedSize64: uint64(binary.LittleEndian.Uint32(buf[22:])),
+		Extra:              nameAndExtra[nameLen:],
+	}
+	// like the zip package, only trust the UTF-8 flag if the name isn't ASCII
+	hdr.NonUTF8 = !utf8.ValidString(hdr.Name) || hdr.Flags&zipFlagUTF8 == 0 && !isASCII(hdr.Name)
+
+	// sizes that don't fit are in the ZIP64 extra field, which in a local
+	// header has both the uncompressed and compressed size
+	needUSize := hdr.UncompressedSize64 == 0xffffffff
+	needCSize := hdr.CompressedSize64 == 0xffffffff
+	for extra := hdr.Extra; len(extra) >= 4; {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraZip64 {
+			continue
+		}
+		if needUSize && len(field) >= 8 {
+			hdr.UncompressedSize64 = binary.LittleEndian.Uint64(field)
+			field = field[8:]
+		}
+		if needCSize && len(field) >= 8 {
+			hdr.CompressedSize64 = binary.LittleEndian.Uint64(field)
+		}
+	}
+	hdr.CompressedSize = uint32(min(hdr.CompressedSize64, 0xffffffff))
+	hdr.UncompressedSize = uint32(min(hdr.UncompressedSize64, 0xffffffff))
+	return hdr, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// zipStreamEntry is an entry of a zip archive being read sequentially.
+type zipStreamEntry struct {
+	hdr zip.FileHeader
+	br  *bufio.Reader
+
+	// raw is the entry's raw data, which is either limited to the
+	// size in the header, or read through counter or scanner
+	raw     io.Reader
+	counter *zipCountingReader
+	scanner *zipDescriptorScanner
+
+	dec      io.ReadCloser // the decompressed data, once opened
+	checkCRC bool
+	hash     hash.Hash32
+
+	finished  bool
+	finishErr error
+}
+
+func newZipStreamEntry(br *bufio.Reader, hdr zip.FileHeader) *zipStreamEntry {
+	e := &zipStreamEntry{hdr: hdr, br: br, hash: crc32.NewIEEE()}
+	switch {
+	case hdr.Flags&zipFlagDataDescriptor == 0:
+		e.raw = io.LimitReader(br, int64(hdr.CompressedSize64))
+	case hdr.Flags&zipFlagEncrypted == 0 && (hdr.Method == zip.Deflate || hdr.Method == ZipMethodDeflate64):
+		// these decompressors read no further than the end of the data
+		// from an io.ByteReader, after which comes the data descriptor
+		e.counter = &zipCountingReader{br: br}
+		e.raw = e.counter
+	default:
+		e.scanner = &zipDescriptorScanner{br: br}
+		e.raw = e.scanner
+	}
+	return e
+}
+
+// open returns a reader of the entry's decompressed data, which checks
+// its CRC at the end.
+func (e *zipStreamEntry) open(password string) (io.ReadCloser, error) {
+	if e.dec == nil {
+		dec, checkCRC, err := e.decompressor(password)
+		if err != nil {
+			return nil, err
+		}
+		e.dec, e.checkCRC = dec, checkCRC
+	}
+	return zipStreamFile{e}, nil
+}
+
+func (e *zipStreamEntry) decompressor(password string) (io.ReadCloser, bool, error) {
+	rawSize, size := int64(-1), int64(-1)
+	if e.hdr.Flags&zipFlagDataDescriptor == 0 {
+		rawSize, size = int64(e.hdr.CompressedSize64), int64(e.hdr.UncompressedSize64)
+	}
+	if e.hdr.Flags&zipFlagEncrypted != 0 {
+		return decryptZipEntry(&e.hdr, e.raw, rawSize, size, password)
+	}
+	decompress := zipEntryDecompressor(e.hdr.Method, size)
+	if decompress == nil {
+		return nil, false, zip.ErrAlgorithm
+	}
+	rc, err := decompressZipEntry(e.hdr.Name, decompress, e.raw)
+	return rc, true, err
+}
+
+// finish skips the rest of the entry's data and reads its data
+// descriptor, if it has one, into its header.
+func (e *zipStreamEntry) finish() error {
+	if e.finished {
+		return e.finishErr
+	}
+	e.finished = true
+
+	if e.counter != nil {
+		// the end of the data can only be found by decompressing it
+		if e.dec == nil {
+			e.dec, _, e.finishErr = e.decompressor("")
+			if e.finishErr != nil {
+				return e.finishErr
+			}
+		}
+		if _, e.finishErr = io.Copy(io.Discard, e.dec); e.finishErr != nil {
+			return e.finishErr
+		}
+		b, err := e.br.Peek(zipDataDescriptorMaxLen + 2)
+		desc, n := parseZipDataDescriptor(b, e.counter.n, false)
+		if n == 0 {
+			if err != nil {
+				e.finishErr = noEOF(err)
+			} else {
+				e.finishErr = fmt.Errorf("invalid data descriptor: %w", zip.ErrFormat)
+			}
+			return e.finishErr
+		}
+		_, e.finishErr = e.br.Discard(n)
+		desc.apply(&e.hdr)
+		return e.finishErr
+	}
+
+	if _, e.finishErr = io.Copy(io.Discard, e.raw); e.finishErr != nil {
+		return e.finishErr
+	}
+	if e.scanner != nil {
+		e.scanner.desc.apply(&e.hdr)
+	}
+	return nil
+}
+
+// zipStreamFile reads the decompressed data of an entry read sequentially.
+type zipStreamFile struct{ e *zipStreamEntry }
+
+func (f zipStreamFile) Read(p []byte) (int, error) {
+	n, err := f.e.dec.Read(p)
+	f.e.hash.Write(p[:n])
+	if err == io.EOF {
+		if err := f.e.finish(); err != nil {
+			return n, err
+		}
+		if f.e.checkCRC && f.e.hash.Sum32() != f.e.hdr.CRC32 {
+			return n, zip.ErrChecksum
+		}
+	}
+	return n, err
+}
+
+func (zipStreamFile) Close() error { return nil }
+
+// zipCountingReader counts the bytes read from br.
+type zipCountingReader struct {
+	br *bufio.Reader
+	n  int64
+}
+
+func (cr *zipCountingReader) Read(p []byte) (int, error) {
+	n, err := cr.br.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *zipCountingReader) ReadByte() (byte, error) {
+	b, err := cr.br.ReadByte()
+	if err == nil {
+		cr.n++
+	}
+	return b, err
+}
+
+// zipDescriptorScanner reads the raw data of an entry of unknown size
+// from br, up to the data descriptor that follows it, which must have
+// a signature.
+type zipDescriptorScanner struct {
+	br   *bufio.Reader
+	n    int64 // bytes read
+	desc *zipDataDescriptor
+}
+
+func (s *zipDescriptorScanner) Read(p []byte) (int, error) {
+	if s.desc != nil {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf, err := s.br.Peek(zipStreamBufferSize)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	// until the input ends, leave enough bytes at the end of the buffer
+	// to tell whether a signature there starts the data descriptor
+	end := len(buf)
+	if err == nil {
+		end -= zipDataDescriptorMaxLen + 2
+	}
+	for i := 0; i < len(buf); i++ {
+		j := bytes.Index(buf[i:], zipDataDescriptorSigBytes)
+		if j < 0 {
+			break
+		}
+		if i += j; i >= end {
+			break
+		}
+		desc, n := parseZipDataDescriptor(buf[i:], s.n+int64(i), true)
+		if n == 0 {
+			continue
+		}
+		if i == 0 {
+			s.desc = &desc
+			if _, err := s.br.Discard(n); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		end = i
+		break
+	}
+
+	n := copy(p, buf[:max(end, 0)])
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF // no data descriptor before the end of the input
+	}
+	s.n += int64(n)
+	_, err = s.br.Discard(n)
+	return n, err
+}
+
+// zipDataDescriptor is the data descriptor that follows
+// the data of an entry written to a stream.
+type zipDataDescriptor struct {
+	crc                      uint32
+	compressedSize, origSize uint64
+}
+
+// apply sets the fields of hdr that the data descriptor holds.
+func (d *zipDataDescriptor) apply(hdr *zip.FileHeader) {
+	hdr.CRC32 = d.crc
+	hdr.CompressedSize64 = d.compressedSize
+	hdr.UncompressedSize64 = d.origSize
+	hdr.CompressedSize = uint32(min(d.compressedSize, 0xffffffff))
+	hdr.UncompressedSize = uint32(min(d.origSize, 0xffffffff))
+}
+
+// parseZipDataDescriptor parses the data descriptor at the start of b,
+// which follows compressedSize bytes of data, and returns it and its
+// length, or 0 if b does not start with one. The signature is optional
+// unless signed is true. Sizes have 4 bytes, or 8 in ZIP64 archives,
+// which is told by what follows the descriptor in b: another header,
+// or nothing.
+func parseZipDataDescriptor(b []byte, compressedSize int64, signed bool) (zipDataDescriptor, int) {
+	start := 0
+	if len(b) >= 4 && binary.LittleEndian.Uint32(b) == zipDataDescriptorSig {
+		start = 4
+	} else if signed {
+		return zipDataDescriptor{}, 0
+	}
+
+	var fallback zipDataDescriptor
+	var fallbackLen int
+	for _, sizeLen := range []int{4, 8} {
+		n := start + 4 + 2*sizeLen
+		if len(b) < n {
+			break
+		}
+		desc := zipDataDescriptor{crc: binary.LittleEndian.Uint32(b[start:])}
+		if sizeLen == 4 {
+			desc.compressedSize = uint64(binary.LittleEndian.Uint32(b[start+4:]))
+			desc.origSize = uint64(binary.LittleEndian.Uint32(b[start+8:]))
+		} else {
+			desc.compressedSize = binary.LittleEndian.Uint64(b[start+4:])
+			desc.origSize = binary.LittleEndian.Uint64(b[start+12:])
+		}
+		if desc.compressedSize != uint64(compressedSize) {
+			continue
+		}
+		if len(b) == n || bytes.HasPrefix(b[n:], []byte("PK")) {
+			return desc, n
+		}
+		if fallbackLen == 0 {
+			fallback, fallbackLen = desc, n
+		}
+	}
+	return fallback, fallbackLen
+}
+
+const (
+	zipLocalHeaderSig    = 0x04034b50
+	zipCentralHeaderSig  = 0x02014b50
+	zipEndSig            = 0x06054b50
+	zipEnd64Sig          = 0x06064b50
+	zipDataDescriptorSig = 0x08074b50
+	zipSpannedSig        = 0x30304b50 // "PK00", written by some tools in place of zipDataDescriptorSig
+
+	zipLocalHeaderLen       = 30
+	zipDataDescriptorMaxLen = 24 // with signature and 8-byte sizes
+	zipStreamBufferSize     = 64 << 10
+
+	zipExtraZip64 = 0x0001
+)
+
+var zipDataDescriptorSigBytes = []byte("PK\x07\x08")
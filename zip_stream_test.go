@@ -0,0 +1,233 @@
+package archives
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+func TestZipExtractStream(t *testing.T) {
+	ctx := context.Background()
+
+	random := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	// stored data that looks like it ends with a data descriptor
+	fakeDescriptor := append([]byte("before PK\x07\x08"), make([]byte, 16)...)
+	fakeDescriptor = append(fakeDescriptor, "PK after"...)
+
+	// non-seekable input is read sequentially
+	extract := func(t *testing.T, format Zip, archive []byte) (map[string][]byte, error) {
+		t.Helper()
+		got := make(map[string][]byte)
+		err := format.Extract(ctx, struct{ io.Reader }{bytes.NewReader(archive)}, func(_ context.Context, f FileInfo) error {
+			if f.IsDir() {
+				got[f.NameInArchive] = nil
+				return nil
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			if got[f.NameInArchive], err = io.ReadAll(rc); err != nil {
+				return err
+			}
+			if f.Size() != int64(len(got[f.NameInArchive])) {
+				t.Errorf("%s: expected size %d after reading, got %d", f.NameInArchive, len(got[f.NameInArchive]), f.Size())
+			}
+			return nil
+		})
+		return got, err
+	}
+	check := func(t *testing.T, got, want map[string][]byte) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("expected %d files, got %d", len(want), len(got))
+		}
+		for name, contents := range want {
+			if c, ok := got[name]; !ok || !bytes.Equal(c, contents) {
+				t.Errorf("%s: extracted contents do not match", name)
+			}
+		}
+	}
+
+	// the zip package writes a data descriptor after each file
+	want := map[string][]byte{
+		"dir/":            nil,
+		"dir/deflate.txt": bytes.Repeat([]byte("compress me\n"), 1000),
+		"dir/stored.bin":  random,
+		"fake.txt":        fakeDescriptor,
+		"empty.txt":       {},
+	}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"dir/", "dir/deflate.txt", "dir/stored.bin", "fake.txt", "empty.txt"} {
+		method := zip.Deflate
+		if name == "dir/stored.bin" || name == "fake.txt" {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(want[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	t.Run("Data descriptors", func(t *testing.T) {
+		got, err := extract(t, Zip{}, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, want)
+	})
+
+	t.Run("Missing central directory", func(t *testing.T) {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := zr.File[len(zr.File)-1]
+		offset, err := last.DataOffset()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// cut the archive after the data descriptor of the last file
+		got, err := extract(t, Zip{}, archive[:offset+int64(last.CompressedSize64)+16])
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, want)
+
+		// without its data descriptor, the end of the last file can't be found
+		if _, err := extract(t, Zip{}, archive[:offset+int64(last.CompressedSize64)]); !errors.Is(err, ErrTruncated) {
+			t.Errorf("expected ErrTruncated, got: %v", err)
+		}
+	})
+
+	t.Run("Corrupt data", func(t *testing.T) {
+		corrupt := bytes.Clone(archive)
+		i := bytes.Index(corrupt, random[:16])
+		corrupt[i+100] ^= 0xff
+		if _, err := extract(t, Zip{}, corrupt); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got: %v", err)
+		}
+	})
+
+	t.Run("Corrupt start of data", func(t *testing.T) {
+		// the xz and bzip2 decompressors read the start of the data right
+		// away, whether or not the size of the data is known
+		garbage := []byte("this is not compressed data")
+		for _, method := range []uint16{ZipMethodXz, ZipMethodBzip2} {
+			for _, flags := range []uint16{0, zipFlagDataDescriptor} {
+				buf := new(bytes.Buffer)
+				zw := zip.NewWriter(buf)
+				w, err := zw.CreateRaw(&zip.FileHeader{
+					Name:               "file.txt",
+					Method:             method,
+					Flags:              flags,
+					CompressedSize64:   uint64(len(garbage)),
+					UncompressedSize64: 100,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(garbage); err != nil {
+					t.Fatal(err)
+				}
+				if err := zw.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := extract(t, Zip{}, buf.Bytes()); !errors.Is(err, ErrCorrupt) {
+					t.Errorf("method %d, flags %#x: expected ErrCorrupt, got: %v", method, flags, err)
+				}
+			}
+		}
+	})
+
+	t.Run("Skipped files", func(t *testing.T) {
+		var names []string
+		r, err := Zip{}.OpenArchiveReader(ctx, struct{ io.Reader }{bytes.NewReader(archive)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			f, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, f.NameInArchive)
+		}
+		if len(names) != len(want) {
+			t.Errorf("expected %d files, got %v", len(want), names)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		format := Zip{Password: "hunter2"}
+		buf := new(bytes.Buffer)
+		files := []FileInfo{memFile("a.txt", want["dir/deflate.txt"]), memFile("b.bin", random)}
+		if err := format.Archive(ctx, buf, files); err != nil {
+			t.Fatal(err)
+		}
+		got, err := extract(t, format, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, map[string][]byte{"a.txt": want["dir/deflate.txt"], "b.bin": random})
+
+		// created by Info-ZIP with: zip -P hunter2 -0 zipcrypto.zip plain.txt && zip -P hunter2 -9 zipcrypto.zip big.txt
+		archive, err := os.ReadFile("testdata/zipcrypto.zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = extract(t, format, archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, map[string][]byte{
+			"plain.txt": []byte("hello, world\n"),
+			"big.txt":   bytes.Repeat([]byte("repeat me\n"), 500),
+		})
+	})
+
+	t.Run("ZIP64", func(t *testing.T) {
+		contents := []byte("hello, world\n")
+		// a local file header with its sizes in the ZIP64 extra field
+		hdr := make([]byte, zipLocalHeaderLen, zipLocalHeaderLen+len("zip64.txt")+20)
+		binary.LittleEndian.PutUint32(hdr, zipLocalHeaderSig)
+		binary.LittleEndian.PutUint16(hdr[4:], 45)
+		binary.LittleEndian.PutUint32(hdr[14:], crc32.ChecksumIEEE(contents))
+		binary.LittleEndian.PutUint32(hdr[18:], 0xffffffff)
+		binary.LittleEndian.PutUint32(hdr[22:], 0xffffffff)
+		binary.LittleEndian.PutUint16(hdr[26:], uint16(len("zip64.txt")))
+		binary.LittleEndian.PutUint16(hdr[28:], 20)
+		hdr = append(hdr, "zip64.txt"...)
+		hdr = binary.LittleEndian.AppendUint16(hdr, zipExtraZip64)
+		hdr = binary.LittleEndian.AppendUint16(hdr, 16)
+		hdr = binary.LittleEndian.AppendUint64(hdr, uint64(len(contents)))
+		hdr = binary.LittleEndian.AppendUint64(hdr, uint64(len(contents)))
+
+		got, err := extract(t, Zip{}, append(hdr, contents...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, got, map[string][]byte{"zip64.txt": contents})
+	})
+}
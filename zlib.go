@@ -9,7 +9,8 @@ import (
 )
 
 func init() {
-	RegisterFormat(Zlib{})
+	// the zlib header is only two bytes, which is prone to false positives
+	RegisterFormatWithPriority(Zlib{}, -5)
 }
 
 // Zlib facilitates zlib compression.
@@ -49,7 +50,11 @@ func (zz Zlib) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 }
 
 func (Zlib) OpenReader(r io.Reader) (io.ReadCloser, error) {
-	return zlib.NewReader(r)
+	zlR, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, classifyError("", err)
+	}
+	return newClassifyingReader(zlR, ""), nil
 }
 
 func isValidZlibHeader(first, second byte) bool {
@@ -3,6 +3,9 @@ package archives
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -47,9 +50,9 @@ func (zs Zstd) OpenWriter(w io.Writer) (io.WriteCloser, error) {
 func (zs Zstd) OpenReader(r io.Reader) (io.ReadCloser, error) {
 	zr, err := zstd.NewReader(r, zs.DecoderOptions...)
 	if err != nil {
-		return nil, err
+		return nil, classifyError("", err)
 	}
-	return errorCloser{zr}, nil
+	return newClassifyingReader(errorCloser{zr}, ""), nil
 }
 
 type errorCloser struct {
@@ -61,6 +64,79 @@ func (ec errorCloser) Close() error {
 	return nil
 }
 
+// Streams returns the offsets of the Zstandard frames in r, including
+// skippable frames, which are found by reading the headers of their blocks.
+func (Zstd) Streams(r io.ReaderAt, size int64) ([]int64, error) {
+	r = io.NewSectionReader(r, 0, size)
+	var offsets []int64
+	for offset := int64(0); offset < size; {
+		end, err := zstdFrameEnd(r, offset)
+		if err != nil {
+			return nil, fmt.Errorf("frame at %d: %w", offset, err)
+		}
+		offsets = append(offsets, offset)
+		offset = end
+	}
+	return offsets, nil
+}
+
+// zstdFrameEnd returns the end of the frame that starts at offset in r.
+func zstdFrameEnd(r io.ReaderAt, offset int64) (int64, error) {
+	var hdr [5]byte // magic and frame header descriptor
+	if _, err := r.ReadAt(hdr[:], offset); err != nil {
+		return 0, noEOF(err)
+	}
+	if binary.LittleEndian.Uint32(hdr[:])&^0xf == skippableFrameMagic {
+		var size [4]byte
+		if _, err := r.ReadAt(size[:], offset+4); err != nil {
+			return 0, noEOF(err)
+		}
+		return offset + 8 + int64(binary.LittleEndian.Uint32(size[:])), nil
+	}
+	if !bytes.Equal(hdr[:4], zstdHeader) {
+		return 0, errors.New("not a Zstandard frame")
+	}
+
+	desc := hdr[4]
+	singleSegment := desc&0x20 != 0
+	pos := offset + 5
+	if !singleSegment {
+		pos++ // window descriptor
+	}
+	pos += [...]int64{0, 1, 2, 4}[desc&0x3] // dictionary ID
+	if desc>>6 == 0 && singleSegment {
+		pos++ // content size
+	} else {
+		pos += [...]int64{0, 2, 4, 8}[desc>>6]
+	}
+	for {
+		var block [3]byte
+		if _, err := r.ReadAt(block[:], pos); err != nil {
+			return 0, noEOF(err)
+		}
+		header := uint32(block[0]) | uint32(block[1])<<8 | uint32(block[2])<<16
+		size := int64(header >> 3)
+		switch header >> 1 & 0x3 {
+		case 1:
+			size = 1 // RLE blocks repeat one byte
+		case 3:
+			return 0, fmt.Errorf("block at %d: reserved block type", pos)
+		}
+		pos += 3 + size
+		if header&1 != 0 {
+			break // last block
+		}
+	}
+	if desc&0x4 != 0 {
+		pos += 4 // content checksum
+	}
+	return pos, nil
+}
+
 // magic number at the beginning of Zstandard files
 // https://github.com/facebook/zstd/blob/6211bfee5ec24dc825c11751c33aa31d618b5f10/doc/zstd_compression_format.md
 var zstdHeader = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// the magic number of skippable frames, which Zstandard and LZ4 share, with
+// any value in its lowest 4 bits
+const skippableFrameMagic = 0x184d2a50